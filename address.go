@@ -0,0 +1,210 @@
+package modbus
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AddressStyle is the notation of register addresses used by Format.
+type AddressStyle string
+
+const (
+	AddressModicon  AddressStyle = "modicon"  // 40001, 6 digits (400001) for numbers above 9999
+	AddressModicon6 AddressStyle = "modicon6" // 400001
+	AddressModiconX AddressStyle = "modiconx" // 4x0001
+	AddressIEC      AddressStyle = "iec"      // %MW100
+	AddressPrefix   AddressStyle = "prefix"   // HR:99
+)
+
+// AddressNotation converts between the table with zero-based protocol address and the notations
+// of documentation and field engineers: "40001", "400001", "4x0001", "%MW100", "HR:99".
+// Modicon prefixes are 0 coils, 1 discrete inputs, 3 input registers, 4 holding registers.
+// Numbers of Modicon and prefix notations are one-based (40001 is address 0), unless ZeroBased is set
+// for devices documenting protocol addresses. IEC 61131 addresses (%M, %I, %IW, %MW) are always zero-based.
+type AddressNotation struct {
+	ZeroBased bool
+	Style     AddressStyle
+	// DefaultTable is used for plain numbers of 4 digits or less, they are rejected if zero.
+	DefaultTable Table
+}
+
+// ParseAddress parses the one-based address notation.
+func ParseAddress(s string) (Table, uint16, error) {
+	return AddressNotation{}.Parse(s)
+}
+
+// FormatAddress formats the address in one-based Modicon notation.
+func FormatAddress(table Table, address uint16) string {
+	return AddressNotation{}.Format(table, address)
+}
+
+// Parse converts the notation to the table and the zero-based address.
+func (an AddressNotation) Parse(s string) (table Table, address uint16, err error) {
+	text := strings.ToUpper(strings.TrimSpace(s))
+	switch {
+	case strings.HasPrefix(text, "%"):
+		return parseIECAddress(s, text[1:])
+	case strings.Contains(text, ":"):
+		prefix, number, _ := strings.Cut(text, ":")
+		if table, err = parseTablePrefix(prefix); err != nil {
+			return 0, 0, fmt.Errorf("modbus: invalid address '%v': %w", s, err)
+		}
+		address, err = an.number(s, number, 0xFFFF)
+		return
+	case len(text) > 2 && (text[1] == 'X'):
+		if table, err = modiconTable(text[0]); err != nil {
+			return 0, 0, fmt.Errorf("modbus: invalid address '%v': %w", s, err)
+		}
+		address, err = an.number(s, text[2:], 0xFFFF)
+		return
+	case len(text) == 5 || len(text) == 6:
+		if table, err = modiconTable(text[0]); err != nil {
+			return 0, 0, fmt.Errorf("modbus: invalid address '%v': %w", s, err)
+		}
+		max := 9999
+		if len(text) == 6 {
+			max = 0xFFFF
+		}
+		address, err = an.number(s, text[1:], max)
+		return
+	case len(text) > 0 && len(text) < 5:
+		if an.DefaultTable == 0 {
+			return 0, 0, fmt.Errorf("modbus: address '%v' has no table", s)
+		}
+		address, err = an.number(s, text, 0xFFFF)
+		return an.DefaultTable, address, err
+	}
+	return 0, 0, fmt.Errorf("modbus: invalid address '%v'", s)
+}
+
+// number converts the number of the notation to the zero-based address,
+// max is the largest zero-based number of the notation.
+func (an AddressNotation) number(s, text string, max int) (uint16, error) {
+	n, err := strconv.ParseUint(text, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("modbus: invalid address '%v'", s)
+	}
+	if !an.ZeroBased {
+		if n == 0 {
+			return 0, fmt.Errorf("modbus: address '%v' is zero in one-based notation", s)
+		}
+		n--
+	}
+	if n > uint64(max) {
+		return 0, fmt.Errorf("modbus: address '%v' is out of range", s)
+	}
+	return uint16(n), nil
+}
+
+// Format converts the table and the zero-based address to the notation of the style.
+func (an AddressNotation) Format(table Table, address uint16) string {
+	n := int(address)
+	if !an.ZeroBased {
+		n++
+	}
+	switch an.Style {
+	case AddressModicon6:
+		return fmt.Sprintf("%c%05d", modiconDigit(table), n)
+	case AddressModiconX:
+		return fmt.Sprintf("%cx%04d", modiconDigit(table), n)
+	case AddressIEC:
+		return fmt.Sprintf("%%%v%d", iecPrefix(table), address)
+	case AddressPrefix:
+		return fmt.Sprintf("%v:%d", tablePrefix(table), n)
+	}
+	if n > 9999 {
+		return fmt.Sprintf("%c%05d", modiconDigit(table), n)
+	}
+	return fmt.Sprintf("%c%04d", modiconDigit(table), n)
+}
+
+func modiconTable(digit byte) (Table, error) {
+	switch digit {
+	case '0':
+		return TableCoils, nil
+	case '1':
+		return TableDiscreteInputs, nil
+	case '3':
+		return TableInputRegisters, nil
+	case '4':
+		return TableHoldingRegisters, nil
+	}
+	return 0, fmt.Errorf("unknown table digit '%c'", digit)
+}
+
+func modiconDigit(table Table) byte {
+	switch table {
+	case TableCoils:
+		return '0'
+	case TableDiscreteInputs:
+		return '1'
+	case TableInputRegisters:
+		return '3'
+	}
+	return '4'
+}
+
+func parseTablePrefix(prefix string) (Table, error) {
+	switch strings.TrimSpace(prefix) {
+	case "C", "CO":
+		return TableCoils, nil
+	case "DI":
+		return TableDiscreteInputs, nil
+	case "HR":
+		return TableHoldingRegisters, nil
+	case "IR":
+		return TableInputRegisters, nil
+	}
+	return ParseTable(prefix)
+}
+
+func tablePrefix(table Table) string {
+	switch table {
+	case TableCoils:
+		return "CO"
+	case TableDiscreteInputs:
+		return "DI"
+	case TableInputRegisters:
+		return "IR"
+	}
+	return "HR"
+}
+
+// parseIECAddress parses IEC 61131 located variables without '%':
+// M, MX, QX coils; I, IX discrete inputs; IW input registers; MW, QW holding registers.
+func parseIECAddress(s, text string) (table Table, address uint16, err error) {
+	i := strings.IndexAny(text, "0123456789")
+	if i < 0 {
+		return 0, 0, fmt.Errorf("modbus: invalid address '%v'", s)
+	}
+	switch text[:i] {
+	case "M", "MX", "QX", "Q":
+		table = TableCoils
+	case "I", "IX":
+		table = TableDiscreteInputs
+	case "IW":
+		table = TableInputRegisters
+	case "MW", "QW":
+		table = TableHoldingRegisters
+	default:
+		return 0, 0, fmt.Errorf("modbus: unknown IEC area '%v' of address '%v'", text[:i], s)
+	}
+	n, err := strconv.ParseUint(text[i:], 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("modbus: invalid address '%v'", s)
+	}
+	return table, uint16(n), nil
+}
+
+func iecPrefix(table Table) string {
+	switch table {
+	case TableCoils:
+		return "M"
+	case TableDiscreteInputs:
+		return "I"
+	case TableInputRegisters:
+		return "IW"
+	}
+	return "MW"
+}
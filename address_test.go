@@ -0,0 +1,83 @@
+package modbus
+
+import "testing"
+
+func TestAddressRoundTrip(t *testing.T) {
+	styles := []AddressStyle{"", AddressModicon, AddressModicon6, AddressModiconX, AddressIEC, AddressPrefix}
+	tables := []Table{TableCoils, TableDiscreteInputs, TableHoldingRegisters, TableInputRegisters}
+	for _, style := range styles {
+		for _, zeroBased := range []bool{false, true} {
+			an := AddressNotation{Style: style, ZeroBased: zeroBased}
+			for _, table := range tables {
+				for _, address := range []uint16{0, 1, 99, 9998, 9999, 10000, 65534, 65535} {
+					text := an.Format(table, address)
+					tb, a, err := an.Parse(text)
+					if err != nil || tb != table || a != address {
+						t.Errorf("%+v: %v:%v formatted '%v' parsed %v:%v %v", an, table, address, text, tb, a, err)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestAddressFormat(t *testing.T) {
+	tests := []struct {
+		an      AddressNotation
+		table   Table
+		address uint16
+		text    string
+	}{
+		{AddressNotation{}, TableHoldingRegisters, 0, "40001"},
+		{AddressNotation{}, TableHoldingRegisters, 9998, "49999"},
+		{AddressNotation{}, TableHoldingRegisters, 9999, "410000"},
+		{AddressNotation{}, TableCoils, 0, "00001"},
+		{AddressNotation{ZeroBased: true}, TableInputRegisters, 0, "30000"},
+		{AddressNotation{Style: AddressModicon6}, TableDiscreteInputs, 0, "100001"},
+		{AddressNotation{Style: AddressModicon6}, TableHoldingRegisters, 65535, "465536"},
+		{AddressNotation{Style: AddressModiconX}, TableHoldingRegisters, 0, "4x0001"},
+		{AddressNotation{Style: AddressIEC}, TableHoldingRegisters, 100, "%MW100"},
+		{AddressNotation{Style: AddressIEC, ZeroBased: true}, TableCoils, 3, "%M3"},
+		{AddressNotation{Style: AddressPrefix}, TableHoldingRegisters, 99, "HR:100"},
+		{AddressNotation{Style: AddressPrefix, ZeroBased: true}, TableInputRegisters, 99, "IR:99"},
+	}
+	for _, test := range tests {
+		if text := test.an.Format(test.table, test.address); text != test.text {
+			t.Errorf("%+v: %v:%v formatted '%v', '%v' expected", test.an, test.table, test.address, text, test.text)
+		}
+	}
+}
+
+func TestAddressParse(t *testing.T) {
+	tests := []struct {
+		an      AddressNotation
+		text    string
+		table   Table
+		address uint16
+		err     bool
+	}{
+		{AddressNotation{}, "40001", TableHoldingRegisters, 0, false},
+		{AddressNotation{}, " 4x0100 ", TableHoldingRegisters, 99, false},
+		{AddressNotation{}, "300010", TableInputRegisters, 9, false},
+		{AddressNotation{}, "hr:1", TableHoldingRegisters, 0, false},
+		{AddressNotation{}, "%IX7", TableDiscreteInputs, 7, false},
+		{AddressNotation{}, "%QW2", TableHoldingRegisters, 2, false},
+		{AddressNotation{ZeroBased: true}, "40000", TableHoldingRegisters, 0, false},
+		{AddressNotation{DefaultTable: TableHoldingRegisters}, "12", TableHoldingRegisters, 11, false},
+		{AddressNotation{}, "12", 0, 0, true},
+		{AddressNotation{}, "40000", 0, 0, true},
+		{AddressNotation{}, "465537", 0, 0, true},
+		{AddressNotation{}, "20001", 0, 0, true},
+		{AddressNotation{}, "XY:1", 0, 0, true},
+		{AddressNotation{}, "%MD1", 0, 0, true},
+		{AddressNotation{}, "%MW65536", 0, 0, true},
+		{AddressNotation{}, "4000001", 0, 0, true},
+		{AddressNotation{}, "", 0, 0, true},
+	}
+	for _, test := range tests {
+		table, address, err := test.an.Parse(test.text)
+		if (err != nil) != test.err || (!test.err && (table != test.table || address != test.address)) {
+			t.Errorf("%+v: '%v' parsed %v:%v %v", test.an, test.text, table, address, err)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package modbus
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BitField is a named flag or multi-bit field of a 16-bit register, Bit is the lowest bit.
+type BitField struct {
+	Name string `json:"name" yaml:"name"`
+	Bit  uint8  `json:"bit" yaml:"bit"`
+	// Count of bits, zero or one for flags
+	Width uint8 `json:"width,omitempty" yaml:"width,omitempty"`
+}
+
+// IsFlag reports whether the field is a single bit decoded as bool.
+func (f BitField) IsFlag() bool { return f.Width <= 1 }
+
+// Mask returns the bits of the field in the register.
+func (f BitField) Mask() uint16 {
+	width := uint(f.Width)
+	if width == 0 {
+		width = 1
+	}
+	return uint16((uint32(1)<<width - 1) << f.Bit)
+}
+
+// BitFields is the layout of a packed status or control word.
+// The text form is "name=bit[:width]" joined with ';', e.g. "ready=0;fault=3;mode=4:4".
+type BitFields []BitField
+
+// ParseBitFields parses the text form of the fields.
+func ParseBitFields(text string) (bf BitFields, err error) {
+	for _, item := range strings.Split(text, ";") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(item, "=")
+		bit, width, _ := strings.Cut(spec, ":")
+		f := BitField{Name: strings.TrimSpace(name)}
+		b, err := strconv.ParseUint(strings.TrimSpace(bit), 10, 8)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("modbus: invalid bit field '%v'", item)
+		}
+		f.Bit = uint8(b)
+		if width != "" {
+			w, err := strconv.ParseUint(strings.TrimSpace(width), 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("modbus: invalid bit field '%v'", item)
+			}
+			f.Width = uint8(w)
+		}
+		bf = append(bf, f)
+	}
+	return bf, bf.Validate()
+}
+
+func (bf BitFields) String() string {
+	items := make([]string, len(bf))
+	for n, f := range bf {
+		items[n] = f.Name + "=" + strconv.Itoa(int(f.Bit))
+		if !f.IsFlag() {
+			items[n] += ":" + strconv.Itoa(int(f.Width))
+		}
+	}
+	return strings.Join(items, ";")
+}
+
+func (bf BitFields) MarshalText() ([]byte, error) {
+	return []byte(bf.String()), nil
+}
+
+func (bf *BitFields) UnmarshalText(text []byte) (err error) {
+	*bf, err = ParseBitFields(string(text))
+	return
+}
+
+// Validate checks names and that fields fit the register without overlapping.
+func (bf BitFields) Validate() error {
+	var used uint16
+	names := make(map[string]bool, len(bf))
+	for _, f := range bf {
+		if f.Name == "" {
+			return fmt.Errorf("modbus: bit field at bit '%v' has no name", f.Bit)
+		}
+		if names[f.Name] {
+			return fmt.Errorf("modbus: bit field '%v' is duplicated", f.Name)
+		}
+		names[f.Name] = true
+		if int(f.Bit)+int(f.Width) > 16 || f.Bit > 15 {
+			return fmt.Errorf("modbus: bit field '%v' exceeds 16 bits", f.Name)
+		}
+		if used&f.Mask() != 0 {
+			return fmt.Errorf("modbus: bit field '%v' overlaps other fields", f.Name)
+		}
+		used |= f.Mask()
+	}
+	return nil
+}
+
+// Field returns the field by name.
+func (bf BitFields) Field(name string) (BitField, bool) {
+	for _, f := range bf {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return BitField{}, false
+}
+
+// Decode splits the register to named values, bool for flags and uint64 for multi-bit fields.
+func (bf BitFields) Decode(word uint16) map[string]interface{} {
+	values := make(map[string]interface{}, len(bf))
+	for _, f := range bf {
+		v := (word & f.Mask()) >> f.Bit
+		if f.IsFlag() {
+			values[f.Name] = v != 0
+		} else {
+			values[f.Name] = uint64(v)
+		}
+	}
+	return values
+}
+
+// Encode converts the named values to the masks of MBClient.ModifyRegister,
+// andMask keeps the bits of the fields not given.
+func (bf BitFields) Encode(values map[string]interface{}) (andMask, orMask uint16, err error) {
+	andMask = 0xFFFF
+	for name, value := range values {
+		f, ok := bf.Field(name)
+		if !ok {
+			return 0, 0, fmt.Errorf("modbus: unknown bit field '%v'", name)
+		}
+		var v uint64
+		if f.IsFlag() {
+			b, err := toBool(value)
+			if err != nil {
+				return 0, 0, err
+			}
+			if b {
+				v = 1
+			}
+		} else if v, err = toUint64(value); err != nil {
+			return 0, 0, err
+		}
+		if v > 0xFFFF || v<<f.Bit&^uint64(f.Mask()) != 0 {
+			return 0, 0, fmt.Errorf("modbus: value '%v' does not fit bit field '%v'", value, name)
+		}
+		andMask &^= f.Mask()
+		orMask |= uint16(v << f.Bit)
+	}
+	return
+}
@@ -0,0 +1,111 @@
+package modbus
+
+import "testing"
+
+func TestBitFields(t *testing.T) {
+	bf, err := ParseBitFields("ready=0; fault=3; mode=4:4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := bf.String(); s != "ready=0;fault=3;mode=4:4" {
+		t.Errorf("string %v", s)
+	}
+	values := bf.Decode(0xFF59)
+	if values["ready"] != true || values["fault"] != true || values["mode"] != uint64(5) {
+		t.Errorf("decode %v", values)
+	}
+	tests := []struct {
+		values  map[string]interface{}
+		andMask uint16
+		orMask  uint16
+		err     bool
+	}{
+		{map[string]interface{}{}, 0xFFFF, 0x0000, false},
+		{map[string]interface{}{"ready": true}, 0xFFFE, 0x0001, false},
+		{map[string]interface{}{"ready": false, "fault": 1}, 0xFFF6, 0x0008, false},
+		{map[string]interface{}{"mode": 15}, 0xFF0F, 0x00F0, false},
+		{map[string]interface{}{"mode": "3", "ready": "true"}, 0xFF0E, 0x0031, false},
+		{map[string]interface{}{"mode": 16}, 0, 0, true},
+		{map[string]interface{}{"mode": -1}, 0, 0, true},
+		{map[string]interface{}{"speed": 1}, 0, 0, true},
+		{map[string]interface{}{"ready": "maybe"}, 0, 0, true},
+	}
+	for _, test := range tests {
+		andMask, orMask, err := bf.Encode(test.values)
+		if (err != nil) != test.err || (!test.err && (andMask != test.andMask || orMask != test.orMask)) {
+			t.Errorf("%v: masks %04X %04X %v", test.values, andMask, orMask, err)
+		}
+	}
+	for _, text := range []string{"a=0;a=1", "a=16", "a=12:5", "a=0:4;b=3", "=1", "a", "a=x", "a=1:x"} {
+		if _, err := ParseBitFields(text); err == nil {
+			t.Errorf("%v: error expected", text)
+		}
+	}
+}
+
+func TestWriteTagBitFields(t *testing.T) {
+	fields, _ := ParseBitFields("ready=0;fault=3;mode=4:4")
+	tests := []struct {
+		name        string
+		order       WordOrder
+		noMaskWrite bool
+		values      map[string]interface{}
+		register    uint16
+	}{
+		{"flag", OrderABCD, false, map[string]interface{}{"ready": false}, 0xA5FE},
+		{"field", OrderABCD, false, map[string]interface{}{"mode": 2}, 0xA52F},
+		{"flag and field", OrderABCD, false, map[string]interface{}{"fault": false, "mode": 0}, 0xA507},
+		{"fallback", OrderABCD, true, map[string]interface{}{"mode": 2}, 0xA52F},
+		// bytes swapped on the device, the fields are numbered in the swapped register
+		{"swapped", OrderBADC, false, map[string]interface{}{"ready": false}, 0xA4FF},
+		{"swapped fallback", OrderBADC, true, map[string]interface{}{"mode": 0}, 0x05FF},
+	}
+	for _, test := range tests {
+		dev := &registerDevice{noMaskWrite: test.noMaskWrite}
+		dev.registers[2] = 0xA5FF
+		rm, err := NewRegisterMap(&Tag{Name: "control", Table: TableHoldingRegisters, Address: 2, Type: TypeUint16,
+			WordOrder: test.order, Access: AccessReadWrite, Fields: fields})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mbc := NewSClient(1, "rtu")
+		if warn, err := rm.WriteTag(mbc, dev, "control", test.values); err != nil || warn != nil {
+			t.Errorf("%v: %v %v", test.name, warn, err)
+			continue
+		}
+		if dev.registers[2] != test.register {
+			t.Errorf("%v: register %04X, %04X expected", test.name, dev.registers[2], test.register)
+		}
+		fallback := 0
+		if test.noMaskWrite {
+			fallback = 1
+		}
+		if dev.maskWrites != 1 || dev.reads != fallback || dev.writes != fallback {
+			t.Errorf("%v: %v mask writes, %v reads, %v writes", test.name, dev.maskWrites, dev.reads, dev.writes)
+		}
+		// the fallback is remembered by the client
+		if test.noMaskWrite {
+			rm.WriteTag(mbc, dev, "control", test.values)
+			if dev.maskWrites != 1 || dev.reads != 2 {
+				t.Errorf("%v: mask write retried", test.name)
+			}
+		}
+	}
+}
+
+func TestEncodeBitFields(t *testing.T) {
+	fields, _ := ParseBitFields("ready=0;fault=3;mode=4:4")
+	tag := &Tag{Name: "control", Table: TableHoldingRegisters, Type: TypeUint16, Fields: fields}
+	// unlike WriteTag the whole register is encoded, fields not given are zero
+	raw, err := tag.Encode(map[string]interface{}{"ready": true, "mode": 2})
+	if err != nil || len(raw) != 2 || raw[0] != 0x00 || raw[1] != 0x21 {
+		t.Errorf("encode % X %v", raw, err)
+	}
+	tag.WordOrder = OrderBADC
+	if raw, err = tag.Encode(map[string]interface{}{"ready": true}); err != nil || len(raw) != 2 || raw[0] != 0x01 || raw[1] != 0x00 {
+		t.Errorf("encode swapped % X %v", raw, err)
+	}
+	if _, err = tag.Encode(map[string]interface{}{"speed": 1}); err == nil {
+		t.Errorf("unknown field encoded")
+	}
+}
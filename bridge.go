@@ -0,0 +1,163 @@
+package modbus
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Bridge runs a serial slave on one port and forwards its requests to the master transporter,
+// e.g. an ASCII master to a RTU bus or between two RS-485 segments:
+//  mbt := modbus.NewTransporter()
+//  mbt.Connect("rtu", "/dev/ttyUSB1", 19200, 8, "E", 1, 500, 0)
+//  bridge := modbus.NewBridge(mbt)
+//  bridge.ListenAndServe("ascii", "/dev/ttyUSB0", 9600, 7, "E", 1)
+// Requests are re-framed if the modes differ or the slave id is rewritten, the response is
+// framed back with the original slave id. Requests without answer of the device are not answered,
+// so the master on the slave port times out as on a direct bus.
+type Bridge struct {
+	Master ApiSender
+	// Mode (rtu, ascii, tcp) of the master transporter, detected if empty
+	MasterMode string
+	// Maximum wait for the response on the master side, zero waits for the transporter timeout
+	RequestTimeout time.Duration
+	// Maximum time from the request to the response on the slave port, later responses
+	// are dropped instead of colliding with the next request. Zero disables.
+	ResponseTimeout time.Duration
+	Logger          *log.Logger
+
+	slave   serialSlave
+	mu      sync.Mutex
+	rewrite map[byte]byte
+	units   map[byte]bool
+}
+
+func NewBridge(master ApiSender) *Bridge {
+	return &Bridge{Master: master, rewrite: make(map[byte]byte), units: make(map[byte]bool)}
+}
+
+// Rewrite maps the slave id of requests on the slave port to the id on the master side.
+func (b *Bridge) Rewrite(from, to byte) {
+	b.mu.Lock()
+	b.rewrite[from] = to
+	b.mu.Unlock()
+}
+
+// Forward restricts the bridge to the slave ids, requests of other ids are ignored
+// as they belong to other slaves of the segment. All ids are forwarded if none is set,
+// which requires that no other slave answers on the slave port.
+func (b *Bridge) Forward(units ...byte) {
+	b.mu.Lock()
+	for _, id := range units {
+		b.units[id] = true
+	}
+	b.mu.Unlock()
+}
+
+// target returns the id on the master side, ok is false if the id is not forwarded.
+func (b *Bridge) target(id byte) (byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.units) > 0 && id != 0 && !b.units[id] {
+		return 0, false
+	}
+	if to, ok := b.rewrite[id]; ok {
+		return to, true
+	}
+	return id, true
+}
+
+func (b *Bridge) logf(format string, v ...interface{}) {
+	if b.Logger != nil {
+		b.Logger.Printf(format, v...)
+	}
+}
+
+// ListenAndServe opens the slave port in mode (rtu, ascii) and serves the requests until Close.
+func (b *Bridge) ListenAndServe(mode, address string, baudrate, databits int, parity string, stopbits int) error {
+	return b.slave.serve(mode, nil, address, baudrate, databits, parity, stopbits, b.Logger, b.serve)
+}
+
+// Close stops ListenAndServe and waits until the slave port is closed.
+func (b *Bridge) Close() error {
+	b.slave.close()
+	return nil
+}
+
+// serve forwards the request frame of the slave port and returns the response frame, nil if not answered.
+func (b *Bridge) serve(mode string, frame []byte, received time.Time) []byte {
+	id, _ := frameSpec(mode, frame)
+	src := NewSClient(id, mode)
+	if mode == "ascii" && src.Verify(frame, frame) != nil || mode == "rtu" && len(frame) < rtuMinSize {
+		b.logf("modbus: bridge dropped invalid frame % x\n", frame)
+		return nil
+	}
+	pdu, err := src.Decode(frame)
+	if err != nil {
+		b.logf("modbus: bridge dropped frame: %v\n", err)
+		return nil
+	}
+	to, ok := b.target(id)
+	if !ok {
+		return nil
+	}
+	masterMode := b.MasterMode
+	if masterMode == "" {
+		masterMode = transporterMode(b.Master)
+	}
+	dst := NewSClient(to, masterMode)
+	request, err := dst.Encode(pdu)
+	if err != nil {
+		b.logf("modbus: bridge unit '%v': %v\n", id, err)
+		return nil
+	}
+	response, warn, err := b.send(request)
+	if err != nil || warn != nil {
+		b.logf("modbus: bridge unit '%v' as '%v': %v %v\n", id, to, warn, err)
+		return nil
+	}
+	if response == nil || id == 0 {
+		return nil
+	}
+	if err = dst.Verify(request, response); err == nil {
+		pdu, err = dst.Decode(response)
+	}
+	if err == nil {
+		response, err = src.Encode(pdu)
+	}
+	if err != nil {
+		b.logf("modbus: bridge unit '%v' as '%v': %v\n", id, to, err)
+		return nil
+	}
+	if b.ResponseTimeout > 0 && time.Since(received) > b.ResponseTimeout {
+		b.logf("modbus: bridge unit '%v' dropped response later than %v\n", id, b.ResponseTimeout)
+		return nil
+	}
+	return response
+}
+
+// send sends the request to the master within RequestTimeout.
+func (b *Bridge) send(request []byte) (response []byte, warn, err error) {
+	if b.RequestTimeout <= 0 {
+		return b.Master.Send(request)
+	}
+	type result struct {
+		response  []byte
+		warn, err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		r.response, r.warn, r.err = b.Master.Send(request)
+		done <- r
+	}()
+	timer := time.NewTimer(b.RequestTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.response, r.warn, r.err
+	case <-timer.C:
+		return nil, fmt.Errorf("modbus: bridge request timed out after %v", b.RequestTimeout), nil
+	}
+}
@@ -0,0 +1,91 @@
+package modbus
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// busLockPoll is the interval of retries while the bus lock is held by another process.
+const busLockPoll = 5 * time.Millisecond
+
+// SerialLockFile returns the conventional bus lock file of the device, e.g. /var/lock/modbus.ttyUSB0.lock.
+// It differs from UUCP lock files (LCK..ttyUSB0), the lock protocols are not compatible.
+func SerialLockFile(device string) string {
+	return filepath.Join("/var/lock", "modbus."+filepath.Base(device)+".lock")
+}
+
+// busLock is an advisory lock shared by the processes talking on a serial line.
+// The lock is flock(2) on the file, which is released by the kernel when the holder exits,
+// so there are no stale locks to remove. The file is kept and holds the PID of the holder
+// for information.
+type busLock struct {
+	Path string
+	// Maximum wait for the lock, zero waits for ever
+	Timeout time.Duration
+
+	file *os.File
+}
+
+// acquire locks the file, waiting while it is locked by another process or busLock.
+func (bl *busLock) acquire() error {
+	if bl.file != nil {
+		return fmt.Errorf("modbus: bus lock '%v' is already held", bl.Path)
+	}
+	f, err := os.OpenFile(bl.Path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	var deadline time.Time
+	if bl.Timeout > 0 {
+		deadline = time.Now().Add(bl.Timeout)
+	}
+	for {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("modbus: bus lock '%v': %w", bl.Path, err)
+		}
+		if locked {
+			break
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			f.Close()
+			return fmt.Errorf("modbus: bus lock '%v' held by process '%v' timed out after %v", bl.Path, lockHolder(bl.Path), bl.Timeout)
+		}
+		time.Sleep(busLockPoll)
+	}
+	// the PID is informational, failures do not affect the lock
+	if f.Truncate(0) == nil {
+		f.WriteAt([]byte(fmt.Sprintf("%10d\n", os.Getpid())), 0)
+	}
+	bl.file = f
+	return nil
+}
+
+// release unlocks the file, it is not removed as another process may wait on it.
+func (bl *busLock) release() error {
+	if bl.file == nil {
+		return nil
+	}
+	f := bl.file
+	bl.file = nil
+	f.Truncate(0)
+	// closing the file releases the lock
+	return f.Close()
+}
+
+// lockHolder returns the PID written by the holder of the lock, empty if unknown.
+func lockHolder(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	var pid int
+	fmt.Sscan(string(data), &pid)
+	if pid <= 0 {
+		return ""
+	}
+	return fmt.Sprint(pid)
+}
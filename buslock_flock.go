@@ -0,0 +1,25 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package modbus
+
+import (
+	"os"
+	"syscall"
+)
+
+// tryLockFile takes the exclusive flock of the file without waiting, locked is false if it is held.
+func tryLockFile(f *os.File) (locked bool, err error) {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return false, err
+	}
+	if e := rc.Control(func(fd uintptr) {
+		err = syscall.Flock(int(fd), syscall.LOCK_EX|syscall.LOCK_NB)
+	}); e != nil {
+		return false, e
+	}
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+
+package modbus
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// tryLockFile returns an error, bus locks are supported on unix only.
+func tryLockFile(f *os.File) (bool, error) {
+	return false, fmt.Errorf("bus lock is not supported on %v", runtime.GOOS)
+}
@@ -0,0 +1,137 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package modbus
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestBusLockHelper holds the lock of BUSLOCK_HELPER until killed, it is run by the other tests.
+func TestBusLockHelper(t *testing.T) {
+	path := os.Getenv("BUSLOCK_HELPER")
+	if path == "" {
+		t.Skip("helper process only")
+	}
+	bl := &busLock{Path: path}
+	if err := bl.acquire(); err != nil {
+		os.Exit(1)
+	}
+	os.Stdout.WriteString("locked\n")
+	select {}
+}
+
+// staleLockFile returns a lock file left by a process which is not running.
+func staleLockFile(t *testing.T) string {
+	t.Helper()
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skip(err)
+	}
+	path := filepath.Join(t.TempDir(), "LCK..ttyTEST")
+	if err := os.WriteFile(path, []byte("  "+strconv.Itoa(cmd.Process.Pid)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBusLockStale(t *testing.T) {
+	path := staleLockFile(t)
+	const rounds = 200
+	var holders, overlaps int32
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bl := &busLock{Path: path, Timeout: 5 * time.Second}
+			for n := 0; n < rounds; n++ {
+				if err := bl.acquire(); err != nil {
+					errs <- err
+					return
+				}
+				if atomic.AddInt32(&holders, 1) > 1 {
+					atomic.AddInt32(&overlaps, 1)
+				}
+				time.Sleep(10 * time.Microsecond)
+				atomic.AddInt32(&holders, -1)
+				if err := bl.release(); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if overlaps != 0 {
+		t.Errorf("lock held by both acquirers '%v' times", overlaps)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("lock file removed: %v", err)
+	}
+}
+
+func TestBusLockTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "LCK..ttyTEST")
+	holder := &busLock{Path: path}
+	if err := holder.acquire(); err != nil {
+		t.Fatal(err)
+	}
+	bl := &busLock{Path: path, Timeout: 50 * time.Millisecond}
+	start := time.Now()
+	if err := bl.acquire(); err == nil {
+		t.Fatalf("lock acquired while held")
+	}
+	if elapsed := time.Since(start); elapsed < bl.Timeout {
+		t.Errorf("timed out after %v", elapsed)
+	}
+	holder.release()
+	if err := bl.acquire(); err != nil {
+		t.Fatal(err)
+	}
+	bl.release()
+}
+
+func TestBusLockDeadProcess(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs a helper process")
+	}
+	path := filepath.Join(t.TempDir(), "LCK..ttyTEST")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestBusLockHelper$")
+	cmd.Env = append(os.Environ(), "BUSLOCK_HELPER="+path)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	buf := make([]byte, 7)
+	if _, err = out.Read(buf); err != nil || string(buf[:6]) != "locked" {
+		t.Fatalf("helper: %q %v", buf, err)
+	}
+
+	bl := &busLock{Path: path, Timeout: 50 * time.Millisecond}
+	if err = bl.acquire(); err == nil {
+		t.Fatalf("lock acquired while held by the helper")
+	}
+	cmd.Process.Kill()
+	cmd.Wait()
+	bl.Timeout = time.Second
+	if err = bl.acquire(); err != nil {
+		t.Fatalf("lock of the killed helper: %v", err)
+	}
+	bl.release()
+}
@@ -0,0 +1,331 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+// CacheStats are the counters of CacheProxy requests.
+type CacheStats struct {
+	Requests   uint64
+	Hits       uint64 // reads answered from the cache
+	Coalesced  uint64 // reads sharing the backend request of an identical read
+	Forwarded  uint64 // requests sent to the backend
+	Writes     uint64
+	Exceptions uint64 // exception responses
+}
+
+// CacheProxy is the Handler caching reads of the next handler (e.g. Gateway) per register:
+//  proxy := modbus.NewCacheProxy(gw, time.Second)
+//  modbus.NewServer(proxy).ListenAndServe(":502")
+// Reads are answered from the cache if all registers or bits are fresh, identical concurrent
+// reads are coalesced into one backend request. Writes invalidate the overlapping registers
+// of the unit, broadcast writes of all units. Exception responses are not cached.
+type CacheProxy struct {
+	Next Handler
+	// TTL of registers without rule, zero disables caching
+	TTL time.Duration
+
+	mu        sync.Mutex
+	rules     []cacheRule
+	values    map[cacheKey]cacheValue
+	inflight  map[cacheRead]*cacheCall
+	gen       uint64 // incremented by invalidation
+	lastPurge time.Time
+	clients   map[string]*CacheStats
+	backends  map[byte]*CacheStats
+}
+
+type cacheRule struct {
+	table    Table
+	address  uint16
+	quantity uint16
+	ttl      time.Duration
+}
+
+type cacheKey struct {
+	unit    byte
+	table   Table
+	address uint16
+}
+
+type cacheValue struct {
+	value   uint16
+	expires time.Time
+}
+
+type cacheRead struct {
+	unit     byte
+	function byte
+	address  uint16
+	quantity uint16
+}
+
+type cacheCall struct {
+	done chan struct{}
+	res  *ProtocolDataUnit
+}
+
+func NewCacheProxy(next Handler, ttl time.Duration) *CacheProxy {
+	return &CacheProxy{
+		Next:     next,
+		TTL:      ttl,
+		values:   make(map[cacheKey]cacheValue),
+		inflight: make(map[cacheRead]*cacheCall),
+		clients:  make(map[string]*CacheStats),
+		backends: make(map[byte]*CacheStats),
+	}
+}
+
+// SetTTL sets the TTL of the registers or bits of the table of all units, zero disables caching.
+// Later rules override earlier ones.
+func (c *CacheProxy) SetTTL(table Table, address, quantity uint16, ttl time.Duration) {
+	c.mu.Lock()
+	c.rules = append(c.rules, cacheRule{table: table, address: address, quantity: quantity, ttl: ttl})
+	c.mu.Unlock()
+}
+
+// ClientStats returns the counters by client host.
+func (c *CacheProxy) ClientStats() map[string]CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := make(map[string]CacheStats, len(c.clients))
+	for host, s := range c.clients {
+		stats[host] = *s
+	}
+	return stats
+}
+
+// BackendStats returns the counters by unit id.
+func (c *CacheProxy) BackendStats() map[byte]CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := make(map[byte]CacheStats, len(c.backends))
+	for unit, s := range c.backends {
+		stats[unit] = *s
+	}
+	return stats
+}
+
+// Invalidate drops the cached registers or bits of the unit, unit 0 drops them of all units.
+func (c *CacheProxy) Invalidate(unit byte, table Table, address, quantity uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invalidate(unit, table, address, quantity)
+}
+
+// invalidate drops the cached values. Caller must hold the mutex.
+func (c *CacheProxy) invalidate(unit byte, table Table, address, quantity uint16) {
+	c.gen++
+	if unit == 0 {
+		for key := range c.values {
+			if key.table == table && key.address >= address && int(key.address) < int(address)+int(quantity) {
+				delete(c.values, key)
+			}
+		}
+		return
+	}
+	for n := 0; n < int(quantity) && int(address)+n <= 0xFFFF; n++ {
+		delete(c.values, cacheKey{unit: unit, table: table, address: address + uint16(n)})
+	}
+}
+
+// stats returns the counters of the client and the unit. Caller must hold the mutex.
+func (c *CacheProxy) stats(addr net.Addr, unit byte) (client, backend *CacheStats) {
+	host := ""
+	if addr != nil {
+		host = addr.String()
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+	}
+	if client = c.clients[host]; client == nil {
+		client = &CacheStats{}
+		c.clients[host] = client
+	}
+	if backend = c.backends[unit]; backend == nil {
+		backend = &CacheStats{}
+		c.backends[unit] = backend
+	}
+	return
+}
+
+// ttl returns the TTL of the register. Caller must hold the mutex.
+func (c *CacheProxy) ttl(table Table, address uint16) time.Duration {
+	for n := len(c.rules) - 1; n >= 0; n-- {
+		rule := c.rules[n]
+		if rule.table == table && address >= rule.address && int(address) < int(rule.address)+int(rule.quantity) {
+			return rule.ttl
+		}
+	}
+	return c.TTL
+}
+
+// ServeModbus answers reads from the cache or the next handler and invalidates on writes.
+func (c *CacheProxy) ServeModbus(req *Request) *ProtocolDataUnit {
+	c.mu.Lock()
+	client, backend := c.stats(req.RemoteAddr, req.UnitID)
+	client.Requests++
+	backend.Requests++
+	read, table, ok := cacheableRead(req.UnitID, req.PDU)
+	if !ok {
+		client.Forwarded++
+		backend.Forwarded++
+		c.mu.Unlock()
+		res := c.Next.ServeModbus(req)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if wtable, address, quantity, ok := writeRange(req.PDU); ok {
+			client.Writes++
+			backend.Writes++
+			c.invalidate(req.UnitID, wtable, address, quantity)
+		}
+		if res != nil && res.FunctionCode&0x80 != 0 {
+			client.Exceptions++
+			backend.Exceptions++
+		}
+		return res
+	}
+	if data, hit := c.lookup(req.UnitID, table, read.address, read.quantity); hit {
+		client.Hits++
+		backend.Hits++
+		c.mu.Unlock()
+		return &ProtocolDataUnit{FunctionCode: req.PDU.FunctionCode, Data: data}
+	}
+	if call, ok := c.inflight[read]; ok {
+		client.Coalesced++
+		backend.Coalesced++
+		c.mu.Unlock()
+		<-call.done
+		return call.res
+	}
+	call := &cacheCall{done: make(chan struct{})}
+	c.inflight[read] = call
+	client.Forwarded++
+	backend.Forwarded++
+	gen := c.gen
+	c.mu.Unlock()
+
+	call.res = c.Next.ServeModbus(req)
+
+	c.mu.Lock()
+	delete(c.inflight, read)
+	if call.res != nil && call.res.FunctionCode&0x80 != 0 {
+		client.Exceptions++
+		backend.Exceptions++
+	} else if call.res != nil && gen == c.gen {
+		c.store(req.UnitID, table, read.address, read.quantity, call.res.Data)
+	}
+	c.mu.Unlock()
+	close(call.done)
+	return call.res
+}
+
+// lookup builds the response data of the read if all values are fresh. Caller must hold the mutex.
+func (c *CacheProxy) lookup(unit byte, table Table, address, quantity uint16) (data []byte, ok bool) {
+	now := time.Now()
+	if table.IsBit() {
+		data = make([]byte, 1+(int(quantity)+7)/8)
+	} else {
+		data = make([]byte, 1+int(quantity)*2)
+	}
+	data[0] = byte(len(data) - 1)
+	for n := 0; n < int(quantity); n++ {
+		v, found := c.values[cacheKey{unit: unit, table: table, address: address + uint16(n)}]
+		if !found || now.After(v.expires) {
+			return nil, false
+		}
+		if !table.IsBit() {
+			binary.BigEndian.PutUint16(data[1+n*2:], v.value)
+		} else if v.value != 0 {
+			data[1+n/8] |= 1 << (n % 8)
+		}
+	}
+	return data, true
+}
+
+// store caches the values of the read response. Caller must hold the mutex.
+func (c *CacheProxy) store(unit byte, table Table, address, quantity uint16, data []byte) {
+	count := int(quantity) * 2
+	if table.IsBit() {
+		count = (int(quantity) + 7) / 8
+	}
+	if len(data) != count+1 || int(data[0]) != count {
+		return
+	}
+	now := time.Now()
+	for n := 0; n < int(quantity); n++ {
+		ttl := c.ttl(table, address+uint16(n))
+		if ttl <= 0 {
+			continue
+		}
+		var v uint16
+		if table.IsBit() {
+			v = uint16(data[1+n/8]>>(n%8)) & 0x01
+		} else {
+			v = binary.BigEndian.Uint16(data[1+n*2:])
+		}
+		c.values[cacheKey{unit: unit, table: table, address: address + uint16(n)}] = cacheValue{value: v, expires: now.Add(ttl)}
+	}
+	if now.Sub(c.lastPurge) > time.Minute {
+		c.lastPurge = now
+		for key, v := range c.values {
+			if now.After(v.expires) {
+				delete(c.values, key)
+			}
+		}
+	}
+}
+
+// cacheableRead returns the read of FC 1-4 with valid quantity, broadcasts are not cached.
+func cacheableRead(unit byte, pdu *ProtocolDataUnit) (read cacheRead, table Table, ok bool) {
+	if unit == 0 || len(pdu.Data) != 4 {
+		return
+	}
+	switch pdu.FunctionCode {
+	case FuncCodeReadCoils:
+		table = TableCoils
+	case FuncCodeReadDiscreteInputs:
+		table = TableDiscreteInputs
+	case FuncCodeReadHoldingRegisters:
+		table = TableHoldingRegisters
+	case FuncCodeReadInputRegisters:
+		table = TableInputRegisters
+	default:
+		return
+	}
+	read = cacheRead{
+		unit:     unit,
+		function: pdu.FunctionCode,
+		address:  binary.BigEndian.Uint16(pdu.Data),
+		quantity: binary.BigEndian.Uint16(pdu.Data[2:]),
+	}
+	ok = read.quantity >= 1 && read.quantity <= table.MaxRead() && int(read.address)+int(read.quantity) <= 0x10000
+	return
+}
+
+// writeRange returns the range written by the request.
+func writeRange(pdu *ProtocolDataUnit) (table Table, address, quantity uint16, ok bool) {
+	data := pdu.Data
+	if len(data) < 4 {
+		return
+	}
+	address = binary.BigEndian.Uint16(data)
+	switch pdu.FunctionCode {
+	case FuncCodeWriteSingleCoil:
+		return TableCoils, address, 1, true
+	case FuncCodeWriteMultipleCoils:
+		return TableCoils, address, binary.BigEndian.Uint16(data[2:]), true
+	case FuncCodeWriteSingleRegister, FuncCodeMaskWriteRegister:
+		return TableHoldingRegisters, address, 1, true
+	case FuncCodeWriteMultipleRegisters:
+		return TableHoldingRegisters, address, binary.BigEndian.Uint16(data[2:]), true
+	case FuncCodeReadWriteMultipleRegisters:
+		if len(data) >= 8 {
+			return TableHoldingRegisters, binary.BigEndian.Uint16(data[4:]), binary.BigEndian.Uint16(data[6:]), true
+		}
+	}
+	return
+}
@@ -0,0 +1,128 @@
+package modbus
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// cacheBackend serves the requests of all units from one register device,
+// requests wait for hold to be closed if set.
+func cacheBackend(dev *registerDevice, entered chan<- struct{}, hold <-chan struct{}) Handler {
+	return HandlerFunc(func(req *Request) *ProtocolDataUnit {
+		if entered != nil {
+			entered <- struct{}{}
+			<-hold
+		}
+		return dev.serve(req.PDU)
+	})
+}
+
+func cacheRequest(unit, function byte, data ...byte) *Request {
+	return &Request{UnitID: unit, PDU: &ProtocolDataUnit{FunctionCode: function, Data: data}, RemoteAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5020}}
+}
+
+func TestCacheProxy(t *testing.T) {
+	dev := &registerDevice{}
+	for n := range dev.registers {
+		dev.registers[n] = uint16(n)
+	}
+	proxy := NewCacheProxy(cacheBackend(dev, nil, nil), time.Minute)
+	proxy.SetTTL(TableHoldingRegisters, 5, 1, 0)
+	proxy.SetTTL(TableHoldingRegisters, 8, 2, 100*time.Millisecond)
+	steps := []struct {
+		name    string
+		request *Request
+		wait    time.Duration
+		reads   int    // backend reads after the step
+		value   uint16 // first register of the response
+	}{
+		{"first read", cacheRequest(1, FuncCodeReadHoldingRegisters, 0, 0, 0, 2), 0, 1, 0},
+		{"cached", cacheRequest(1, FuncCodeReadHoldingRegisters, 0, 0, 0, 2), 0, 1, 0},
+		{"cached part", cacheRequest(1, FuncCodeReadHoldingRegisters, 0, 1, 0, 1), 0, 1, 1},
+		{"partly cached", cacheRequest(1, FuncCodeReadHoldingRegisters, 0, 1, 0, 2), 0, 2, 1},
+		{"other unit", cacheRequest(2, FuncCodeReadHoldingRegisters, 0, 0, 0, 2), 0, 3, 0},
+		// input registers are answered with illegal function, counted as exception
+		{"other table", cacheRequest(1, FuncCodeReadInputRegisters, 0, 0, 0, 2), 0, 3, 0},
+		{"not cached register", cacheRequest(1, FuncCodeReadHoldingRegisters, 0, 4, 0, 2), 0, 4, 4},
+		{"not cached register again", cacheRequest(1, FuncCodeReadHoldingRegisters, 0, 4, 0, 2), 0, 5, 4},
+		{"short TTL", cacheRequest(1, FuncCodeReadHoldingRegisters, 0, 8, 0, 2), 0, 6, 8},
+		{"short TTL cached", cacheRequest(1, FuncCodeReadHoldingRegisters, 0, 8, 0, 2), 0, 6, 8},
+		{"short TTL expired", cacheRequest(1, FuncCodeReadHoldingRegisters, 0, 8, 0, 2), 120 * time.Millisecond, 7, 8},
+		{"write", cacheRequest(1, FuncCodeWriteSingleRegister, 0, 1, 0x12, 0x34), 0, 7, 0},
+		{"invalidated by write", cacheRequest(1, FuncCodeReadHoldingRegisters, 0, 0, 0, 2), 0, 8, 0},
+		{"write of other unit", cacheRequest(2, FuncCodeWriteMultipleRegisters, 0, 0, 0, 1, 2, 0, 7), 0, 8, 0},
+		{"kept by write of other unit", cacheRequest(1, FuncCodeReadHoldingRegisters, 0, 0, 0, 2), 0, 8, 0},
+		{"broadcast write", cacheRequest(0, FuncCodeWriteSingleRegister, 0, 1, 0, 1), 0, 8, 0},
+		{"invalidated by broadcast", cacheRequest(1, FuncCodeReadHoldingRegisters, 0, 0, 0, 2), 0, 9, 7},
+		{"exception", cacheRequest(1, FuncCodeReadHoldingRegisters, 0, 15, 0, 2), 0, 10, 0},
+		{"exception not cached", cacheRequest(1, FuncCodeReadHoldingRegisters, 0, 15, 0, 2), 0, 11, 0},
+	}
+	for _, step := range steps {
+		time.Sleep(step.wait)
+		response := proxy.ServeModbus(step.request)
+		if dev.reads != step.reads {
+			t.Errorf("%v: '%v' backend reads, '%v' expected", step.name, dev.reads, step.reads)
+		}
+		if step.request.PDU.FunctionCode == FuncCodeReadHoldingRegisters && response.FunctionCode == FuncCodeReadHoldingRegisters {
+			if value := uint16(response.Data[1])<<8 | uint16(response.Data[2]); value != step.value {
+				t.Errorf("%v: value %04X, %04X expected", step.name, value, step.value)
+			}
+		}
+	}
+	proxy.Invalidate(1, TableHoldingRegisters, 0, 1)
+	proxy.ServeModbus(cacheRequest(1, FuncCodeReadHoldingRegisters, 0, 0, 0, 2))
+	if dev.reads != 12 {
+		t.Errorf("'%v' backend reads after Invalidate", dev.reads)
+	}
+	stats := proxy.ClientStats()["127.0.0.1"]
+	if stats.Requests != uint64(len(steps))+1 || stats.Hits != 4 || stats.Writes != 3 || stats.Exceptions != 3 || stats.Forwarded != stats.Requests-stats.Hits {
+		t.Errorf("client stats %+v", stats)
+	}
+	if unit := proxy.BackendStats()[2]; unit.Requests != 2 || unit.Writes != 1 {
+		t.Errorf("unit 2 stats %+v", unit)
+	}
+}
+
+func TestCacheProxyCoalescing(t *testing.T) {
+	dev := &registerDevice{}
+	dev.registers[0] = 0x1234
+	entered, hold := make(chan struct{}, 10), make(chan struct{})
+	proxy := NewCacheProxy(cacheBackend(dev, entered, hold), time.Minute)
+	const clients = 5
+	responses := make(chan *ProtocolDataUnit, clients)
+	go func() { responses <- proxy.ServeModbus(cacheRequest(1, FuncCodeReadHoldingRegisters, 0, 0, 0, 1)) }()
+	<-entered
+	var wg sync.WaitGroup
+	for n := 1; n < clients; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses <- proxy.ServeModbus(cacheRequest(1, FuncCodeReadHoldingRegisters, 0, 0, 0, 1))
+		}()
+	}
+	for deadline := time.Now().Add(time.Second); proxy.BackendStats()[1].Coalesced < clients-1; {
+		if time.Now().After(deadline) {
+			t.Fatalf("stats %+v", proxy.BackendStats()[1])
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// invalidated while in flight, the response is not cached
+	proxy.Invalidate(1, TableHoldingRegisters, 0, 1)
+	close(hold)
+	wg.Wait()
+	for n := 0; n < clients; n++ {
+		if response := <-responses; len(response.Data) != 3 || response.Data[1] != 0x12 {
+			t.Errorf("response %+v", response)
+		}
+	}
+	if dev.reads != 1 {
+		t.Errorf("'%v' backend reads of coalesced requests", dev.reads)
+	}
+	proxy.ServeModbus(cacheRequest(1, FuncCodeReadHoldingRegisters, 0, 0, 0, 1))
+	proxy.ServeModbus(cacheRequest(1, FuncCodeReadHoldingRegisters, 0, 0, 0, 1))
+	if dev.reads != 2 {
+		t.Errorf("'%v' backend reads, read in flight during invalidation cached", dev.reads)
+	}
+}
@@ -20,6 +20,8 @@ type ApiClient interface {
 type MBClient struct {
 	mode string
 	ApiClient
+	// noMaskWrite is set if the device does not support FC 22
+	noMaskWrite int32
 }
 
 func NewClient() *MBClient {
@@ -61,6 +63,12 @@ func (mbc *MBClient) Set(id byte, mode string) {
 	mbc.ApiClient.SetID(id)
 }
 
+// IsBroadcast reports whether requests of the serial client are sent to all slaves (id 0).
+// The transporter returns no response for broadcast requests.
+func (c *MBClient) IsBroadcast() bool {
+	return strings.ToLower(c.mode) != "tcp" && c.ApiClient.GetID() == serialBroadcastAddress
+}
+
 func (c *MBClient) VerifyID(id byte) error {
 	if id != c.ApiClient.GetID() {
 		return fmt.Errorf("modbus: response slave id '%v' does not match request '%v'", id, c.ApiClient.GetID())
@@ -68,6 +76,135 @@ func (c *MBClient) VerifyID(id byte) error {
 	return nil
 }
 
+// Query sends the request through the transporter, verifies and decodes the response.
+// Exception responses are returned as *ModbusError warning, broadcasts return nil pdu.
+func (c *MBClient) Query(mbt ApiSender, aduRequest []byte) (pdu *ProtocolDataUnit, warn, err error) {
+	aduResponse, warn, err := mbt.Send(aduRequest)
+	if err != nil || warn != nil || aduResponse == nil {
+		return
+	}
+	if warn = c.ApiClient.Verify(aduRequest, aduResponse); warn != nil {
+		return
+	}
+	if pdu, warn = c.ApiClient.Decode(aduResponse); warn != nil {
+		return
+	}
+	if pdu.FunctionCode&0x80 != 0 {
+		warn = responseError(pdu)
+	}
+	return
+}
+
+// Read encodes the read request of the table.
+func (c *MBClient) Read(table Table, address, quantity uint16) ([]byte, error) {
+	switch table {
+	case TableCoils:
+		return c.ReadCoils(address, quantity)
+	case TableDiscreteInputs:
+		return c.ReadDiscreteInputs(address, quantity)
+	case TableHoldingRegisters:
+		return c.ReadHoldingRegisters(address, quantity)
+	case TableInputRegisters:
+		return c.ReadInputRegisters(address, quantity)
+	}
+	return []byte{0x0}, fmt.Errorf("modbus: unknown table '%v'", table)
+}
+
+// ReadValues reads quantity of registers or bits from the table and returns the values without byte count.
+func (c *MBClient) ReadValues(mbt ApiSender, table Table, address, quantity uint16) (values []byte, warn, err error) {
+	aduRequest, err := c.Read(table, address, quantity)
+	if err != nil {
+		return
+	}
+	pdu, warn, err := c.Query(mbt, aduRequest)
+	if err != nil || warn != nil {
+		return
+	}
+	if pdu == nil || len(pdu.Data) < 1 {
+		warn = fmt.Errorf("modbus: response data is empty")
+		return
+	}
+	count := int(quantity) * 2
+	if table.IsBit() {
+		count = (int(quantity) + 7) / 8
+	}
+	if int(pdu.Data[0]) != count || len(pdu.Data) != count+1 {
+		warn = fmt.Errorf("modbus: response data size '%v' does not match count '%v'", len(pdu.Data)-1, count)
+		return
+	}
+	values = pdu.Data[1:]
+	return
+}
+
+// Write encodes the write request of the table, single register and coil functions are used for quantity 1.
+// Registers are 2*quantity bytes, coils are packed with the first coil in LSB.
+func (c *MBClient) Write(table Table, address, quantity uint16, value []byte) ([]byte, error) {
+	switch table {
+	case TableCoils:
+		if quantity == 1 && len(value) > 0 {
+			return c.WriteSingleCoilBool(address, value[0]&0x01 != 0)
+		}
+		if len(value) != (int(quantity)+7)/8 {
+			return []byte{0x0}, fmt.Errorf("modbus: coils value size '%v' does not match quantity '%v'", len(value), quantity)
+		}
+		return c.WriteMultipleCoils(address, quantity, value)
+	case TableHoldingRegisters:
+		if len(value) != int(quantity)*2 {
+			return []byte{0x0}, fmt.Errorf("modbus: registers value size '%v' does not match quantity '%v'", len(value), quantity)
+		}
+		if quantity == 1 {
+			return c.WriteSingleRegister(address, binary.BigEndian.Uint16(value))
+		}
+		return c.WriteMultipleRegisters(address, quantity, value)
+	}
+	return []byte{0x0}, fmt.Errorf("modbus: table '%v' is not writable", table)
+}
+
+// WriteValues writes quantity of registers or coils to the table.
+func (c *MBClient) WriteValues(mbt ApiSender, table Table, address, quantity uint16, value []byte) (warn, err error) {
+	aduRequest, err := c.Write(table, address, quantity, value)
+	if err != nil {
+		return
+	}
+	_, warn, err = c.Query(mbt, aduRequest)
+	return
+}
+
+// SetMaskWrite sets whether the device supports Mask Write Register (FC 22) used by ModifyRegister.
+func (c *MBClient) SetMaskWrite(supported bool) {
+	var v int32
+	if !supported {
+		v = 1
+	}
+	atomic.StoreInt32(&c.noMaskWrite, v)
+}
+
+// ModifyRegister changes the bits of the holding register not set in andMask to orMask:
+// (current AND andMask) OR (orMask AND NOT andMask).
+// Mask Write Register (FC 22) is used if supported, otherwise the register is read and written
+// with FC 6, which is not atomic on the device. Devices answering FC 22 with illegal function
+// are remembered and served by the fallback.
+func (c *MBClient) ModifyRegister(mbt ApiSender, address, andMask, orMask uint16) (warn, err error) {
+	if atomic.LoadInt32(&c.noMaskWrite) == 0 {
+		aduRequest, err := c.MaskWriteRegister(address, andMask, orMask)
+		if err != nil {
+			return nil, err
+		}
+		_, warn, err = c.Query(mbt, aduRequest)
+		if me, ok := warn.(*ModbusError); !ok || me.ExceptionCode != ExceptionCodeIllegalFunction {
+			return warn, err
+		}
+		atomic.StoreInt32(&c.noMaskWrite, 1)
+	}
+	values, warn, err := c.ReadValues(mbt, TableHoldingRegisters, address, 1)
+	if err != nil || warn != nil {
+		return
+	}
+	current := binary.BigEndian.Uint16(values)
+	value := current&andMask | orMask&^andMask
+	return c.WriteValues(mbt, TableHoldingRegisters, address, 1, []byte{byte(value >> 8), byte(value)})
+}
+
 // Request:
 //  Function code         : 1 byte (0x01)
 //  Starting address      : 2 bytes
@@ -305,6 +442,9 @@ func (rtu *rtuClient) GetID() byte {
 //  Data            : 0 up to 252 bytes
 //  CRC             : 2 byte
 func (rtu *rtuClient) Encode(pdu *ProtocolDataUnit) (adu []byte, err error) {
+	if err = verifySerialAddress(rtu.id, pdu.FunctionCode); err != nil {
+		return
+	}
 	length := len(pdu.Data) + 4
 	if length > rtuMaxSize {
 		err = fmt.Errorf("modbus: length of data '%v' must not be bigger than '%v'", length, rtuMaxSize)
@@ -480,6 +620,9 @@ func (ascii *asciiClient) GetID() byte {
 func (ascii *asciiClient) Encode(pdu *ProtocolDataUnit) (adu []byte, err error) {
 	var buf bytes.Buffer
 
+	if err = verifySerialAddress(ascii.id, pdu.FunctionCode); err != nil {
+		return
+	}
 	if _, err = buf.WriteString(asciiStart); err != nil {
 		return
 	}
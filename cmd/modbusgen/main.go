@@ -0,0 +1,369 @@
+// Command modbusgen generates a typed Go device driver from a register map file (JSON, YAML or CSV).
+//
+//	//go:generate go run github.com/xxandev/modbus/cmd/modbusgen -map meter.csv -package meter -type Meter -out meter_gen.go
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/xxandev/modbus"
+)
+
+type enumValue struct {
+	Name  string
+	Label string
+	Code  int64
+}
+
+type field struct {
+	*modbus.Tag
+	Ident    string
+	GoType   string
+	EnumType string
+	Enum     []enumValue
+}
+
+type device struct {
+	Source  string
+	Package string
+	Type    string
+	Fields  []*field
+	// Generated checks format errors
+	Fmt bool
+}
+
+func main() {
+	mapPath := flag.String("map", "", "register map file (.json, .yaml, .csv)")
+	pkg := flag.String("package", "", "package name of the generated file")
+	typeName := flag.String("type", "Device", "name of the device struct")
+	out := flag.String("out", "", "output file, stdout if empty")
+	flag.Parse()
+
+	if *mapPath == "" || *pkg == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	rm, err := modbus.LoadRegisterMap(*mapPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	src, err := generate(rm, *mapPath, *pkg, *typeName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *out == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	if err := os.WriteFile(*out, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func generate(rm *modbus.RegisterMap, source, pkg, typeName string) ([]byte, error) {
+	dev := &device{Source: source, Package: pkg, Type: identifier(typeName)}
+	idents := make(map[string]string)
+	for _, tag := range rm.Tags {
+		if len(tag.Transforms) > 0 || len(tag.Fields) > 0 {
+			return nil, fmt.Errorf("modbusgen: tag '%v' has transforms or bit fields, they are not supported", tag.Name)
+		}
+		f := &field{Tag: tag, Ident: identifier(tag.Name), GoType: goType(tag)}
+		if other, ok := idents[f.Ident]; ok {
+			return nil, fmt.Errorf("modbusgen: tags '%v' and '%v' have the same identifier '%v'", other, tag.Name, f.Ident)
+		}
+		idents[f.Ident] = tag.Name
+		if (tag.Min != nil || tag.Max != nil) && !numeric(f.GoType) {
+			return nil, fmt.Errorf("modbusgen: tag '%v' of type '%v' can not have min or max", tag.Name, f.GoType)
+		}
+		if len(tag.Enum) > 0 {
+			f.EnumType = dev.Type + f.Ident
+			codes := make(map[int64]string)
+			for name, code := range tag.Enum {
+				if other, ok := codes[code]; ok {
+					return nil, fmt.Errorf("modbusgen: tag '%v' codes '%v' and '%v' have the same value '%v'", tag.Name, other, name, code)
+				}
+				codes[code] = name
+				f.Enum = append(f.Enum, enumValue{Label: name, Code: code})
+			}
+			sort.Slice(f.Enum, func(i, j int) bool { return f.Enum[i].Code < f.Enum[j].Code })
+			// labels differing in case or punctuation only, e.g. "on" and "On", get numbered identifiers
+			names := make(map[string]bool)
+			for n := range f.Enum {
+				name := f.EnumType + identifier(f.Enum[n].Label)
+				for i := 2; names[name]; i++ {
+					name = f.EnumType + identifier(f.Enum[n].Label) + strconv.Itoa(i)
+				}
+				names[name] = true
+				f.Enum[n].Name = name
+			}
+		}
+		dev.Fields = append(dev.Fields, f)
+		dev.Fmt = dev.Fmt || f.EnumType != "" || (tag.Access.CanWrite() && (tag.Min != nil || tag.Max != nil))
+	}
+	var buf bytes.Buffer
+	if err := driverTemplate.Execute(&buf, dev); err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("modbusgen: generated code: %w\n%s", err, buf.Bytes())
+	}
+	return src, nil
+}
+
+// goType returns the Go type of the decoded tag value.
+func goType(tag *modbus.Tag) string {
+	if tag.Table.IsBit() {
+		return "bool"
+	}
+	if tag.Scale != 0 && tag.Scale != 1 {
+		return "float64"
+	}
+	switch tag.Type {
+	case modbus.TypeBool, modbus.TypeString:
+		return string(tag.Type)
+	case modbus.TypeInt16, modbus.TypeInt32, modbus.TypeInt64:
+		return string(tag.Type)
+	case modbus.TypeUint16, modbus.TypeUint32, modbus.TypeUint64:
+		return string(tag.Type)
+	case modbus.TypeFloat32, modbus.TypeFloat64:
+		return string(tag.Type)
+	}
+	return "uint16"
+}
+
+// numeric reports whether the Go type is a number, only numbers have ranges.
+func numeric(goType string) bool {
+	return goType != "bool" && goType != "string"
+}
+
+// identifier converts the tag name to exported Go identifier, e.g. active_power => ActivePower.
+func identifier(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	ident := b.String()
+	if ident == "" || unicode.IsDigit(rune(ident[0])) {
+		ident = "X" + ident
+	}
+	return ident
+}
+
+var driverTemplate = template.Must(template.New("driver").Funcs(template.FuncMap{
+	"quote": strconv.Quote,
+	"deref": func(v *float64) float64 { return *v },
+	"float": func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) },
+	"structTag": func(f *field) string {
+		spec := fmt.Sprintf("%v,%v,%v,%v", f.Table, f.Address, f.Type, f.WordOrder)
+		if f.Type == modbus.TypeString {
+			spec += fmt.Sprintf(",len=%v", f.Length)
+		}
+		if f.Scale != 0 && f.Scale != 1 {
+			spec += ",scale=" + strconv.FormatFloat(f.Scale, 'g', -1, 64)
+		}
+		switch {
+		case !f.Access.CanWrite():
+			spec += ",ro"
+		case !f.Access.CanRead():
+			spec += ",wo"
+		}
+		return "`modbus:" + strconv.Quote(spec) + "`"
+	},
+	"table": func(t modbus.Table) string {
+		switch t {
+		case modbus.TableCoils:
+			return "modbus.TableCoils"
+		case modbus.TableDiscreteInputs:
+			return "modbus.TableDiscreteInputs"
+		case modbus.TableInputRegisters:
+			return "modbus.TableInputRegisters"
+		}
+		return "modbus.TableHoldingRegisters"
+	},
+	"decoded": func(f *field) string {
+		switch f.GoType {
+		case "bool", "string":
+			return f.GoType
+		case "float32", "float64":
+			return "float64"
+		case "uint16", "uint32", "uint64":
+			return "uint64"
+		}
+		return "int64"
+	},
+	"valueType": func(f *field) string {
+		if f.EnumType != "" {
+			return f.EnumType
+		}
+		return f.GoType
+	},
+}).Parse(`// Code generated by modbusgen from {{.Source}}; DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- if .Fmt}}
+	"fmt"
+{{end}}
+	"github.com/xxandev/modbus"
+)
+{{range .Fields}}{{if .EnumType}}{{$enum := .}}
+// {{.EnumType}} is the coded value of tag {{.Name}}.
+type {{.EnumType}} {{.GoType}}
+
+const (
+{{- range .Enum}}
+	{{.Name}} {{$enum.EnumType}} = {{.Code}}
+{{- end}}
+)
+
+// Valid reports whether the value is a known code.
+func (v {{.EnumType}}) Valid() bool {
+	switch v {
+	case {{range $n, $e := .Enum}}{{if $n}}, {{end}}{{$e.Name}}{{end}}:
+		return true
+	}
+	return false
+}
+
+func (v {{.EnumType}}) String() string {
+	switch v {
+{{- range .Enum}}
+	case {{.Name}}:
+		return {{quote .Label}}
+{{- end}}
+	}
+	return fmt.Sprintf("{{.EnumType}}(%v)", {{.GoType}}(v))
+}
+{{end}}{{end}}
+// {{.Type}} is the register image of the device.
+type {{.Type}} struct {
+{{- range .Fields}}
+	// {{.Ident}} is tag {{.Name}}{{if .Unit}}, {{.Unit}}{{end}}{{if .Description}}. {{.Description}}{{end}}
+	{{.Ident}} {{valueType .}} {{structTag .}}
+{{- end}}
+}
+
+// Validate checks ranges and coded values of the writable tags.
+func (v *{{.Type}}) Validate() error {
+{{- range .Fields}}{{if .Access.CanWrite}}
+{{- if .Min}}
+	if float64(v.{{.Ident}}) < {{float (deref .Min)}} {
+		return fmt.Errorf("{{$.Package}}: {{.Name}} value '%v' is less than {{float (deref .Min)}}", v.{{.Ident}})
+	}
+{{- end}}
+{{- if .Max}}
+	if float64(v.{{.Ident}}) > {{float (deref .Max)}} {
+		return fmt.Errorf("{{$.Package}}: {{.Name}} value '%v' is greater than {{float (deref .Max)}}", v.{{.Ident}})
+	}
+{{- end}}
+{{- if .EnumType}}
+	if !v.{{.Ident}}.Valid() {
+		return fmt.Errorf("{{$.Package}}: {{.Name}} value '%v' is not a known code", v.{{.Ident}})
+	}
+{{- end}}
+{{- end}}{{end}}
+	return nil
+}
+
+// registerMap lists the tags of the device.
+var registerMap = mustRegisterMap(
+{{- range .Fields}}
+	&modbus.Tag{Name: {{quote .Name}}, Table: {{table .Table}}, Address: {{.Address}}, Type: {{quote (print .Type)}}, Length: {{.Length}}, WordOrder: {{quote (print .WordOrder)}}, Scale: {{float .Scale}}, Unit: {{quote .Unit}}, Access: {{quote (print .Access)}}
+{{- if .Min}}, Min: limit({{float (deref .Min)}}){{end}}{{if .Max}}, Max: limit({{float (deref .Max)}}){{end -}}
+{{- if .EnumType}}, Enum: map[string]int64{ {{- range .Enum}}{{quote .Label}}: {{.Code}}, {{end -}} }{{end -}}
+},
+{{- end}}
+)
+
+func mustRegisterMap(tags ...*modbus.Tag) *modbus.RegisterMap {
+	rm, err := modbus.NewRegisterMap(tags...)
+	if err != nil {
+		panic(err)
+	}
+	return rm
+}
+
+func limit(v float64) *float64 {
+	return &v
+}
+
+// {{.Type}}Driver reads and writes the tags of one device.
+type {{.Type}}Driver struct {
+	Client      *modbus.MBClient
+	Transporter modbus.ApiSender
+}
+
+func New{{.Type}}Driver(client *modbus.MBClient, transporter modbus.ApiSender) *{{.Type}}Driver {
+	return &{{.Type}}Driver{Client: client, Transporter: transporter}
+}
+
+// RegisterMap returns the register map of the device.
+func (d *{{.Type}}Driver) RegisterMap() *modbus.RegisterMap {
+	return registerMap
+}
+
+// Read reads all readable tags with the minimal count of requests.
+func (d *{{.Type}}Driver) Read() (v *{{.Type}}, warn, err error) {
+	v = &{{.Type}}{}
+	warn, err = modbus.Unmarshal(d.Client, d.Transporter, v)
+	return
+}
+
+// Write checks and writes all writable tags.
+func (d *{{.Type}}Driver) Write(v *{{.Type}}) (warn, err error) {
+	if err = v.Validate(); err != nil {
+		return
+	}
+	return modbus.Marshal(d.Client, d.Transporter, v)
+}
+{{range .Fields}}{{if .Access.CanRead}}
+// {{.Ident}} reads tag {{.Name}}{{if .Unit}}, {{.Unit}}{{end}}.
+func (d *{{$.Type}}Driver) {{.Ident}}() (v {{valueType .}}, warn, err error) {
+	value, warn, err := registerMap.ReadTag(d.Client, d.Transporter, {{quote .Name}})
+	if err == nil && warn == nil {
+		v = {{valueType .}}(value.({{decoded .}}))
+	}
+	return
+}
+{{end}}{{if .Access.CanWrite}}
+// Set{{.Ident}} checks and writes tag {{.Name}}{{if .Unit}}, {{.Unit}}{{end}}.
+func (d *{{$.Type}}Driver) Set{{.Ident}}(v {{valueType .}}) (warn, err error) {
+{{- if .Min}}
+	if float64(v) < {{float (deref .Min)}} {
+		return nil, fmt.Errorf("{{$.Package}}: {{.Name}} value '%v' is less than {{float (deref .Min)}}", v)
+	}
+{{- end}}
+{{- if .Max}}
+	if float64(v) > {{float (deref .Max)}} {
+		return nil, fmt.Errorf("{{$.Package}}: {{.Name}} value '%v' is greater than {{float (deref .Max)}}", v)
+	}
+{{- end}}
+{{- if .EnumType}}
+	if !v.Valid() {
+		return nil, fmt.Errorf("{{$.Package}}: {{.Name}} value '%v' is not a known code", v)
+	}
+{{- end}}
+	return registerMap.WriteTag(d.Client, d.Transporter, {{quote .Name}}, {{.GoType}}(v))
+}
+{{end}}{{end}}`))
@@ -0,0 +1,73 @@
+// Command modbussim simulates the devices of a configuration file (JSON or YAML) for integration
+// tests and demos, over Modbus TCP and a RTU or ASCII pty:
+//
+//	modbussim -config devices.yaml -tcp 127.0.0.1:5020 -serial rtu
+//
+// The path of the pty is printed on start, e.g. "rtu /dev/pts/3", for the transporter under test.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/xxandev/modbus"
+)
+
+func main() {
+	config := flag.String("config", "", "simulator configuration file (.json, .yaml)")
+	tcp := flag.String("tcp", "", "TCP address to serve, e.g. 127.0.0.1:5020")
+	serial := flag.String("serial", "", "serial mode to serve on a new pty (rtu, ascii)")
+	baud := flag.Int("baud", 19200, "baud rate of the pty")
+	interval := flag.Duration("interval", 100*time.Millisecond, "update interval of the generators")
+	verbose := flag.Bool("v", false, "log dropped frames and generator errors")
+	flag.Parse()
+
+	if *config == "" || *tcp == "" && *serial == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	sim, err := modbus.LoadSimulator(*config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	if *verbose {
+		sim.Logger = logger
+	}
+	sim.Start(*interval)
+	defer sim.Stop()
+
+	errs := make(chan error, 2)
+	if *tcp != "" {
+		srv := modbus.NewServer(sim)
+		srv.Logger = sim.Logger
+		defer srv.Close()
+		go func() { errs <- srv.ListenAndServe(*tcp) }()
+		fmt.Printf("tcp %v\n", *tcp)
+	}
+	if *serial != "" {
+		pty, err := modbus.OpenPty()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer pty.Close()
+		srv := modbus.NewSerialServer(sim)
+		srv.Logger = sim.Logger
+		defer srv.Close()
+		go func() { errs <- srv.ServePort(*serial, modbus.FixedPort(pty.Master), *baud, 8, "E", 1) }()
+		fmt.Printf("%v %v\n", *serial, pty.Path)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err = <-errs:
+		logger.Print(err)
+	case <-signals:
+	}
+}
@@ -0,0 +1,336 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// DataType is the type of a value stored in registers or bits.
+type DataType string
+
+const (
+	TypeBool    DataType = "bool"
+	TypeInt16   DataType = "int16"
+	TypeUint16  DataType = "uint16"
+	TypeInt32   DataType = "int32"
+	TypeUint32  DataType = "uint32"
+	TypeInt64   DataType = "int64"
+	TypeUint64  DataType = "uint64"
+	TypeFloat32 DataType = "float32"
+	TypeFloat64 DataType = "float64"
+	TypeString  DataType = "string"
+)
+
+// ParseDataType converts the type name to DataType.
+func ParseDataType(name string) (DataType, error) {
+	dt := DataType(strings.ToLower(strings.TrimSpace(name)))
+	switch dt {
+	case TypeBool, TypeInt16, TypeUint16, TypeInt32, TypeUint32, TypeInt64, TypeUint64, TypeFloat32, TypeFloat64, TypeString:
+		return dt, nil
+	case "float", "real":
+		return TypeFloat32, nil
+	case "double":
+		return TypeFloat64, nil
+	case "int", "short":
+		return TypeInt16, nil
+	case "uint", "word", "ushort":
+		return TypeUint16, nil
+	}
+	return "", fmt.Errorf("modbus: unknown data type '%v'", name)
+}
+
+func (dt *DataType) UnmarshalText(text []byte) (err error) {
+	*dt, err = ParseDataType(string(text))
+	return
+}
+
+// Registers returns count of registers of the type, zero for strings of any length.
+func (dt DataType) Registers() uint16 {
+	switch dt {
+	case TypeBool, TypeInt16, TypeUint16:
+		return 1
+	case TypeInt32, TypeUint32, TypeFloat32:
+		return 2
+	case TypeInt64, TypeUint64, TypeFloat64:
+		return 4
+	}
+	return 0
+}
+
+// WordOrder is the order of bytes of multi-register values on the wire, 'abcd' is big endian.
+type WordOrder string
+
+const (
+	OrderABCD WordOrder = "abcd" // big endian
+	OrderCDAB WordOrder = "cdab" // word swap
+	OrderBADC WordOrder = "badc" // byte swap
+	OrderDCBA WordOrder = "dcba" // little endian
+)
+
+// ParseWordOrder converts the order name to WordOrder, empty name is big endian.
+func ParseWordOrder(name string) (WordOrder, error) {
+	wo := WordOrder(strings.ToLower(strings.TrimSpace(name)))
+	switch wo {
+	case "", "big", "be":
+		return OrderABCD, nil
+	case "little", "le":
+		return OrderDCBA, nil
+	case OrderABCD, OrderCDAB, OrderBADC, OrderDCBA:
+		return wo, nil
+	}
+	return "", fmt.Errorf("modbus: unknown word order '%v'", name)
+}
+
+func (wo *WordOrder) UnmarshalText(text []byte) (err error) {
+	*wo, err = ParseWordOrder(string(text))
+	return
+}
+
+// swap converts between big endian and the word order, the conversion is symmetric.
+func (wo WordOrder) swap(data []byte) []byte {
+	b := make([]byte, len(data))
+	copy(b, data)
+	switch wo {
+	case OrderDCBA:
+		for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+			b[i], b[j] = b[j], b[i]
+		}
+	case OrderBADC:
+		for i := 0; i+1 < len(b); i += 2 {
+			b[i], b[i+1] = b[i+1], b[i]
+		}
+	case OrderCDAB:
+		for i, j := 0, len(b)-2; i < j; i, j = i+2, j-2 {
+			b[i], b[i+1], b[j], b[j+1] = b[j], b[j+1], b[i], b[i+1]
+		}
+	}
+	return b
+}
+
+// DecodeValue decodes registers of the type in word order to bool, int64, uint64, float64 or string.
+func DecodeValue(dt DataType, wo WordOrder, data []byte) (interface{}, error) {
+	if n := int(dt.Registers()) * 2; n > 0 && len(data) != n {
+		return nil, fmt.Errorf("modbus: %v value needs '%v' bytes, got '%v'", dt, n, len(data))
+	}
+	b := wo.swap(data)
+	switch dt {
+	case TypeBool:
+		return binary.BigEndian.Uint16(b) != 0, nil
+	case TypeInt16:
+		return int64(int16(binary.BigEndian.Uint16(b))), nil
+	case TypeUint16:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case TypeInt32:
+		return int64(int32(binary.BigEndian.Uint32(b))), nil
+	case TypeUint32:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	case TypeInt64:
+		return int64(binary.BigEndian.Uint64(b)), nil
+	case TypeUint64:
+		return binary.BigEndian.Uint64(b), nil
+	case TypeFloat32:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case TypeFloat64:
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case TypeString:
+		return strings.TrimRight(string(b), "\x00 "), nil
+	}
+	return nil, fmt.Errorf("modbus: unknown data type '%v'", dt)
+}
+
+// EncodeValue encodes the value of the type to registers in word order,
+// strings are padded with zeros to the count of registers.
+func EncodeValue(dt DataType, wo WordOrder, value interface{}, registers uint16) ([]byte, error) {
+	if n := dt.Registers(); n > 0 {
+		registers = n
+	}
+	b := make([]byte, int(registers)*2)
+	switch dt {
+	case TypeBool:
+		v, err := toBool(value)
+		if err != nil {
+			return nil, err
+		}
+		if v {
+			b[1] = 1
+		}
+	case TypeInt16, TypeInt32, TypeInt64:
+		v, err := toInt64(value)
+		if err != nil {
+			return nil, err
+		}
+		bits := uint(len(b) * 8)
+		if bits < 64 && (v < -(int64(1)<<(bits-1)) || v >= int64(1)<<(bits-1)) {
+			return nil, fmt.Errorf("modbus: value '%v' overflows %v", v, dt)
+		}
+		putUint(b, uint64(v))
+	case TypeUint16, TypeUint32, TypeUint64:
+		v, err := toUint64(value)
+		if err != nil {
+			return nil, err
+		}
+		bits := uint(len(b) * 8)
+		if bits < 64 && v >= uint64(1)<<bits {
+			return nil, fmt.Errorf("modbus: value '%v' overflows %v", v, dt)
+		}
+		putUint(b, v)
+	case TypeFloat32:
+		v, err := toFloat64(value)
+		if err != nil {
+			return nil, err
+		}
+		binary.BigEndian.PutUint32(b, math.Float32bits(float32(v)))
+	case TypeFloat64:
+		v, err := toFloat64(value)
+		if err != nil {
+			return nil, err
+		}
+		binary.BigEndian.PutUint64(b, math.Float64bits(v))
+	case TypeString:
+		v := fmt.Sprint(value)
+		if len(v) > len(b) {
+			return nil, fmt.Errorf("modbus: string of '%v' bytes does not fit '%v' registers", len(v), registers)
+		}
+		copy(b, v)
+	default:
+		return nil, fmt.Errorf("modbus: unknown data type '%v'", dt)
+	}
+	return wo.swap(b), nil
+}
+
+// putUint puts the low bytes of value in big endian order.
+func putUint(b []byte, value uint64) {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i] = byte(value)
+		value >>= 8
+	}
+}
+
+func toBool(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		return strconv.ParseBool(v)
+	}
+	if v, ok := builtin(value); ok {
+		return toBool(v)
+	}
+	f, err := toFloat64(value)
+	return f != 0, err
+}
+
+func toInt64(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint:
+		return int64(v), nil
+	case uint8:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case uint64:
+		if v > math.MaxInt64 {
+			return 0, fmt.Errorf("modbus: value '%v' overflows int64", v)
+		}
+		return int64(v), nil
+	case float32:
+		return int64(math.Round(float64(v))), nil
+	case float64:
+		return int64(math.Round(v)), nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		return strconv.ParseInt(v, 0, 64)
+	}
+	if v, ok := builtin(value); ok {
+		return toInt64(v)
+	}
+	return 0, fmt.Errorf("modbus: can not convert '%v' (%T) to integer", value, value)
+}
+
+func toUint64(value interface{}) (uint64, error) {
+	switch v := value.(type) {
+	case uint:
+		return uint64(v), nil
+	case uint8:
+		return uint64(v), nil
+	case uint16:
+		return uint64(v), nil
+	case uint32:
+		return uint64(v), nil
+	case uint64:
+		return v, nil
+	case string:
+		return strconv.ParseUint(v, 0, 64)
+	}
+	if v, ok := builtin(value); ok {
+		return toUint64(v)
+	}
+	i, err := toInt64(value)
+	if err != nil {
+		return 0, err
+	}
+	if i < 0 {
+		return 0, fmt.Errorf("modbus: negative value '%v' for unsigned type", i)
+	}
+	return uint64(i), nil
+}
+
+func toFloat64(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float32:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case uint64:
+		return float64(v), nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	if v, ok := builtin(value); ok {
+		return toFloat64(v)
+	}
+	i, err := toInt64(value)
+	return float64(i), err
+}
+
+// builtin converts the value of a named type, e.g. a generated enum type, to the built-in type
+// of its kind. ok is false for built-in types and other kinds.
+func builtin(value interface{}) (v interface{}, ok bool) {
+	rv := reflect.ValueOf(value)
+	if !rv.IsValid() || rv.Type().PkgPath() == "" {
+		return nil, false
+	}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return rv.Uint(), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.Bool:
+		return rv.Bool(), true
+	case reflect.String:
+		return rv.String(), true
+	}
+	return nil, false
+}
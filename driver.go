@@ -0,0 +1,212 @@
+package modbus
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Driver packages the behaviour of a device model: detection, register map with scaling of values,
+// and write procedures. Drivers are registered by name with RegisterDriver, usually from init of
+// the driver package.
+type Driver interface {
+	// Name returns the unique name of the driver.
+	Name() string
+	// Probe reports whether the device of the client is handled by the driver.
+	// Warnings (exception responses) mean the device is not recognised.
+	Probe(mbc *MBClient, mbt ApiSender) (ok bool, warn, err error)
+	// RegisterMap returns the tags of the device.
+	RegisterMap() *RegisterMap
+	// Write writes the value of the tag, drivers may unlock or sequence the write.
+	Write(mbc *MBClient, mbt ApiSender, name string, value interface{}) (warn, err error)
+}
+
+// BaseDriver implements Driver with the register map, drivers embed it and override methods.
+// Probe matches all ProbeFuncs, a driver without them never matches.
+type BaseDriver struct {
+	DriverName string
+	Map        *RegisterMap
+	ProbeFuncs []ProbeFunc
+}
+
+// ProbeFunc checks a property of the device for probing.
+type ProbeFunc func(mbc *MBClient, mbt ApiSender) (ok bool, warn, err error)
+
+func (d *BaseDriver) Name() string              { return d.DriverName }
+func (d *BaseDriver) RegisterMap() *RegisterMap { return d.Map }
+
+func (d *BaseDriver) Probe(mbc *MBClient, mbt ApiSender) (ok bool, warn, err error) {
+	for _, probe := range d.ProbeFuncs {
+		if ok, warn, err = probe(mbc, mbt); !ok || err != nil || warn != nil {
+			ok = false
+			return
+		}
+	}
+	return len(d.ProbeFuncs) > 0, nil, nil
+}
+
+func (d *BaseDriver) Write(mbc *MBClient, mbt ApiSender, name string, value interface{}) (warn, err error) {
+	if d.Map == nil {
+		return nil, fmt.Errorf("modbus: driver '%v' has no register map", d.DriverName)
+	}
+	return d.Map.WriteTag(mbc, mbt, name, value)
+}
+
+// ProbeValues matches the raw registers or bits (packed as read) of the table at the address.
+func ProbeValues(table Table, address uint16, value []byte) ProbeFunc {
+	quantity := uint16(len(value) / 2)
+	if table.IsBit() {
+		quantity = uint16(len(value) * 8)
+	}
+	return func(mbc *MBClient, mbt ApiSender) (ok bool, warn, err error) {
+		values, warn, err := mbc.ReadValues(mbt, table, address, quantity)
+		return err == nil && warn == nil && bytes.Equal(values, value), warn, err
+	}
+}
+
+// ProbeTag matches the value of the tag decoded from the device, compared in text form.
+func ProbeTag(tag *Tag, value interface{}) ProbeFunc {
+	return func(mbc *MBClient, mbt ApiSender) (ok bool, warn, err error) {
+		values, warn, err := mbc.ReadValues(mbt, tag.Table, tag.Address, tag.Quantity())
+		if err != nil || warn != nil {
+			return
+		}
+		v, e := tag.Decode(values)
+		return e == nil && fmt.Sprint(v) == fmt.Sprint(value), nil, nil
+	}
+}
+
+// ProbeSunSpec matches SunSpec devices with the model, and the manufacturer and model
+// of the common model if not empty.
+func ProbeSunSpec(modelID uint16, manufacturer, model string) ProbeFunc {
+	return func(mbc *MBClient, mbt ApiSender) (ok bool, warn, err error) {
+		sc := NewSunSpecClient(mbc, mbt)
+		headers, warn, err := sc.Scan()
+		if err != nil || warn != nil {
+			return
+		}
+		for _, h := range headers {
+			if h.ID == 1 && (manufacturer != "" || model != "") {
+				m, w, e := sc.ReadModel(h)
+				if e != nil || w != nil {
+					return false, w, e
+				}
+				mn, _ := m.String("Mn")
+				md, _ := m.String("Md")
+				if (manufacturer != "" && mn != manufacturer) || (model != "" && md != model) {
+					return false, nil, nil
+				}
+			}
+			if h.ID == modelID {
+				ok = true
+			}
+		}
+		return
+	}
+}
+
+// drivers is the registry of drivers by name.
+var drivers = struct {
+	sync.RWMutex
+	byName map[string]Driver
+	order  []string
+}{byName: map[string]Driver{}}
+
+// RegisterDriver makes the driver available by name, registering the same name twice panics.
+func RegisterDriver(d Driver) {
+	drivers.Lock()
+	defer drivers.Unlock()
+	if d == nil {
+		panic("modbus: register driver is nil")
+	}
+	name := d.Name()
+	if _, dup := drivers.byName[name]; dup {
+		panic("modbus: register driver twice for " + name)
+	}
+	drivers.byName[name] = d
+	drivers.order = append(drivers.order, name)
+}
+
+// LookupDriver returns the registered driver by name.
+func LookupDriver(name string) (Driver, bool) {
+	drivers.RLock()
+	defer drivers.RUnlock()
+	d, ok := drivers.byName[name]
+	return d, ok
+}
+
+// Drivers returns the sorted names of registered drivers.
+func Drivers() []string {
+	drivers.RLock()
+	defer drivers.RUnlock()
+	names := append([]string{}, drivers.order...)
+	sort.Strings(names)
+	return names
+}
+
+// ProbeDriver tries the registered drivers in order of registration (or the named ones)
+// against the device of the client and returns the first matching driver.
+// Warnings of drivers are skipped, the last one is returned if no driver matches.
+// Transport errors stop probing.
+func ProbeDriver(mbc *MBClient, mbt ApiSender, names ...string) (d Driver, warn, err error) {
+	if len(names) == 0 {
+		drivers.RLock()
+		names = append(names, drivers.order...)
+		drivers.RUnlock()
+	}
+	for _, name := range names {
+		driver, ok := LookupDriver(name)
+		if !ok {
+			return nil, nil, fmt.Errorf("modbus: unknown driver '%v'", name)
+		}
+		ok, w, e := driver.Probe(mbc, mbt)
+		if e != nil {
+			return nil, nil, fmt.Errorf("modbus: probe driver '%v': %w", name, e)
+		}
+		if ok {
+			return driver, nil, nil
+		}
+		if w != nil {
+			warn = w
+		}
+	}
+	if warn == nil {
+		warn = fmt.Errorf("modbus: no driver matches the device '%v'", mbc.GetID())
+	}
+	return
+}
+
+// Device binds the driver to the client and transporter of a unit.
+type Device struct {
+	Driver
+	Client      *MBClient
+	Transporter ApiSender
+}
+
+func NewDevice(d Driver, mbc *MBClient, mbt ApiSender) *Device {
+	return &Device{Driver: d, Client: mbc, Transporter: mbt}
+}
+
+// ProbeDevice identifies the device of the client with the registered drivers.
+func ProbeDevice(mbc *MBClient, mbt ApiSender, names ...string) (device *Device, warn, err error) {
+	d, warn, err := ProbeDriver(mbc, mbt, names...)
+	if d != nil {
+		device = NewDevice(d, mbc, mbt)
+	}
+	return
+}
+
+// Read reads the tags of the register map, all readable tags if no names given.
+func (d *Device) Read(names ...string) (values map[string]interface{}, warn, err error) {
+	rm := d.RegisterMap()
+	if rm == nil {
+		return nil, nil, fmt.Errorf("modbus: driver '%v' has no register map", d.Name())
+	}
+	return rm.Read(d.Client, d.Transporter, names...)
+}
+
+// Write writes the value of the tag with the procedure of the driver.
+func (d *Device) Write(name string, value interface{}) (warn, err error) {
+	return d.Driver.Write(d.Client, d.Transporter, name, value)
+}
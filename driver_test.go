@@ -0,0 +1,160 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// registerDevice is a backend with 16 holding registers answering FC 3, 6, 16 and 22 of RTU frames,
+// counting the requests.
+type registerDevice struct {
+	mu          sync.Mutex
+	registers   [16]uint16
+	reads       int
+	writes      int
+	maskWrites  int  // FC 22 requests, also refused ones
+	noMaskWrite bool // FC 22 answered with illegal function
+}
+
+func (d *registerDevice) Send(aduRequest []byte) (aduResponse []byte, warn, err error) {
+	mbc := NewSClient(aduRequest[0], "rtu")
+	pdu, err := mbc.Decode(aduRequest)
+	if err != nil {
+		return
+	}
+	aduResponse, err = mbc.Encode(d.serve(pdu))
+	return
+}
+
+func (d *registerDevice) serve(pdu *ProtocolDataUnit) *ProtocolDataUnit {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	address := int(binary.BigEndian.Uint16(pdu.Data))
+	switch pdu.FunctionCode {
+	case FuncCodeReadHoldingRegisters:
+		d.reads++
+		quantity := int(binary.BigEndian.Uint16(pdu.Data[2:]))
+		if address+quantity > len(d.registers) {
+			return ExceptionResponse(pdu.FunctionCode, ExceptionCodeIllegalDataAddress)
+		}
+		data := []byte{byte(2 * quantity)}
+		for n := 0; n < quantity; n++ {
+			data = append(data, byte(d.registers[address+n]>>8), byte(d.registers[address+n]))
+		}
+		return &ProtocolDataUnit{FunctionCode: pdu.FunctionCode, Data: data}
+	case FuncCodeWriteSingleRegister:
+		d.writes++
+		d.registers[address] = binary.BigEndian.Uint16(pdu.Data[2:])
+		return pdu
+	case FuncCodeWriteMultipleRegisters:
+		d.writes++
+		quantity := int(binary.BigEndian.Uint16(pdu.Data[2:]))
+		for n := 0; n < quantity; n++ {
+			d.registers[address+n] = binary.BigEndian.Uint16(pdu.Data[5+2*n:])
+		}
+		return &ProtocolDataUnit{FunctionCode: pdu.FunctionCode, Data: pdu.Data[:4]}
+	case FuncCodeMaskWriteRegister:
+		d.maskWrites++
+		if d.noMaskWrite {
+			break
+		}
+		andMask, orMask := binary.BigEndian.Uint16(pdu.Data[2:]), binary.BigEndian.Uint16(pdu.Data[4:])
+		d.registers[address] = d.registers[address]&andMask | orMask&^andMask
+		return pdu
+	}
+	return ExceptionResponse(pdu.FunctionCode, ExceptionCodeIllegalFunction)
+}
+
+// driverTestMap has a model code at 0 and a scaled setpoint at 1.
+func driverTestMap(t *testing.T) *RegisterMap {
+	t.Helper()
+	rm, err := NewRegisterMap(
+		&Tag{Name: "model", Table: TableHoldingRegisters, Address: 0, Type: TypeUint16},
+		&Tag{Name: "setpoint", Table: TableHoldingRegisters, Address: 1, Type: TypeUint16, Scale: 0.1, Access: AccessReadWrite},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rm
+}
+
+func TestProbeDriver(t *testing.T) {
+	dev := &registerDevice{}
+	dev.registers[0] = 0x1234
+	rm := driverTestMap(t)
+	failing := func(mbc *MBClient, mbt ApiSender) (bool, error, error) { return false, nil, errors.New("bus down") }
+	for _, d := range []*BaseDriver{
+		{DriverName: "test-none", Map: rm},
+		{DriverName: "test-other", Map: rm, ProbeFuncs: []ProbeFunc{ProbeValues(TableHoldingRegisters, 0, []byte{0x43, 0x21})}},
+		{DriverName: "test-exception", Map: rm, ProbeFuncs: []ProbeFunc{ProbeValues(TableHoldingRegisters, 15, []byte{0, 0, 0, 0})}},
+		{DriverName: "test-values", Map: rm, ProbeFuncs: []ProbeFunc{ProbeValues(TableHoldingRegisters, 0, []byte{0x12, 0x34})}},
+		{DriverName: "test-tag", Map: rm, ProbeFuncs: []ProbeFunc{ProbeTag(&Tag{Table: TableHoldingRegisters, Address: 0, Type: TypeUint16}, 0x1234)}},
+		{DriverName: "test-both", Map: rm, ProbeFuncs: []ProbeFunc{
+			ProbeValues(TableHoldingRegisters, 0, []byte{0x12, 0x34}),
+			ProbeTag(&Tag{Table: TableHoldingRegisters, Address: 0, Type: TypeUint16}, 1),
+		}},
+		{DriverName: "test-failing", Map: rm, ProbeFuncs: []ProbeFunc{failing}},
+	} {
+		RegisterDriver(d)
+	}
+	tests := []struct {
+		names  []string
+		driver string
+		warn   bool
+		err    bool
+	}{
+		{[]string{"test-none", "test-other", "test-values"}, "test-values", false, false},
+		{[]string{"test-tag", "test-values"}, "test-tag", false, false},
+		{[]string{"test-both", "test-tag"}, "test-tag", false, false},
+		{[]string{"test-none", "test-other"}, "", true, false},
+		{[]string{"test-exception", "test-values"}, "test-values", false, false},
+		{[]string{"test-exception"}, "", true, false},
+		{[]string{"test-failing", "test-values"}, "", false, true},
+		{[]string{"test-unknown"}, "", false, true},
+	}
+	mbc := NewSClient(1, "rtu")
+	for _, test := range tests {
+		d, warn, err := ProbeDriver(mbc, dev, test.names...)
+		name := ""
+		if d != nil {
+			name = d.Name()
+		}
+		if name != test.driver || (warn != nil) != test.warn || (err != nil) != test.err {
+			t.Errorf("%v: driver '%v' warn %v err %v", test.names, name, warn, err)
+		}
+	}
+	if d, ok := LookupDriver("test-values"); !ok || d.Name() != "test-values" {
+		t.Errorf("lookup %v %v", d, ok)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("duplicated driver registered")
+		}
+	}()
+	RegisterDriver(&BaseDriver{DriverName: "test-values"})
+}
+
+func TestDevice(t *testing.T) {
+	dev := &registerDevice{}
+	dev.registers[0], dev.registers[1] = 7, 215
+	d := NewDevice(&BaseDriver{DriverName: "test-device", Map: driverTestMap(t)}, NewSClient(1, "rtu"), dev)
+	values, warn, err := d.Read()
+	if err != nil || warn != nil || values["model"] != uint64(7) || values["setpoint"] != 21.5 {
+		t.Errorf("read %v %v %v", values, warn, err)
+	}
+	if warn, err = d.Write("setpoint", 22.5); err != nil || warn != nil || dev.registers[1] != 225 {
+		t.Errorf("write %v %v, register %v", warn, err, dev.registers[1])
+	}
+	if _, err = d.Write("model", 8); err == nil {
+		t.Errorf("read only tag written")
+	}
+	empty := NewDevice(&BaseDriver{DriverName: "test-empty"}, NewSClient(1, "rtu"), dev)
+	if _, _, err = empty.Read(); err == nil {
+		t.Errorf("read without register map")
+	}
+	if _, err = empty.Write("setpoint", 1); err == nil {
+		t.Errorf("write without register map")
+	}
+}
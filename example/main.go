@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -18,47 +19,45 @@ func main() {
 		log.Panic(err)
 	}
 	defer mb.Close()
-	request := make(chan []byte, 999)
-	defer close(request)
 
-	go func() {
-		devices := []*modbus.MBClient{
-			modbus.NewSClient(0x12, "rtu"),
-			modbus.NewSClient(0x13, "rtu"),
-			modbus.NewSClient(0x14, "rtu"),
-			modbus.NewSClient(0x15, "rtu"),
-			modbus.NewSClient(0x16, "rtu"),
+	scheduler := modbus.NewScheduler(mb)
+	devices := []*modbus.MBClient{
+		modbus.NewSClient(0x12, "rtu"),
+		modbus.NewSClient(0x13, "rtu"),
+		modbus.NewSClient(0x14, "rtu"),
+		modbus.NewSClient(0x15, "rtu"),
+		modbus.NewSClient(0x16, "rtu"),
+	}
+	for n := range devices {
+		points := []modbus.Point{
+			{Table: modbus.TableCoils, Address: 0, Length: 2},
+			{Table: modbus.TableHoldingRegisters, Address: 0, Length: 11},
 		}
-		for n := range devices {
-			req1, _ := devices[n].ReadCoils(0, 2)
-			request <- req1
-			time.Sleep(2000 * time.Millisecond)
-			req2, _ := devices[n].ReadHoldingRegisters(0, 11)
-			request <- req2
-			time.Sleep(2000 * time.Millisecond)
+		if err := scheduler.Add(fmt.Sprintf("device%v", n), devices[n], points, 2000*time.Millisecond, nil); err != nil {
+			log.Panic(err)
 		}
-	}()
+	}
 
 	go func() {
-		timestamp := time.Now()
-		for req := range request {
-			timestamp = time.Now()
-			id, fcode := mb.Spec(req)
-			resp, warn, err := mb.Send(req)
-			if err != nil {
-				log.Panicf("error send %v[%v]: %v \n", id, fcode, err)
+		for result := range scheduler.Results(99) {
+			id := result.Client.GetID()
+			if result.Err != nil {
+				log.Panicf("error poll %v[%v]: %v \n", result.Name, id, result.Err)
 			}
-			if warn != nil {
-				log.Printf("warning send %v[%v]: %v \n", id, fcode, warn)
+			if result.Warn != nil {
+				log.Printf("warning poll %v[%v]: %v \n", result.Name, id, result.Warn)
 				continue
 			}
-			log.Printf("since %v, response %v[%v]: %v \n", time.Since(timestamp), id, fcode, resp)
+			log.Printf("took %v, late %v, response %v[%v]: %v \n", result.Duration, result.Late, result.Name, id, result.Values)
 		}
 	}()
+	scheduler.Start()
+	defer scheduler.Stop()
 
 	ossigs := make(chan os.Signal, 1)
 	signal.Notify(ossigs, os.Interrupt, os.Kill, syscall.SIGTERM)
 	for range ossigs {
+		scheduler.Stop()
 		mb.Close()
 		os.Exit(0)
 	}
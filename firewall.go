@@ -0,0 +1,289 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+)
+
+// FirewallRule matches requests by source, unit id, function code and address range,
+// empty fields match all.
+type FirewallRule struct {
+	Allow bool
+	// IP addresses or CIDR networks of the clients, requests without source (transporters) do not match
+	Sources   []string
+	Units     []byte
+	Functions []byte
+	// Range Address..Address+Quantity-1 of allow rules must contain all addresses of the request,
+	// deny rules match if any address of the request is in the range. Requests without address
+	// do not match. Zero Quantity matches all.
+	Address  uint16
+	Quantity uint16
+
+	networks []*net.IPNet
+}
+
+func (rule *FirewallRule) String() string {
+	action := "deny"
+	if rule.Allow {
+		action = "allow"
+	}
+	s := action
+	if len(rule.Sources) > 0 {
+		s += " from " + strings.Join(rule.Sources, ",")
+	}
+	if len(rule.Units) > 0 {
+		s += fmt.Sprintf(" units %v", rule.Units)
+	}
+	if len(rule.Functions) > 0 {
+		s += fmt.Sprintf(" functions %v", rule.Functions)
+	}
+	if rule.Quantity > 0 {
+		s += fmt.Sprintf(" addresses %v-%v", rule.Address, int(rule.Address)+int(rule.Quantity)-1)
+	}
+	return s
+}
+
+// matchSource reports whether the client ip matches the rule.
+func (rule *FirewallRule) matchSource(ip net.IP) bool {
+	if len(rule.networks) == 0 {
+		return true
+	}
+	for _, network := range rule.networks {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAddress reports whether the rule range contains the ranges of the request,
+// for deny rules whether it overlaps any of them.
+func (rule *FirewallRule) matchAddress(ranges [][2]uint16) bool {
+	if rule.Quantity == 0 {
+		return true
+	}
+	if len(ranges) == 0 {
+		return false
+	}
+	start, end := int(rule.Address), int(rule.Address)+int(rule.Quantity)
+	for _, r := range ranges {
+		inside := int(r[0]) >= start && int(r[0])+int(r[1]) <= end
+		overlaps := int(r[0]) < end && int(r[0])+int(r[1]) > start
+		if rule.Allow && !inside {
+			return false
+		}
+		if !rule.Allow && overlaps {
+			return true
+		}
+	}
+	return rule.Allow
+}
+
+// Firewall filters requests by rules in front of a Handler or a transporter:
+//  fw := modbus.NewFirewall(false)
+//  fw.Add(modbus.FirewallRule{Allow: true, Functions: []byte{1, 2, 3, 4}})
+//  fw.Add(modbus.FirewallRule{Allow: true, Functions: []byte{6, 16}, Address: 100, Quantity: 10})
+//  fw.Next = gw
+//  modbus.NewServer(fw).ListenAndServe(":502")
+// The first matching rule decides, requests without matching rule get the default policy.
+// Denied requests are answered with exception illegal data address (0x02) if a rule allows
+// the function at other addresses, otherwise with illegal function (0x01).
+// Denied requests are written to Audit, allowed ones too if AuditAllowed is set.
+type Firewall struct {
+	Next         Handler
+	Default      bool
+	Audit        *log.Logger
+	AuditAllowed bool
+
+	mu    sync.RWMutex
+	rules []*FirewallRule
+}
+
+// NewFirewall returns the firewall with the default policy, allow or deny.
+func NewFirewall(allow bool) *Firewall {
+	return &Firewall{Default: allow}
+}
+
+// Add appends the rule.
+func (f *Firewall) Add(rule FirewallRule) error {
+	rule.networks = nil
+	for _, source := range rule.Sources {
+		if !strings.Contains(source, "/") {
+			ip := net.ParseIP(source)
+			if ip == nil {
+				return fmt.Errorf("modbus: invalid firewall source '%v'", source)
+			}
+			if ip.To4() != nil {
+				source += "/32"
+			} else {
+				source += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(source)
+		if err != nil {
+			return fmt.Errorf("modbus: invalid firewall source '%v'", source)
+		}
+		rule.networks = append(rule.networks, network)
+	}
+	if int(rule.Address)+int(rule.Quantity) > 0x10000 {
+		return fmt.Errorf("modbus: firewall range '%v' quantity '%v' exceeds address 65535", rule.Address, rule.Quantity)
+	}
+	f.mu.Lock()
+	f.rules = append(f.rules, &rule)
+	f.mu.Unlock()
+	return nil
+}
+
+// Check returns whether the request of the client is allowed and the exception code of denial,
+// addr is nil for requests without source.
+func (f *Firewall) Check(addr net.Addr, unit byte, pdu *ProtocolDataUnit) (allow bool, exceptionCode byte) {
+	allow, exceptionCode, rule := f.check(sourceIP(addr), unit, pdu)
+	f.audit(addr, unit, pdu, allow, rule)
+	return
+}
+
+func (f *Firewall) check(ip net.IP, unit byte, pdu *ProtocolDataUnit) (bool, byte, *FirewallRule) {
+	ranges := requestRanges(pdu)
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	elsewhere := false
+	for _, rule := range f.rules {
+		if !rule.matchSource(ip) || !containsByte(rule.Units, unit) || !containsByte(rule.Functions, pdu.FunctionCode) {
+			continue
+		}
+		if !rule.matchAddress(ranges) {
+			elsewhere = elsewhere || rule.Allow
+			continue
+		}
+		if rule.Allow {
+			return true, 0, rule
+		}
+		if rule.Quantity > 0 {
+			return false, ExceptionCodeIllegalDataAddress, rule
+		}
+		return false, ExceptionCodeIllegalFunction, rule
+	}
+	if f.Default {
+		return true, 0, nil
+	}
+	if elsewhere {
+		return false, ExceptionCodeIllegalDataAddress, nil
+	}
+	return false, ExceptionCodeIllegalFunction, nil
+}
+
+func (f *Firewall) audit(addr net.Addr, unit byte, pdu *ProtocolDataUnit, allow bool, rule *FirewallRule) {
+	if f.Audit == nil || (allow && !f.AuditAllowed) {
+		return
+	}
+	action, reason := "denied", "default policy"
+	if allow {
+		action = "allowed"
+	}
+	if rule != nil {
+		reason = "rule '" + rule.String() + "'"
+	}
+	source := "transporter"
+	if addr != nil {
+		source = addr.String()
+	}
+	f.Audit.Printf("modbus: firewall %v %v unit '%v' function '%v' data % x by %v", action, source, unit, pdu.FunctionCode, pdu.Data, reason)
+}
+
+// ServeModbus passes the allowed requests to the next handler, denied broadcasts are not answered.
+func (f *Firewall) ServeModbus(req *Request) *ProtocolDataUnit {
+	if allow, code := f.Check(req.RemoteAddr, req.UnitID, req.PDU); !allow {
+		if req.UnitID == 0 {
+			return nil
+		}
+		return ExceptionResponse(req.PDU.FunctionCode, code)
+	}
+	return f.Next.ServeModbus(req)
+}
+
+// Protect returns the transporter passing the allowed requests framed in mode (rtu, ascii, tcp)
+// to mbt, the mode is detected from mbt if empty. Denied requests are answered with exception frames.
+func (f *Firewall) Protect(mode string, mbt ApiSender) ApiSender {
+	if mode == "" {
+		mode = transporterMode(mbt)
+	}
+	return &firewallSender{firewall: f, mode: strings.ToLower(mode), sender: mbt}
+}
+
+type firewallSender struct {
+	firewall *Firewall
+	mode     string
+	sender   ApiSender
+}
+
+func (fs *firewallSender) Send(aduRequest []byte) (aduResponse []byte, warn, err error) {
+	id, _ := frameSpec(fs.mode, aduRequest)
+	mbc := NewSClient(id, fs.mode)
+	pdu, err := mbc.Decode(aduRequest)
+	if err != nil {
+		return
+	}
+	allow, code := fs.firewall.Check(nil, id, pdu)
+	if allow {
+		return fs.sender.Send(aduRequest)
+	}
+	if id == 0 {
+		err = fmt.Errorf("modbus: firewall denied broadcast function '%v'", pdu.FunctionCode)
+		return
+	}
+	response := ExceptionResponse(pdu.FunctionCode, code)
+	if fs.mode == "tcp" {
+		aduResponse = tcpFrame(binary.BigEndian.Uint16(aduRequest), id, response)
+		return
+	}
+	aduResponse, err = mbc.Encode(response)
+	return
+}
+
+// sourceIP returns the ip of the address, nil if unknown.
+func sourceIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case nil:
+		return nil
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	return net.ParseIP(host)
+}
+
+// requestRanges returns the address ranges (address, quantity) of the request.
+func requestRanges(pdu *ProtocolDataUnit) (ranges [][2]uint16) {
+	if _, address, quantity, ok := writeRange(pdu); ok {
+		ranges = append(ranges, [2]uint16{address, quantity})
+	}
+	if len(pdu.Data) >= 4 {
+		switch pdu.FunctionCode {
+		case FuncCodeReadCoils, FuncCodeReadDiscreteInputs, FuncCodeReadHoldingRegisters,
+			FuncCodeReadInputRegisters, FuncCodeReadWriteMultipleRegisters:
+			ranges = append(ranges, [2]uint16{binary.BigEndian.Uint16(pdu.Data), binary.BigEndian.Uint16(pdu.Data[2:])})
+		}
+	}
+	return
+}
+
+func containsByte(list []byte, b byte) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, v := range list {
+		if v == b {
+			return true
+		}
+	}
+	return false
+}
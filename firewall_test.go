@@ -0,0 +1,238 @@
+package modbus
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+)
+
+// countingHandler answers reads with zero values and writes with the echo, counting the requests.
+type countingHandler struct {
+	mu    sync.Mutex
+	count int
+}
+
+func (h *countingHandler) ServeModbus(req *Request) *ProtocolDataUnit {
+	h.mu.Lock()
+	h.count++
+	h.mu.Unlock()
+	pdu := req.PDU
+	if _, _, quantity, ok := virtualRead(pdu); ok {
+		size := rawSize(TableHoldingRegisters, quantity)
+		if pdu.FunctionCode == FuncCodeReadCoils || pdu.FunctionCode == FuncCodeReadDiscreteInputs {
+			size = rawSize(TableCoils, quantity)
+		}
+		return &ProtocolDataUnit{FunctionCode: pdu.FunctionCode, Data: append([]byte{byte(size)}, make([]byte, size)...)}
+	}
+	if req.UnitID == 0 {
+		return nil
+	}
+	return &ProtocolDataUnit{FunctionCode: pdu.FunctionCode, Data: pdu.Data[:4]}
+}
+
+func (h *countingHandler) requests() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+// serveLoopback serves the handler on a 127.0.0.1 listener and returns the connected TCP transporter.
+func serveLoopback(t *testing.T, handler Handler) *MBTransporter {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(handler)
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	mbt := NewTransporter()
+	if err = mbt.Connect("tcp", l.Addr().String(), 0, 0, "", 0, 1000, 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mbt.Close() })
+	return mbt
+}
+
+// exceptionOf returns the exception code of the warning of a query, zero if none.
+func exceptionOf(warn error) byte {
+	var me *ModbusError
+	if errors.As(warn, &me) {
+		return me.ExceptionCode
+	}
+	return 0
+}
+
+func testFirewall(t *testing.T) *Firewall {
+	t.Helper()
+	fw := NewFirewall(false)
+	rules := []FirewallRule{
+		// unit 9 is denied to the loopback client before the allow rule below
+		{Allow: false, Sources: []string{"127.0.0.1"}, Units: []byte{9}},
+		{Allow: true, Sources: []string{"127.0.0.0/8"}, Functions: []byte{FuncCodeReadHoldingRegisters}},
+		{Allow: true, Sources: []string{"10.0.0.0/8"}, Functions: []byte{FuncCodeWriteSingleRegister}},
+		{Allow: true, Functions: []byte{FuncCodeWriteMultipleRegisters}, Address: 100, Quantity: 10},
+	}
+	for _, rule := range rules {
+		if err := fw.Add(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fw
+}
+
+func TestFirewallServer(t *testing.T) {
+	backend := &countingHandler{}
+	fw := testFirewall(t)
+	fw.Next = backend
+	mbt := serveLoopback(t, fw)
+
+	tests := []struct {
+		name      string
+		unit      byte
+		query     func(mbc *MBClient) (warn, err error)
+		exception byte
+	}{
+		{"read allowed by source network", 1, func(mbc *MBClient) (warn, err error) {
+			_, warn, err = mbc.ReadValues(mbt, TableHoldingRegisters, 0, 2)
+			return
+		}, 0},
+		{"first match denies unit", 9, func(mbc *MBClient) (warn, err error) {
+			_, warn, err = mbc.ReadValues(mbt, TableHoldingRegisters, 0, 2)
+			return
+		}, ExceptionCodeIllegalFunction},
+		{"source outside network", 1, func(mbc *MBClient) (warn, err error) {
+			return mbc.WriteValues(mbt, TableHoldingRegisters, 0, 1, []byte{0, 1})
+		}, ExceptionCodeIllegalFunction},
+		{"write in allowed range", 1, func(mbc *MBClient) (warn, err error) {
+			return mbc.WriteValues(mbt, TableHoldingRegisters, 100, 2, []byte{0, 1, 0, 2})
+		}, 0},
+		{"write crossing allowed range", 1, func(mbc *MBClient) (warn, err error) {
+			return mbc.WriteValues(mbt, TableHoldingRegisters, 108, 4, make([]byte, 8))
+		}, ExceptionCodeIllegalDataAddress},
+		{"function not allowed", 1, func(mbc *MBClient) (warn, err error) {
+			_, warn, err = mbc.ReadValues(mbt, TableInputRegisters, 0, 1)
+			return
+		}, ExceptionCodeIllegalFunction},
+	}
+	for _, test := range tests {
+		before := backend.requests()
+		warn, err := test.query(NewSClient(test.unit, "tcp"))
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		if code := exceptionOf(warn); code != test.exception {
+			t.Errorf("%v: exception '%v', expected '%v' (%v)", test.name, code, test.exception, warn)
+		}
+		if forwarded := backend.requests() - before; forwarded != 0 && test.exception != 0 || forwarded != 1 && test.exception == 0 {
+			t.Errorf("%v: '%v' requests forwarded", test.name, forwarded)
+		}
+	}
+}
+
+func TestFirewallDeniedBroadcast(t *testing.T) {
+	backend := &countingHandler{}
+	fw := testFirewall(t)
+	fw.Next = backend
+	req := &Request{
+		UnitID:     0,
+		PDU:        &ProtocolDataUnit{FunctionCode: FuncCodeWriteSingleRegister, Data: []byte{0, 1, 0, 5}},
+		RemoteAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5020},
+	}
+	if res := fw.ServeModbus(req); res != nil || backend.requests() != 0 {
+		t.Errorf("denied broadcast answered %v, '%v' requests forwarded", res, backend.requests())
+	}
+	req.RemoteAddr = &net.TCPAddr{IP: net.IPv4(10, 1, 2, 3), Port: 5020}
+	if res := fw.ServeModbus(req); res != nil || backend.requests() != 1 {
+		t.Errorf("allowed broadcast answered %v, '%v' requests forwarded", res, backend.requests())
+	}
+}
+
+func TestFirewallDenyOverlap(t *testing.T) {
+	backend := &countingHandler{}
+	fw := NewFirewall(true)
+	fw.Add(FirewallRule{Allow: false, Functions: []byte{FuncCodeWriteMultipleRegisters}, Address: 100, Quantity: 10})
+	fw.Next = backend
+	mbt := serveLoopback(t, fw)
+	mbc := NewSClient(1, "tcp")
+
+	tests := []struct {
+		address, quantity uint16
+		denied            bool
+	}{
+		{95, 10, true},
+		{105, 10, true},
+		{102, 2, true},
+		{90, 30, true},
+		{90, 10, false},
+		{110, 5, false},
+	}
+	for _, test := range tests {
+		before := backend.requests()
+		warn, err := mbc.WriteValues(mbt, TableHoldingRegisters, test.address, test.quantity, make([]byte, 2*test.quantity))
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, forwarded := exceptionOf(warn), backend.requests()-before
+		if test.denied && (code != ExceptionCodeIllegalDataAddress || forwarded != 0) || !test.denied && (code != 0 || forwarded != 1) {
+			t.Errorf("write %v-%v: exception '%v', '%v' requests forwarded", test.address, test.address+test.quantity-1, code, forwarded)
+		}
+	}
+}
+
+// senderFunc adapts the function to ApiSender.
+type senderFunc func(aduRequest []byte) ([]byte, error, error)
+
+func (f senderFunc) Send(aduRequest []byte) (aduResponse []byte, warn, err error) {
+	return f(aduRequest)
+}
+
+func TestFirewallProtect(t *testing.T) {
+	fw := NewFirewall(false)
+	fw.Add(FirewallRule{Allow: true, Functions: []byte{FuncCodeReadHoldingRegisters}, Address: 0, Quantity: 10})
+	fw.Add(FirewallRule{Allow: true, Units: []byte{0}, Functions: []byte{FuncCodeWriteSingleRegister}, Address: 50, Quantity: 1})
+
+	for _, mode := range []string{"rtu", "tcp"} {
+		sent := 0
+		backend := &countingHandler{}
+		// the device behind the transporter
+		mbt := fw.Protect(mode, senderFunc(func(aduRequest []byte) ([]byte, error, error) {
+			sent++
+			id, _ := frameSpec(mode, aduRequest)
+			mbc := NewSClient(id, mode)
+			pdu, err := mbc.Decode(aduRequest)
+			if err != nil {
+				return nil, nil, err
+			}
+			res := backend.ServeModbus(&Request{UnitID: id, PDU: pdu})
+			if res == nil {
+				return nil, nil, nil
+			}
+			if mode == "tcp" {
+				return tcpFrame(uint16(aduRequest[0])<<8|uint16(aduRequest[1]), id, res), nil, nil
+			}
+			adu, err := mbc.Encode(res)
+			return adu, nil, err
+		}))
+		mbc := NewSClient(1, mode)
+		if _, warn, err := mbc.ReadValues(mbt, TableHoldingRegisters, 0, 10); warn != nil || err != nil || sent != 1 {
+			t.Errorf("%v: allowed read: %v %v, '%v' sent", mode, warn, err, sent)
+		}
+		if _, warn, err := mbc.ReadValues(mbt, TableHoldingRegisters, 5, 10); exceptionOf(warn) != ExceptionCodeIllegalDataAddress || err != nil || sent != 1 {
+			t.Errorf("%v: read out of range: %v %v, '%v' sent", mode, warn, err, sent)
+		}
+		if warn, err := mbc.WriteValues(mbt, TableHoldingRegisters, 0, 1, []byte{0, 1}); exceptionOf(warn) != ExceptionCodeIllegalFunction || err != nil || sent != 1 {
+			t.Errorf("%v: write: %v %v, '%v' sent", mode, warn, err, sent)
+		}
+		if mode == "rtu" {
+			broadcast := NewSClient(0, mode)
+			if warn, err := broadcast.WriteValues(mbt, TableHoldingRegisters, 50, 1, []byte{0, 1}); warn != nil || err != nil || sent != 2 {
+				t.Errorf("%v: allowed broadcast: %v %v, '%v' sent", mode, warn, err, sent)
+			}
+			if _, err := broadcast.WriteValues(mbt, TableHoldingRegisters, 51, 1, []byte{0, 1}); err == nil || sent != 2 {
+				t.Errorf("%v: denied broadcast: %v, '%v' sent", mode, err, sent)
+			}
+		}
+	}
+}
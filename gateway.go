@@ -0,0 +1,151 @@
+package modbus
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Gateway is the Handler forwarding Modbus TCP requests to serial buses by unit id,
+// served by Server:
+//  gw := modbus.NewGateway()
+//  gw.Route("", mbt, 1, 2, 3)
+//  modbus.NewServer(gw).ListenAndServe(":502")
+// Requests are re-framed for the bus and queued in order of arrival, one request at a time on a bus.
+// Units without route are answered with exception gateway path unavailable (0x0A),
+// units not answering with gateway target device failed to respond (0x0B).
+// Broadcasts (unit 0) are not answered.
+type Gateway struct {
+	// Maximum wait for the bus, requests waiting longer are answered with server device busy (0x06).
+	// Zero waits for ever.
+	QueueTimeout time.Duration
+	Logger       *log.Logger
+
+	mu    sync.RWMutex
+	units map[byte]*gatewayUnit
+	buses map[ApiSender]*gatewayBus
+}
+
+type gatewayBus struct {
+	sender ApiSender
+	// token is held while a request is on the bus, blocked senders are queued in order
+	token chan struct{}
+}
+
+type gatewayUnit struct {
+	bus    *gatewayBus
+	client *MBClient
+}
+
+func NewGateway() *Gateway {
+	return &Gateway{units: make(map[byte]*gatewayUnit), buses: make(map[ApiSender]*gatewayBus)}
+}
+
+// Route maps the unit ids to the bus of the transporter, the mode (rtu, ascii, tcp) frames
+// the requests and is detected from the transporter if empty.
+// Routes of the same transporter share the bus queue.
+func (g *Gateway) Route(mode string, mbt ApiSender, units ...byte) error {
+	if mode == "" {
+		if mode = transporterMode(mbt); mode == "" {
+			return fmt.Errorf("modbus: mode of transporter '%T' is unknown", mbt)
+		}
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	bus, ok := g.buses[mbt]
+	if !ok {
+		bus = &gatewayBus{sender: mbt, token: make(chan struct{}, 1)}
+		g.buses[mbt] = bus
+	}
+	for _, id := range units {
+		g.units[id] = &gatewayUnit{bus: bus, client: NewSClient(id, mode)}
+	}
+	return nil
+}
+
+// Unroute removes the routes of the unit ids.
+func (g *Gateway) Unroute(units ...byte) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, id := range units {
+		delete(g.units, id)
+	}
+}
+
+func (g *Gateway) logf(format string, v ...interface{}) {
+	if g.Logger != nil {
+		g.Logger.Printf(format, v...)
+	}
+}
+
+// ServeModbus forwards the request to the bus of the unit and returns the response of the device.
+func (g *Gateway) ServeModbus(req *Request) *ProtocolDataUnit {
+	g.mu.RLock()
+	unit, ok := g.units[req.UnitID]
+	g.mu.RUnlock()
+	if !ok {
+		return ExceptionResponse(req.PDU.FunctionCode, ExceptionCodeGatewayPathUnavailable)
+	}
+	aduRequest, err := unit.client.ApiClient.Encode(req.PDU)
+	if err != nil {
+		g.logf("modbus: gateway unit '%v': %v", req.UnitID, err)
+		return ExceptionResponse(req.PDU.FunctionCode, ExceptionCodeGatewayPathUnavailable)
+	}
+	if !unit.bus.acquire(g.QueueTimeout) {
+		g.logf("modbus: gateway unit '%v': bus is busy", req.UnitID)
+		return ExceptionResponse(req.PDU.FunctionCode, ExceptionCodeServerDeviceBusy)
+	}
+	pdu, warn, err := unit.client.Query(unit.bus.sender, aduRequest)
+	unit.bus.release()
+	if err != nil {
+		g.logf("modbus: gateway unit '%v': %v", req.UnitID, err)
+		return ExceptionResponse(req.PDU.FunctionCode, ExceptionCodeGatewayPathUnavailable)
+	}
+	if _, ok := warn.(*ModbusError); ok {
+		return pdu
+	}
+	if warn != nil {
+		g.logf("modbus: gateway unit '%v': %v", req.UnitID, warn)
+		return ExceptionResponse(req.PDU.FunctionCode, ExceptionCodeGatewayTargetDeviceFailedToRespond)
+	}
+	return pdu
+}
+
+// acquire waits for the bus, zero timeout waits for ever.
+func (bus *gatewayBus) acquire(timeout time.Duration) bool {
+	if timeout <= 0 {
+		bus.token <- struct{}{}
+		return true
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case bus.token <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (bus *gatewayBus) release() {
+	<-bus.token
+}
+
+// transporterMode returns the mode of the known transporters.
+func transporterMode(mbt ApiSender) string {
+	if t, ok := mbt.(*MBTransporter); ok {
+		return transporterMode(t.ApiTransporter)
+	}
+	switch t := mbt.(type) {
+	case *rtuTransporter:
+		return "rtu"
+	case *asciiTransporter:
+		return "ascii"
+	case *tcpTransporter:
+		return "tcp"
+	case *unixTransporter:
+		return t.Mode()
+	}
+	return ""
+}
@@ -0,0 +1,153 @@
+package modbus
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// faultySender fails all requests with the warning or error, comparable as the gateway keys buses by sender.
+type faultySender struct {
+	warn, err error
+}
+
+func (f faultySender) Send(aduRequest []byte) (aduResponse []byte, warn, err error) {
+	return nil, f.warn, f.err
+}
+
+// heldSender signals entered and waits for hold to be closed before passing the request to the device.
+type heldSender struct {
+	device  *registerDevice
+	entered chan struct{}
+	hold    chan struct{}
+}
+
+func (h heldSender) Send(aduRequest []byte) (aduResponse []byte, warn, err error) {
+	h.entered <- struct{}{}
+	<-h.hold
+	return h.device.Send(aduRequest)
+}
+
+func gatewayRead(unit byte, address, quantity uint16) *Request {
+	return &Request{UnitID: unit, PDU: &ProtocolDataUnit{FunctionCode: FuncCodeReadHoldingRegisters,
+		Data: []byte{byte(address >> 8), byte(address), byte(quantity >> 8), byte(quantity)}}}
+}
+
+func TestGatewayRoutes(t *testing.T) {
+	dev := &registerDevice{}
+	dev.registers[0] = 0x1234
+	silent := faultySender{warn: errors.New("timeout")}
+	broken := faultySender{err: errors.New("port closed")}
+	gw := NewGateway()
+	gw.Route("rtu", dev, 1, 5)
+	gw.Route("rtu", silent, 2)
+	gw.Route("rtu", broken, 3)
+	if err := gw.Route("", dev, 4); err == nil {
+		t.Errorf("route of unknown transporter mode")
+	}
+	tests := []struct {
+		name      string
+		request   *Request
+		exception byte
+	}{
+		{"routed", gatewayRead(1, 0, 1), 0},
+		{"same bus", gatewayRead(5, 0, 1), 0},
+		{"device exception", gatewayRead(1, 15, 2), ExceptionCodeIllegalDataAddress},
+		{"no route", gatewayRead(9, 0, 1), ExceptionCodeGatewayPathUnavailable},
+		{"no response", gatewayRead(2, 0, 1), ExceptionCodeGatewayTargetDeviceFailedToRespond},
+		{"transport error", gatewayRead(3, 0, 1), ExceptionCodeGatewayPathUnavailable},
+	}
+	for _, test := range tests {
+		response := gw.ServeModbus(test.request)
+		if test.exception != 0 {
+			if response.FunctionCode != FuncCodeReadHoldingRegisters|0x80 || response.Data[0] != test.exception {
+				t.Errorf("%v: response %+v, exception '%v' expected", test.name, response, test.exception)
+			}
+			continue
+		}
+		if response.FunctionCode != FuncCodeReadHoldingRegisters || len(response.Data) != 3 || response.Data[1] != 0x12 {
+			t.Errorf("%v: response %+v", test.name, response)
+		}
+	}
+	gw.Unroute(1)
+	if response := gw.ServeModbus(gatewayRead(1, 0, 1)); response.Data[0] != ExceptionCodeGatewayPathUnavailable {
+		t.Errorf("unrouted: response %+v", response)
+	}
+}
+
+func TestGatewayQueueTimeout(t *testing.T) {
+	hold := make(chan struct{})
+	entered := make(chan struct{}, 1)
+	dev := &registerDevice{}
+	blocking := heldSender{device: dev, entered: entered, hold: hold}
+	gw := NewGateway()
+	gw.QueueTimeout = 20 * time.Millisecond
+	gw.Route("rtu", blocking, 1, 2)
+	gw.Route("rtu", dev, 3)
+	first := make(chan *ProtocolDataUnit)
+	go func() { first <- gw.ServeModbus(gatewayRead(1, 0, 1)) }()
+	<-entered
+	// the bus of units 1 and 2 is held by the first request
+	if response := gw.ServeModbus(gatewayRead(2, 0, 1)); response.FunctionCode&0x80 == 0 || response.Data[0] != ExceptionCodeServerDeviceBusy {
+		t.Errorf("queued: response %+v, busy expected", response)
+	}
+	if response := gw.ServeModbus(gatewayRead(3, 0, 1)); response.FunctionCode&0x80 != 0 {
+		t.Errorf("other bus: response %+v", response)
+	}
+	// without timeout the request waits for the bus
+	gw.QueueTimeout = 0
+	second := make(chan *ProtocolDataUnit)
+	go func() { second <- gw.ServeModbus(gatewayRead(2, 0, 1)) }()
+	select {
+	case <-second:
+		t.Fatalf("request did not wait for the bus")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(hold)
+	for n, c := range []chan *ProtocolDataUnit{first, second} {
+		if response := <-c; response.FunctionCode&0x80 != 0 {
+			t.Errorf("request %v: response %+v", n+1, response)
+		}
+	}
+}
+
+func TestGatewayServer(t *testing.T) {
+	dev := &registerDevice{}
+	dev.registers[0] = 0x1234
+	gw := NewGateway()
+	gw.Route("rtu", dev, 1)
+	gw.Route("rtu", faultySender{warn: errors.New("timeout")}, 2)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(gw)
+	go srv.Serve(l)
+	defer srv.Close()
+	mbt := NewTransporter()
+	if err = mbt.Connect("tcp", l.Addr().String(), 0, 0, "", 0, 1000, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer mbt.Close()
+	tests := []struct {
+		unit      byte
+		exception byte
+	}{
+		{1, 0},
+		{2, ExceptionCodeGatewayTargetDeviceFailedToRespond},
+		{9, ExceptionCodeGatewayPathUnavailable},
+	}
+	for _, test := range tests {
+		values, warn, err := NewSClient(test.unit, "tcp").ReadValues(mbt, TableHoldingRegisters, 0, 1)
+		var me *ModbusError
+		switch {
+		case err != nil:
+			t.Errorf("unit %v: %v", test.unit, err)
+		case test.exception == 0 && (warn != nil || len(values) != 2 || values[0] != 0x12):
+			t.Errorf("unit %v: % x %v", test.unit, values, warn)
+		case test.exception != 0 && (!errors.As(warn, &me) || me.ExceptionCode != test.exception):
+			t.Errorf("unit %v: %v, exception '%v' expected", test.unit, warn, test.exception)
+		}
+	}
+}
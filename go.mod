@@ -2,4 +2,7 @@ module github.com/xxandev/modbus
 
 go 1.18
 
-require github.com/goburrow/serial v0.1.0
+require (
+	github.com/goburrow/serial v0.1.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,327 @@
+package modbus
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// structMaps caches register maps of struct types.
+var structMaps sync.Map
+
+// Unmarshal reads the fields tagged with `modbus:"table,address[,type][,order][,scale=x][,len=n]"`
+// from the device with the minimal count of requests and fills the struct pointed by v.
+//  type Meter struct {
+//  	Power   float64 `modbus:"holding,100,float32,cdab"`
+//  	Voltage float64 `modbus:"input,0,uint16,scale=0.1"`
+//  	Relay   bool    `modbus:"coils,3"`
+//  }
+// Fields of failed requests are left unchanged, the first warning is returned.
+func Unmarshal(mbc *MBClient, mbt ApiSender, v interface{}) (warn, err error) {
+	rv, rm, err := structMap(v)
+	if err != nil {
+		return
+	}
+	values, warn, err := rm.Read(mbc, mbt)
+	if err != nil {
+		return
+	}
+	for _, tag := range rm.Tags {
+		value, ok := values[tag.Name]
+		if !ok {
+			continue
+		}
+		if err = setField(rv.FieldByIndex(structFieldIndex(rv.Type(), tag.Name)), value); err != nil {
+			err = fmt.Errorf("modbus: field '%v': %w", tag.Name, err)
+			return
+		}
+	}
+	return
+}
+
+// Marshal writes the fields of writable tables (holding registers, coils) of the struct pointed by v
+// to the device. Contiguous fields are written with one request of FC 15/16, single ones with FC 5/6.
+// Fields tagged with 'ro' option are skipped, 'wo' fields are not read by Unmarshal.
+func Marshal(mbc *MBClient, mbt ApiSender, v interface{}) (warn, err error) {
+	rv, rm, err := structMap(v)
+	if err != nil {
+		return
+	}
+	type chunk struct {
+		table    Table
+		address  uint16
+		quantity uint16
+		value    []byte
+	}
+	var tags []*Tag
+	for _, tag := range rm.Tags {
+		if tag.Access.CanWrite() {
+			tags = append(tags, tag)
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		if tags[i].Table != tags[j].Table {
+			return tags[i].Table < tags[j].Table
+		}
+		return tags[i].Address < tags[j].Address
+	})
+	env, warn, err := rm.env(mbc, mbt, tags...)
+	if err != nil || warn != nil {
+		return
+	}
+	var chunks []*chunk
+	for _, tag := range tags {
+		raw, e := tag.EncodeWith(rv.FieldByIndex(structFieldIndex(rv.Type(), tag.Name)).Interface(), env)
+		if e != nil {
+			err = fmt.Errorf("modbus: field '%v': %w", tag.Name, e)
+			return
+		}
+		quantity := tag.Quantity()
+		if n := len(chunks); n > 0 {
+			last := chunks[n-1]
+			if last.table == tag.Table && int(last.address)+int(last.quantity) == int(tag.Address) {
+				if tag.Table == TableHoldingRegisters && last.quantity+quantity <= 123 {
+					last.value = append(last.value, raw...)
+					last.quantity += quantity
+					continue
+				}
+				if tag.Table == TableCoils && last.quantity < 1968 {
+					if last.quantity%8 == 0 {
+						last.value = append(last.value, 0)
+					}
+					copyBits(last.value, int(last.quantity), raw, 0, 1)
+					last.quantity++
+					continue
+				}
+			}
+		}
+		chunks = append(chunks, &chunk{table: tag.Table, address: tag.Address, quantity: quantity, value: raw})
+	}
+	for _, c := range chunks {
+		var w error
+		if w, err = mbc.WriteValues(mbt, c.table, c.address, c.quantity, c.value); err != nil {
+			return
+		}
+		if w != nil && warn == nil {
+			warn = fmt.Errorf("modbus: write %v:%v[%v]: %w", c.table, c.address, c.quantity, w)
+		}
+	}
+	return
+}
+
+// RegisterMapOf returns the register map built from modbus tags of the struct type of v.
+func RegisterMapOf(v interface{}) (*RegisterMap, error) {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("modbus: '%T' is not a struct", v)
+	}
+	if rm, ok := structMaps.Load(t); ok {
+		return rm.(*RegisterMap), nil
+	}
+	rm := &RegisterMap{}
+	if err := structTags(t, nil, rm); err != nil {
+		return nil, err
+	}
+	if err := rm.Validate(); err != nil {
+		return nil, err
+	}
+	structMaps.Store(t, rm)
+	return rm, nil
+}
+
+// structMap returns the struct value pointed by v and its register map.
+func structMap(v interface{}) (rv reflect.Value, rm *RegisterMap, err error) {
+	rv = reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		err = fmt.Errorf("modbus: '%T' is not a pointer to struct", v)
+		return
+	}
+	rv = rv.Elem()
+	rm, err = RegisterMapOf(v)
+	return
+}
+
+// structTags collects the tags of fields, embedded structs without tag are walked in.
+// Tag names are dotted paths of the fields. Tagged fields must be exported to be set.
+func structTags(t reflect.Type, path []string, rm *RegisterMap) error {
+	for n := 0; n < t.NumField(); n++ {
+		field := t.Field(n)
+		spec, ok := field.Tag.Lookup("modbus")
+		if spec == "-" || (!ok && !(field.Anonymous && field.Type.Kind() == reflect.Struct)) {
+			continue
+		}
+		name := append(append([]string{}, path...), field.Name)
+		if ok && field.PkgPath != "" {
+			return fmt.Errorf("modbus: field '%v' is unexported", strings.Join(name, "."))
+		}
+		if !ok {
+			if err := structTags(field.Type, name, rm); err != nil {
+				return err
+			}
+			continue
+		}
+		tag, err := ParseStructTag(spec, field.Type)
+		if err != nil {
+			return fmt.Errorf("modbus: field '%v': %w", field.Name, err)
+		}
+		tag.Name = strings.Join(name, ".")
+		rm.Tags = append(rm.Tags, tag)
+	}
+	return nil
+}
+
+// structFieldIndex converts the dotted tag name to the field index.
+func structFieldIndex(t reflect.Type, name string) (index []int) {
+	for _, part := range strings.Split(name, ".") {
+		field, _ := t.FieldByName(part)
+		index = append(index, field.Index...)
+		t = field.Type
+	}
+	return
+}
+
+// ParseStructTag parses the struct tag "table,address[,type][,order][,scale=x][,len=n][,unit=u][,transform=p][,ro|rw|wo]".
+// Type is taken from the Go type of the field if omitted.
+func ParseStructTag(spec string, fieldType reflect.Type) (tag *Tag, err error) {
+	parts := strings.Split(spec, ",")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("modbus: tag '%v' needs table and address", spec)
+	}
+	tag = &Tag{WordOrder: OrderABCD}
+	if tag.Table, err = ParseTable(parts[0]); err != nil {
+		return
+	}
+	address, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 0, 16)
+	if err != nil {
+		return nil, fmt.Errorf("modbus: invalid address '%v'", parts[1])
+	}
+	tag.Address = uint16(address)
+	tag.Access = AccessRead
+	if tag.Table.IsWritable() {
+		tag.Access = AccessReadWrite
+	}
+	for _, part := range parts[2:] {
+		part = strings.TrimSpace(part)
+		key, value, option := strings.Cut(part, "=")
+		switch {
+		case option && key == "scale":
+			if tag.Scale, err = strconv.ParseFloat(value, 64); err != nil {
+				return nil, fmt.Errorf("modbus: invalid scale '%v'", value)
+			}
+		case option && (key == "len" || key == "length"):
+			length, e := strconv.ParseUint(value, 0, 16)
+			if e != nil {
+				return nil, fmt.Errorf("modbus: invalid length '%v'", value)
+			}
+			tag.Length = uint16(length)
+		case option && key == "unit":
+			tag.Unit = value
+		case option && key == "transform":
+			if tag.Transforms, err = ParsePipeline(value); err != nil {
+				return nil, err
+			}
+		case option:
+			return nil, fmt.Errorf("modbus: unknown option '%v'", key)
+		case part == "ro":
+			tag.Access = AccessRead
+		case part == "rw":
+			tag.Access = AccessReadWrite
+		case part == "wo":
+			tag.Access = AccessWrite
+		default:
+			if dt, e := ParseDataType(part); e == nil {
+				tag.Type = dt
+			} else if wo, e := ParseWordOrder(part); e == nil && part != "" {
+				tag.WordOrder = wo
+			} else {
+				return nil, fmt.Errorf("modbus: unknown tag option '%v'", part)
+			}
+		}
+	}
+	if tag.Type == "" {
+		if tag.Type, err = kindDataType(tag.Table, fieldType); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// kindDataType chooses the data type for the Go type of a field.
+func kindDataType(table Table, t reflect.Type) (DataType, error) {
+	if table.IsBit() {
+		return TypeBool, nil
+	}
+	if t == nil {
+		return TypeUint16, nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return TypeBool, nil
+	case reflect.Int8, reflect.Int16:
+		return TypeInt16, nil
+	case reflect.Int32, reflect.Int:
+		return TypeInt32, nil
+	case reflect.Int64:
+		return TypeInt64, nil
+	case reflect.Uint8, reflect.Uint16:
+		return TypeUint16, nil
+	case reflect.Uint32, reflect.Uint:
+		return TypeUint32, nil
+	case reflect.Uint64:
+		return TypeUint64, nil
+	case reflect.Float32:
+		return TypeFloat32, nil
+	case reflect.Float64:
+		return TypeFloat64, nil
+	case reflect.String:
+		return TypeString, nil
+	}
+	return "", fmt.Errorf("modbus: unsupported field type '%v'", t)
+}
+
+// setField converts the decoded value to the kind of the field.
+func setField(fv reflect.Value, value interface{}) error {
+	switch fv.Kind() {
+	case reflect.Bool:
+		v, err := toBool(value)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(v)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err := toInt64(value)
+		if err != nil {
+			return err
+		}
+		if fv.OverflowInt(v) {
+			return fmt.Errorf("value '%v' overflows %v", v, fv.Type())
+		}
+		fv.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := toUint64(value)
+		if err != nil {
+			return err
+		}
+		if fv.OverflowUint(v) {
+			return fmt.Errorf("value '%v' overflows %v", v, fv.Type())
+		}
+		fv.SetUint(v)
+	case reflect.Float32, reflect.Float64:
+		v, err := toFloat64(value)
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(v)
+	case reflect.String:
+		fv.SetString(fmt.Sprint(value))
+	default:
+		return fmt.Errorf("unsupported field type '%v'", fv.Type())
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package modbus
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type marshalStatus struct {
+	Alarm bool `modbus:"coils,3"`
+}
+
+type marshalMeter struct {
+	marshalStatus
+	Power    float64 `modbus:"holding,100,float32,cdab"`
+	Voltage  float64 `modbus:"input,0,uint16,scale=0.1"`
+	Setpoint int16   `modbus:"holding,102"`
+	Serial   string  `modbus:"input,10,len=4,ro"`
+	Ignored  int     `modbus:"-"`
+}
+
+// marshalDevice serves the simulated device of the struct register map.
+func marshalDevice(t *testing.T, v interface{}) (*SimDevice, *MBTransporter) {
+	t.Helper()
+	rm, err := RegisterMapOf(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := NewSimulator()
+	dev, err := sim.Add(1, rm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dev, serveLoopback(t, sim)
+}
+
+func TestUnmarshal(t *testing.T) {
+	dev, mbt := marshalDevice(t, &marshalMeter{})
+	// float32 1.5 in word order cdab
+	dev.SetRaw(TableHoldingRegisters, 100, 3, []byte{0x00, 0x00, 0x3F, 0xC0, 0xFF, 0xF9})
+	dev.SetRaw(TableInputRegisters, 0, 1, []byte{0x08, 0xFE})
+	dev.SetRaw(TableInputRegisters, 10, 4, []byte("SN-0042\x00"))
+	dev.SetRaw(TableCoils, 3, 1, []byte{1})
+
+	var m marshalMeter
+	m.Ignored = 5
+	if warn, err := Unmarshal(NewSClient(1, "tcp"), mbt, &m); warn != nil || err != nil {
+		t.Fatalf("%v %v", warn, err)
+	}
+	expected := marshalMeter{marshalStatus{true}, 1.5, 230.2, -7, "SN-0042", 5}
+	if m.Alarm != expected.Alarm || m.Power != expected.Power || m.Setpoint != expected.Setpoint ||
+		strings.TrimRight(m.Serial, "\x00") != expected.Serial || m.Ignored != 5 ||
+		m.Voltage < 230.19 || m.Voltage > 230.21 {
+		t.Errorf("%+v, expected %+v", m, expected)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	dev, mbt := marshalDevice(t, &marshalMeter{})
+	dev.SetRaw(TableInputRegisters, 10, 4, []byte("KEEP...."))
+	m := marshalMeter{marshalStatus{true}, -2, 1, 300, "LOST", 0}
+	if warn, err := Marshal(NewSClient(1, "tcp"), mbt, &m); warn != nil || err != nil {
+		t.Fatalf("%v %v", warn, err)
+	}
+	if raw, _ := dev.Raw(TableHoldingRegisters, 100, 3); !bytes.Equal(raw, []byte{0x00, 0x00, 0xC0, 0x00, 0x01, 0x2C}) {
+		t.Errorf("holding registers % x", raw)
+	}
+	if raw, _ := dev.Raw(TableCoils, 3, 1); len(raw) != 1 || raw[0]&1 != 1 {
+		t.Errorf("coil % x", raw)
+	}
+	// read only and input fields are not written
+	if raw, _ := dev.Raw(TableInputRegisters, 10, 4); string(raw) != "KEEP...." {
+		t.Errorf("read only field written: %q", raw)
+	}
+}
+
+func TestMarshalUnexported(t *testing.T) {
+	type meter struct {
+		Voltage uint16 `modbus:"input,0"`
+		power   uint16 `modbus:"holding,0"`
+	}
+	mbt := serveLoopback(t, &countingHandler{})
+	mbc := NewSClient(1, "tcp")
+	var m meter
+	if _, err := RegisterMapOf(&m); err == nil || !strings.Contains(err.Error(), "power") {
+		t.Errorf("register map: %v", err)
+	}
+	if _, err := Unmarshal(mbc, mbt, &m); err == nil {
+		t.Errorf("unmarshal: no error")
+	}
+	if _, err := Marshal(mbc, mbt, &m); err == nil {
+		t.Errorf("marshal: no error")
+	}
+	_ = m.power
+}
+
+func TestParseStructTag(t *testing.T) {
+	tests := []struct {
+		spec string
+		tag  Tag
+	}{
+		{"holding,5", Tag{Table: TableHoldingRegisters, Address: 5, Type: TypeUint16, WordOrder: OrderABCD, Access: AccessReadWrite}},
+		{"input,0x10,int32,dcba,scale=0.5,unit=W", Tag{Table: TableInputRegisters, Address: 16, Type: TypeInt32, WordOrder: OrderDCBA, Access: AccessRead, Scale: 0.5, Unit: "W"}},
+		{"holding,1,string,len=8,wo", Tag{Table: TableHoldingRegisters, Address: 1, Type: TypeString, WordOrder: OrderABCD, Access: AccessWrite, Length: 8}},
+		{"coils,2", Tag{Table: TableCoils, Address: 2, Type: TypeBool, WordOrder: OrderABCD, Access: AccessReadWrite}},
+	}
+	for _, test := range tests {
+		tag, err := ParseStructTag(test.spec, nil)
+		if err != nil {
+			t.Errorf("%v: %v", test.spec, err)
+			continue
+		}
+		if tag.Table != test.tag.Table || tag.Address != test.tag.Address || tag.Type != test.tag.Type || tag.WordOrder != test.tag.WordOrder ||
+			tag.Access != test.tag.Access || tag.Scale != test.tag.Scale || tag.Unit != test.tag.Unit || tag.Length != test.tag.Length {
+			t.Errorf("%v: %+v, expected %+v", test.spec, *tag, test.tag)
+		}
+	}
+	for _, spec := range []string{"holding", "holding,x", "bogus,1", "holding,1,scale=x", "holding,1,color=red", "holding,1,wide"} {
+		if _, err := ParseStructTag(spec, nil); err == nil {
+			t.Errorf("%v: error expected", spec)
+		}
+	}
+}
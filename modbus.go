@@ -3,6 +3,7 @@ package modbus
 import (
 	"encoding/binary"
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -47,6 +48,11 @@ const (
 	rtuMinSize       = 4
 	rtuMaxSize       = 256
 	rtuExceptionSize = 5
+
+	serialBroadcastAddress = 0
+	serialMaxAddress       = 247
+	serialTurnaroundDelay  = 100 * time.Millisecond // Serial line guide recommends 100ms to 200ms
+	serialTimeout          = time.Second            // Response timeout if Timeout is not set
 )
 
 var (
@@ -125,6 +131,63 @@ func (e *ModbusError) Error() string {
 	return fmt.Sprintf("modbus: exception '%v' (%s), function '%v'", e.ExceptionCode, name, e.FunctionCode)
 }
 
+// Table is a block of the modbus data model.
+type Table byte
+
+const (
+	TableCoils Table = iota + 1
+	TableDiscreteInputs
+	TableHoldingRegisters
+	TableInputRegisters
+)
+
+// ParseTable converts table name (coils, discrete, holding, input) to Table.
+func ParseTable(name string) (Table, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "coils", "coil":
+		return TableCoils, nil
+	case "discrete", "discreteinputs", "discrete_inputs":
+		return TableDiscreteInputs, nil
+	case "holding", "holdingregisters", "holding_registers":
+		return TableHoldingRegisters, nil
+	case "input", "inputregisters", "input_registers":
+		return TableInputRegisters, nil
+	}
+	return 0, fmt.Errorf("modbus: unknown table '%v'", name)
+}
+
+func (t Table) String() string {
+	switch t {
+	case TableCoils:
+		return "coils"
+	case TableDiscreteInputs:
+		return "discrete"
+	case TableHoldingRegisters:
+		return "holding"
+	case TableInputRegisters:
+		return "input"
+	}
+	return fmt.Sprintf("table(%d)", byte(t))
+}
+
+// IsBit reports whether the table holds single bits instead of 16-bit registers.
+func (t Table) IsBit() bool {
+	return t == TableCoils || t == TableDiscreteInputs
+}
+
+// IsWritable reports whether the table can be written by the client.
+func (t Table) IsWritable() bool {
+	return t == TableCoils || t == TableHoldingRegisters
+}
+
+// MaxRead returns the maximum quantity of one read request.
+func (t Table) MaxRead() uint16 {
+	if t.IsBit() {
+		return 2000
+	}
+	return 125
+}
+
 // ProtocolDataUnit (PDU) is independent of underlying communication layers.
 type ProtocolDataUnit struct {
 	FunctionCode byte
@@ -206,6 +269,34 @@ func dataBlockSuffix(suffix []byte, value ...uint16) []byte {
 	return data
 }
 
+// isBroadcast reports whether a request with slave id and function code is a serial line broadcast.
+// Only write requests may be broadcast, the slaves do not reply to them.
+func isBroadcast(id, function byte) bool {
+	if id != serialBroadcastAddress {
+		return false
+	}
+	switch function {
+	case FuncCodeWriteSingleCoil,
+		FuncCodeWriteSingleRegister,
+		FuncCodeWriteMultipleCoils,
+		FuncCodeWriteMultipleRegisters:
+		return true
+	}
+	return false
+}
+
+// verifySerialAddress rejects slave ids reserved by the serial line specification
+// and broadcasts of functions which require a response.
+func verifySerialAddress(id, function byte) error {
+	if id > serialMaxAddress {
+		return fmt.Errorf("modbus: slave id '%v' is reserved, must be between '%v' and '%v'", id, serialBroadcastAddress, serialMaxAddress)
+	}
+	if id == serialBroadcastAddress && !isBroadcast(id, function) {
+		return fmt.Errorf("modbus: function '%v' can not be broadcast", function)
+	}
+	return nil
+}
+
 func responseError(response *ProtocolDataUnit) error {
 	mbError := &ModbusError{FunctionCode: response.FunctionCode}
 	if response.Data != nil && len(response.Data) > 0 {
@@ -0,0 +1,503 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Priorities of requests queued by Mux, higher priorities are sent first.
+const (
+	// PriorityAuto queues writes as interactive and other requests as normal
+	PriorityAuto byte = iota
+	PriorityBulk
+	PriorityNormal
+	PriorityInteractive
+)
+
+// Status of Mux responses.
+const (
+	muxStatusOK   = 0
+	muxStatusWarn = 1
+	muxStatusErr  = 2
+)
+
+// muxMaxLength is the maximum length of the ADU in the mux protocol.
+const muxMaxLength = 1024
+
+// Mux owns a transporter, usually the only handle of a serial port, and shares it with other
+// processes over a Unix domain socket:
+//  mbt := modbus.NewTransporter()
+//  mbt.Connect("rtu", "/dev/ttyUSB0", 19200, 8, "E", 1, 500, 0)
+//  mux := modbus.NewMux(mbt)
+//  mux.ListenAndServe("/run/modbus/ttyUSB0.sock")
+// Clients connect with mode "unix" and the socket path as address.
+// The protocol frames are
+//  hello (server):   length: 1 byte, mode: n bytes
+//  request:          priority: 1 byte, length: 2 bytes, ADU: n bytes
+//  response:         status: 1 byte (0 ok, 1 warning, 2 error), length: 2 bytes, ADU or message: n bytes
+// Requests are sent one at a time in order of priority. Requests of the same priority are queued
+// fairly, the connection served least recently goes first.
+type Mux struct {
+	Transporter ApiSender
+	// Framing (rtu, ascii, tcp) of the ADUs, detected from the transporter
+	Mode   string
+	Logger *log.Logger
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	pending   []*muxRequest
+	seq       uint64
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]*muxConn
+	running   bool
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+type muxConn struct {
+	// sequence of the last request sent
+	served uint64
+}
+
+type muxRequest struct {
+	conn     *muxConn
+	priority byte
+	seq      uint64
+	adu      []byte
+	done     chan muxResult
+}
+
+type muxResult struct {
+	response  []byte
+	warn, err error
+}
+
+func NewMux(mbt ApiSender) *Mux {
+	mode := transporterMode(mbt)
+	if mode == "" {
+		mode = "rtu"
+	}
+	m := &Mux{Transporter: mbt, Mode: mode, listeners: make(map[net.Listener]struct{}), conns: make(map[net.Conn]*muxConn)}
+	m.cond = sync.NewCond(&m.mu)
+	return m
+}
+
+func (m *Mux) logf(format string, v ...interface{}) {
+	if m.Logger != nil {
+		m.Logger.Printf(format, v...)
+	}
+}
+
+// ListenAndServe listens on the Unix socket path and serves connections until Close.
+// A stale socket file without listener is removed.
+func (m *Mux) ListenAndServe(path string) error {
+	path = strings.TrimPrefix(path, "unix://")
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return fmt.Errorf("modbus: socket '%v' is in use", path)
+		}
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	return m.Serve(l)
+}
+
+// Serve accepts connections of the listener until Close, the listener is closed on return.
+func (m *Mux) Serve(l net.Listener) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		l.Close()
+		return fmt.Errorf("modbus: mux is closed")
+	}
+	m.listeners[l] = struct{}{}
+	if !m.running {
+		m.running = true
+		m.wg.Add(1)
+		go m.run()
+	}
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.listeners, l)
+		m.mu.Unlock()
+		l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			m.mu.Lock()
+			closed := m.closed
+			m.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		m.mu.Lock()
+		if m.closed {
+			m.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		mc := &muxConn{}
+		m.conns[conn] = mc
+		m.wg.Add(1)
+		m.mu.Unlock()
+		go m.serveConn(conn, mc)
+	}
+}
+
+// Close stops the listeners, closes the connections and waits for the queue.
+func (m *Mux) Close() error {
+	m.mu.Lock()
+	m.closed = true
+	for l := range m.listeners {
+		l.Close()
+	}
+	for conn := range m.conns {
+		conn.Close()
+	}
+	m.cond.Broadcast()
+	m.mu.Unlock()
+	m.wg.Wait()
+	return nil
+}
+
+// Pending returns count of queued requests.
+func (m *Mux) Pending() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.pending)
+}
+
+// serveConn reads the requests of the connection and writes the responses in order.
+func (m *Mux) serveConn(conn net.Conn, mc *muxConn) {
+	defer func() {
+		m.mu.Lock()
+		delete(m.conns, conn)
+		m.mu.Unlock()
+		conn.Close()
+		m.wg.Done()
+	}()
+	hello := append([]byte{byte(len(m.Mode))}, m.Mode...)
+	if _, err := conn.Write(hello); err != nil {
+		return
+	}
+	var header [3]byte
+	for {
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			if err != io.EOF {
+				m.logf("modbus: mux connection: %v", err)
+			}
+			return
+		}
+		length := int(binary.BigEndian.Uint16(header[1:]))
+		if length == 0 || length > muxMaxLength {
+			m.logf("modbus: mux connection: invalid length '%v'", length)
+			return
+		}
+		adu := make([]byte, length)
+		if _, err := io.ReadFull(conn, adu); err != nil {
+			m.logf("modbus: mux connection: %v", err)
+			return
+		}
+		req := &muxRequest{conn: mc, priority: header[0], adu: adu, done: make(chan muxResult, 1)}
+		if req.priority == PriorityAuto {
+			req.priority = PriorityNormal
+			if _, function := frameSpec(m.Mode, adu); isWriteFunction(function) {
+				req.priority = PriorityInteractive
+			}
+		}
+		if !m.enqueue(req) {
+			return
+		}
+		res := <-req.done
+		status, payload := byte(muxStatusOK), res.response
+		if res.err != nil {
+			status, payload = muxStatusErr, []byte(res.err.Error())
+		} else if res.warn != nil {
+			status, payload = muxStatusWarn, []byte(res.warn.Error())
+		}
+		if len(payload) > muxMaxLength {
+			payload = payload[:muxMaxLength]
+		}
+		frame := make([]byte, 3+len(payload))
+		frame[0] = status
+		binary.BigEndian.PutUint16(frame[1:], uint16(len(payload)))
+		copy(frame[3:], payload)
+		if _, err := conn.Write(frame); err != nil {
+			m.logf("modbus: mux connection: %v", err)
+			return
+		}
+	}
+}
+
+func (m *Mux) enqueue(req *muxRequest) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return false
+	}
+	m.seq++
+	req.seq = m.seq
+	m.pending = append(m.pending, req)
+	m.cond.Signal()
+	return true
+}
+
+// next removes the request of the highest priority, of the connection served least recently
+// and the oldest of it. Caller must hold the mutex.
+func (m *Mux) next() *muxRequest {
+	best := -1
+	for n, req := range m.pending {
+		if best < 0 {
+			best = n
+			continue
+		}
+		b := m.pending[best]
+		if req.priority > b.priority ||
+			req.priority == b.priority && (req.conn.served < b.conn.served ||
+				req.conn.served == b.conn.served && req.seq < b.seq) {
+			best = n
+		}
+	}
+	req := m.pending[best]
+	m.pending = append(m.pending[:best], m.pending[best+1:]...)
+	m.seq++
+	req.conn.served = m.seq
+	return req
+}
+
+// run sends the queued requests one at a time until Close.
+func (m *Mux) run() {
+	defer m.wg.Done()
+	for {
+		m.mu.Lock()
+		for len(m.pending) == 0 && !m.closed {
+			m.cond.Wait()
+		}
+		if m.closed {
+			for _, req := range m.pending {
+				req.done <- muxResult{err: fmt.Errorf("modbus: mux is closed")}
+			}
+			m.pending = nil
+			m.mu.Unlock()
+			return
+		}
+		req := m.next()
+		m.mu.Unlock()
+		var res muxResult
+		res.response, res.warn, res.err = m.Transporter.Send(req.adu)
+		req.done <- res
+	}
+}
+
+// isWriteFunction reports whether the function writes coils or registers.
+func isWriteFunction(function byte) bool {
+	switch function {
+	case FuncCodeWriteSingleCoil, FuncCodeWriteMultipleCoils, FuncCodeWriteSingleRegister,
+		FuncCodeWriteMultipleRegisters, FuncCodeMaskWriteRegister, FuncCodeReadWriteMultipleRegisters:
+		return true
+	}
+	return false
+}
+
+// unixTransporter sends requests to Mux over a Unix domain socket.
+type unixTransporter struct {
+	// Socket path
+	Address string
+	// Connect & request timeout, including the wait in the queue
+	Timeout time.Duration
+	// Idle timeout to close the connection
+	IdleTimeout time.Duration
+	// Priority of the requests
+	Priority byte
+	Logger   *log.Logger
+
+	mu           sync.Mutex
+	conn         net.Conn
+	mode         string
+	closeTimer   *time.Timer
+	lastActivity time.Time
+}
+
+func (ux *unixTransporter) GetAddress() string {
+	return ux.Address
+}
+
+func (ux *unixTransporter) SetLogger(logger *log.Logger) {
+	ux.Logger = logger
+}
+
+func (ux *unixTransporter) Set(address string, timeout, idletimeout int64) {
+	ux.Address = strings.TrimPrefix(address, "unix://")
+	ux.Timeout = time.Duration(timeout) * time.Millisecond
+	ux.IdleTimeout = time.Duration(idletimeout) * time.Millisecond
+}
+
+func (ux *unixTransporter) SetPriority(priority byte) {
+	ux.mu.Lock()
+	ux.Priority = priority
+	ux.mu.Unlock()
+}
+
+// Mode returns the framing of the multiplexed transporter, empty before the first connection.
+func (ux *unixTransporter) Mode() string {
+	ux.mu.Lock()
+	defer ux.mu.Unlock()
+	return ux.mode
+}
+
+func (ux *unixTransporter) Spec(aduReqRes []byte) (byte, byte) {
+	ux.mu.Lock()
+	mode := ux.mode
+	ux.mu.Unlock()
+	return frameSpec(mode, aduReqRes)
+}
+
+func (ux *unixTransporter) logf(format string, v ...interface{}) {
+	if ux.Logger != nil {
+		ux.Logger.Printf(format, v...)
+	}
+}
+
+func (ux *unixTransporter) Connect() error {
+	ux.mu.Lock()
+	defer ux.mu.Unlock()
+
+	return ux.connect()
+}
+
+// connect connects and reads the hello of the mux. Caller must hold the mutex.
+func (ux *unixTransporter) connect() error {
+	if ux.conn != nil {
+		return nil
+	}
+	dialer := net.Dialer{Timeout: ux.Timeout}
+	conn, err := dialer.Dial("unix", ux.Address)
+	if err != nil {
+		return err
+	}
+	if ux.Timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(ux.Timeout))
+	}
+	var length [1]byte
+	if _, err = io.ReadFull(conn, length[:]); err == nil {
+		mode := make([]byte, length[0])
+		if _, err = io.ReadFull(conn, mode); err == nil {
+			ux.mode = string(mode)
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("modbus: mux hello: %w", err)
+	}
+	ux.conn = conn
+	return nil
+}
+
+func (ux *unixTransporter) Close() error {
+	ux.mu.Lock()
+	defer ux.mu.Unlock()
+
+	return ux.close()
+}
+
+// close closes the connection. Caller must hold the mutex.
+func (ux *unixTransporter) close() (err error) {
+	if ux.conn != nil {
+		err = ux.conn.Close()
+		ux.conn = nil
+	}
+	return
+}
+
+func (ux *unixTransporter) startCloseTimer() {
+	if ux.IdleTimeout <= 0 {
+		return
+	}
+	if ux.closeTimer == nil {
+		ux.closeTimer = time.AfterFunc(ux.IdleTimeout, ux.closeIdle)
+	} else {
+		ux.closeTimer.Reset(ux.IdleTimeout)
+	}
+}
+
+// closeIdle closes the connection if last activity is passed behind IdleTimeout.
+func (ux *unixTransporter) closeIdle() {
+	ux.mu.Lock()
+	defer ux.mu.Unlock()
+
+	if ux.IdleTimeout > 0 && time.Since(ux.lastActivity) >= ux.IdleTimeout {
+		ux.logf("modbus: closing connection due to idle timeout")
+		ux.close()
+	}
+}
+
+// Send queues the request at the mux and waits for the response.
+// Warnings and errors of the multiplexed transporter are returned as such.
+func (ux *unixTransporter) Send(aduRequest []byte) (aduResponse []byte, warn, err error) {
+	ux.mu.Lock()
+	defer ux.mu.Unlock()
+
+	if len(aduRequest) == 0 || len(aduRequest) > muxMaxLength {
+		err = fmt.Errorf("modbus: request length '%v' is invalid", len(aduRequest))
+		return
+	}
+	if err = ux.connect(); err != nil {
+		return
+	}
+	ux.lastActivity = time.Now()
+	ux.startCloseTimer()
+	var deadline time.Time
+	if ux.Timeout > 0 {
+		deadline = ux.lastActivity.Add(ux.Timeout)
+	}
+	if err = ux.conn.SetDeadline(deadline); err != nil {
+		return
+	}
+	frame := make([]byte, 3+len(aduRequest))
+	frame[0] = ux.Priority
+	binary.BigEndian.PutUint16(frame[1:], uint16(len(aduRequest)))
+	copy(frame[3:], aduRequest)
+	ux.logf("modbus: sending % x\n", aduRequest)
+	if _, err = ux.conn.Write(frame); err != nil {
+		ux.close()
+		return
+	}
+	var header [3]byte
+	if _, err = io.ReadFull(ux.conn, header[:]); err == nil {
+		aduResponse = make([]byte, binary.BigEndian.Uint16(header[1:]))
+		_, err = io.ReadFull(ux.conn, aduResponse)
+	}
+	if err != nil {
+		// the response of a timed out request would be read by the next one
+		ux.close()
+		return nil, nil, err
+	}
+	switch header[0] {
+	case muxStatusOK:
+		if len(aduResponse) == 0 {
+			aduResponse = nil
+		}
+		ux.logf("modbus: received % x\n", aduResponse)
+	case muxStatusWarn:
+		return nil, errors.New(string(aduResponse)), nil
+	default:
+		return nil, nil, errors.New(string(aduResponse))
+	}
+	return
+}
@@ -0,0 +1,143 @@
+package modbus
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMuxNext(t *testing.T) {
+	type queued struct {
+		conn     int
+		priority byte
+	}
+	tests := []struct {
+		name    string
+		served  []uint64 // of the connections
+		pending []queued // in order of arrival
+		order   []int    // indexes of pending in order sent
+	}{
+		{"priority", []uint64{0, 0}, []queued{{0, PriorityBulk}, {0, PriorityNormal}, {1, PriorityInteractive}}, []int{2, 1, 0}},
+		{"arrival", []uint64{0}, []queued{{0, PriorityNormal}, {0, PriorityNormal}, {0, PriorityNormal}}, []int{0, 1, 2}},
+		{"fair", []uint64{0, 0}, []queued{{0, PriorityNormal}, {0, PriorityNormal}, {0, PriorityNormal}, {1, PriorityNormal}}, []int{0, 3, 1, 2}},
+		{"served least recently first", []uint64{5, 3}, []queued{{0, PriorityNormal}, {1, PriorityNormal}}, []int{1, 0}},
+		{"priority before fairness", []uint64{5, 3}, []queued{{1, PriorityNormal}, {0, PriorityInteractive}, {1, PriorityBulk}}, []int{1, 0, 2}},
+	}
+	for _, test := range tests {
+		m := NewMux(&registerDevice{})
+		conns := make([]*muxConn, len(test.served))
+		for n, served := range test.served {
+			conns[n] = &muxConn{served: served}
+		}
+		requests := make(map[*muxRequest]int)
+		for n, q := range test.pending {
+			req := &muxRequest{conn: conns[q.conn], priority: q.priority}
+			requests[req] = n
+			m.enqueue(req)
+		}
+		var order []int
+		for len(m.pending) > 0 {
+			order = append(order, requests[m.next()])
+		}
+		if len(order) != len(test.order) {
+			t.Errorf("%v: order %v, %v expected", test.name, order, test.order)
+			continue
+		}
+		for n := range order {
+			if order[n] != test.order[n] {
+				t.Errorf("%v: order %v, %v expected", test.name, order, test.order)
+				break
+			}
+		}
+	}
+}
+
+// muxRecorder records the unit ids of the requests in order of sending, the first request
+// waits for hold to be closed.
+type muxRecorder struct {
+	device  *registerDevice
+	entered chan struct{}
+	hold    chan struct{}
+	units   []byte
+}
+
+func (r *muxRecorder) Send(aduRequest []byte) (aduResponse []byte, warn, err error) {
+	if len(r.units) == 0 {
+		r.entered <- struct{}{}
+		<-r.hold
+	}
+	r.units = append(r.units, aduRequest[0])
+	return r.device.Send(aduRequest)
+}
+
+func TestMuxPriority(t *testing.T) {
+	backend := &muxRecorder{device: &registerDevice{}, entered: make(chan struct{}), hold: make(chan struct{})}
+	m := NewMux(backend)
+	path := filepath.Join(t.TempDir(), "mux.sock")
+	go m.ListenAndServe(path)
+	defer m.Close()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		mbt := NewTransporter()
+		if err := mbt.Connect("unix", path, 0, 0, "", 0, 1000, 0); err == nil {
+			mbt.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("mux not listening")
+		}
+	}
+	requests := []struct {
+		unit     byte
+		priority byte
+		write    bool
+	}{
+		{1, PriorityNormal, false}, // sent first, the others are queued
+		{2, PriorityBulk, false},
+		{3, PriorityAuto, false},
+		{4, PriorityAuto, true},
+		{5, PriorityInteractive, false},
+		{6, PriorityNormal, false},
+	}
+	done := make(chan error, len(requests))
+	for n, request := range requests {
+		mbt := NewTransporter()
+		if err := mbt.Connect("unix", path, 0, 0, "", 0, 1000, 0); err != nil {
+			t.Fatal(err)
+		}
+		defer mbt.Close()
+		mbt.SetPriority(request.priority)
+		mbc := NewSClient(request.unit, "rtu")
+		go func(write bool) {
+			var warn, err error
+			if write {
+				warn, err = mbc.WriteValues(mbt, TableHoldingRegisters, 0, 1, []byte{0, 1})
+			} else {
+				_, warn, err = mbc.ReadValues(mbt, TableHoldingRegisters, 0, 1)
+			}
+			if err == nil {
+				err = warn
+			}
+			done <- err
+		}(request.write)
+		// queued in order
+		if n == 0 {
+			<-backend.entered
+			continue
+		}
+		for deadline := time.Now().Add(time.Second); m.Pending() < n; time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("request %v not queued", n)
+			}
+		}
+	}
+	close(backend.hold)
+	for range requests {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+	expected := []byte{1, 4, 5, 3, 6, 2}
+	if string(backend.units) != string(expected) {
+		t.Errorf("units sent %v, %v expected", backend.units, expected)
+	}
+}
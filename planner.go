@@ -0,0 +1,204 @@
+package modbus
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Point is a range of registers or bits of one table.
+type Point struct {
+	Table   Table
+	Address uint16
+	Length  uint16 // registers or bits
+}
+
+func (p Point) end() int {
+	return int(p.Address) + int(p.Length)
+}
+
+// Block is one read request of a plan.
+type Block struct {
+	Table    Table
+	Address  uint16
+	Quantity uint16
+}
+
+func (b Block) end() int {
+	return int(b.Address) + int(b.Quantity)
+}
+
+// Planner merges and splits points into the minimal list of read requests.
+type Planner struct {
+	// Unused registers or bits allowed between merged points
+	MaxGap uint16
+	// Device limits of one request, zero uses the spec limits (125 registers, 2000 bits)
+	MaxRegisters uint16
+	MaxBits      uint16
+}
+
+func NewPlanner(maxGap uint16) *Planner {
+	return &Planner{MaxGap: maxGap}
+}
+
+// ReadPlan is the list of read requests covering the points.
+type ReadPlan struct {
+	Points []Point
+	Blocks []Block
+}
+
+func (pl *Planner) maxQuantity(table Table) uint16 {
+	max := table.MaxRead()
+	limit := pl.MaxRegisters
+	if table.IsBit() {
+		limit = pl.MaxBits
+	}
+	if limit > 0 && limit < max {
+		max = limit
+	}
+	return max
+}
+
+// Plan merges points of the same table closer than MaxGap and splits the ranges at the request limits.
+// Points longer than the limit are split over consecutive blocks.
+func (pl *Planner) Plan(points []Point) (*ReadPlan, error) {
+	byTable := make(map[Table][]Point)
+	for _, p := range points {
+		if p.Length < 1 {
+			return nil, fmt.Errorf("modbus: length of point %v:%v must not be zero", p.Table, p.Address)
+		}
+		if p.end() > 0x10000 {
+			return nil, fmt.Errorf("modbus: point %v:%v length '%v' exceeds address space", p.Table, p.Address, p.Length)
+		}
+		if p.Table < TableCoils || p.Table > TableInputRegisters {
+			return nil, fmt.Errorf("modbus: unknown table '%v'", p.Table)
+		}
+		byTable[p.Table] = append(byTable[p.Table], p)
+	}
+	tables := make([]Table, 0, len(byTable))
+	for table := range byTable {
+		tables = append(tables, table)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i] < tables[j] })
+
+	plan := &ReadPlan{Points: points}
+	for _, table := range tables {
+		sorted := byTable[table]
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Address < sorted[j].Address })
+		plan.Blocks = append(plan.Blocks, pl.split(table, sorted)...)
+	}
+	return plan, nil
+}
+
+// split builds blocks of the points sorted by address.
+func (pl *Planner) split(table Table, points []Point) (blocks []Block) {
+	max := int(pl.maxQuantity(table))
+	emit := func(start, end int) {
+		blocks = append(blocks, Block{Table: table, Address: uint16(start), Quantity: uint16(end - start)})
+	}
+	start, end := -1, -1
+	for _, p := range points {
+		ps, pe := int(p.Address), p.end()
+		if pe <= end {
+			// Already covered
+			continue
+		}
+		if start >= 0 && ps <= end+int(pl.MaxGap) && pe-start <= max {
+			end = pe
+			continue
+		}
+		if start >= 0 {
+			emit(start, end)
+		}
+		if ps < end {
+			// Overlapping point, read the rest only
+			ps = end
+		}
+		for pe-ps > max {
+			emit(ps, ps+max)
+			ps += max
+		}
+		start, end = ps, pe
+	}
+	if start >= 0 {
+		emit(start, end)
+	}
+	return
+}
+
+// Requests encodes read requests of the blocks.
+func (rp *ReadPlan) Requests(mbc *MBClient) (aduRequests [][]byte, err error) {
+	aduRequests = make([][]byte, len(rp.Blocks))
+	for n, b := range rp.Blocks {
+		if aduRequests[n], err = mbc.Read(b.Table, b.Address, b.Quantity); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Execute reads all blocks and maps the response back to the points.
+// Registers of a point are returned as 2*Length bytes, bits are packed with the first bit in LSB.
+// Points of failed blocks are nil, the first warning is returned.
+func (rp *ReadPlan) Execute(mbc *MBClient, mbt ApiSender) (values [][]byte, warn, err error) {
+	data := make([][]byte, len(rp.Blocks))
+	for n, b := range rp.Blocks {
+		var w error
+		data[n], w, err = mbc.ReadValues(mbt, b.Table, b.Address, b.Quantity)
+		if err != nil {
+			return
+		}
+		if w != nil && warn == nil {
+			warn = fmt.Errorf("modbus: read %v:%v[%v]: %w", b.Table, b.Address, b.Quantity, w)
+		}
+	}
+	values = rp.Map(data)
+	return
+}
+
+// Map slices the data of the blocks into values of the points.
+// data must have the same order as Blocks, points not fully covered by data are nil.
+func (rp *ReadPlan) Map(data [][]byte) (values [][]byte) {
+	values = make([][]byte, len(rp.Points))
+	for i, p := range rp.Points {
+		size := int(p.Length) * 2
+		if p.Table.IsBit() {
+			size = (int(p.Length) + 7) / 8
+		}
+		value := make([]byte, size)
+		covered := 0
+		for n, b := range rp.Blocks {
+			if b.Table != p.Table || data[n] == nil || b.end() <= int(p.Address) || int(b.Address) >= p.end() {
+				continue
+			}
+			from, to := int(p.Address), p.end()
+			if int(b.Address) > from {
+				from = int(b.Address)
+			}
+			if b.end() < to {
+				to = b.end()
+			}
+			if p.Table.IsBit() {
+				copyBits(value, from-int(p.Address), data[n], from-int(b.Address), to-from)
+			} else {
+				copy(value[(from-int(p.Address))*2:], data[n][(from-int(b.Address))*2:(to-int(b.Address))*2])
+			}
+			covered += to - from
+		}
+		if covered >= int(p.Length) {
+			values[i] = value
+		}
+	}
+	return
+}
+
+// copyBits copies n bits packed in LSB first order.
+func copyBits(dst []byte, dstOffset int, src []byte, srcOffset, n int) {
+	for i := 0; i < n; i++ {
+		s, d := srcOffset+i, dstOffset+i
+		if src[s/8]&(1<<uint(s%8)) != 0 {
+			dst[d/8] |= 1 << uint(d%8)
+		} else {
+			dst[d/8] &^= 1 << uint(d%8)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package modbus
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestPlannerPlan(t *testing.T) {
+	hr, ir, co := TableHoldingRegisters, TableInputRegisters, TableCoils
+	tests := []struct {
+		name    string
+		planner Planner
+		points  []Point
+		blocks  []Block
+	}{
+		{"adjacent merged", Planner{}, []Point{{hr, 0, 2}, {hr, 2, 2}},
+			[]Block{{hr, 0, 4}}},
+		{"gap without MaxGap", Planner{}, []Point{{hr, 0, 2}, {hr, 3, 1}},
+			[]Block{{hr, 0, 2}, {hr, 3, 1}}},
+		{"gap within MaxGap", Planner{MaxGap: 5}, []Point{{hr, 0, 2}, {hr, 7, 1}},
+			[]Block{{hr, 0, 8}}},
+		{"gap beyond MaxGap", Planner{MaxGap: 5}, []Point{{hr, 0, 2}, {hr, 8, 1}},
+			[]Block{{hr, 0, 2}, {hr, 8, 1}}},
+		{"unsorted and overlapping", Planner{}, []Point{{hr, 10, 4}, {hr, 0, 2}, {hr, 11, 1}, {hr, 1, 3}},
+			[]Block{{hr, 0, 4}, {hr, 10, 4}}},
+		{"merge up to MaxRead", Planner{MaxGap: 10}, []Point{{hr, 0, 100}, {hr, 100, 25}, {hr, 125, 1}},
+			[]Block{{hr, 0, 125}, {hr, 125, 1}}},
+		{"long point split at MaxRead", Planner{}, []Point{{hr, 0, 300}},
+			[]Block{{hr, 0, 125}, {hr, 125, 125}, {hr, 250, 50}}},
+		{"device limit", Planner{MaxRegisters: 10}, []Point{{hr, 0, 8}, {hr, 8, 4}},
+			[]Block{{hr, 0, 8}, {hr, 8, 4}}},
+		{"overlap beyond limit reads the rest", Planner{MaxRegisters: 10}, []Point{{hr, 0, 8}, {hr, 6, 8}},
+			[]Block{{hr, 0, 8}, {hr, 8, 6}}},
+		{"bits limit", Planner{}, []Point{{co, 0, 2500}},
+			[]Block{{co, 0, 2000}, {co, 2000, 500}}},
+		{"mixed tables", Planner{MaxGap: 100}, []Point{{ir, 0, 1}, {hr, 5, 1}, {co, 3, 1}, {hr, 0, 1}, {ir, 1, 1}},
+			[]Block{{co, 3, 1}, {hr, 0, 6}, {ir, 0, 2}}},
+	}
+	for _, test := range tests {
+		plan, err := test.planner.Plan(test.points)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(plan.Blocks, test.blocks) {
+			t.Errorf("%v: blocks %v, expected %v", test.name, plan.Blocks, test.blocks)
+		}
+	}
+}
+
+func TestPlannerPlanInvalid(t *testing.T) {
+	for _, p := range []Point{{TableHoldingRegisters, 0, 0}, {TableHoldingRegisters, 65535, 2}, {Table(9), 0, 1}} {
+		if _, err := NewPlanner(0).Plan([]Point{p}); err == nil {
+			t.Errorf("%v: error expected", p)
+		}
+	}
+}
+
+func TestReadPlanMap(t *testing.T) {
+	points := []Point{
+		{TableHoldingRegisters, 0, 130}, // split over two blocks
+		{TableHoldingRegisters, 126, 2},
+		{TableCoils, 2, 3},
+		{TableInputRegisters, 0, 1}, // block failed
+	}
+	plan, err := NewPlanner(10).Plan(points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([][]byte, len(plan.Blocks))
+	for n, b := range plan.Blocks {
+		switch b.Table {
+		case TableHoldingRegisters:
+			data[n] = make([]byte, 2*b.Quantity)
+			for i := range data[n] {
+				data[n][i] = byte(int(b.Address)*2 + i)
+			}
+		case TableCoils:
+			// coils 2 and 4 of 2..4 set
+			data[n] = []byte{0x05}
+		}
+	}
+	if len(plan.Blocks) != 4 {
+		t.Fatalf("blocks %v", plan.Blocks)
+	}
+	values := plan.Map(data)
+	long := make([]byte, 260)
+	for i := range long {
+		long[i] = byte(i)
+	}
+	expected := [][]byte{long, {252, 253, 254, 255}, {0x05}, nil}
+	for n := range expected {
+		if !bytes.Equal(values[n], expected[n]) || (expected[n] == nil) != (values[n] == nil) {
+			t.Errorf("point %v: % x, expected % x", points[n], values[n], expected[n])
+		}
+	}
+}
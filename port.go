@@ -0,0 +1,150 @@
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+// PortOpener opens the link of a serial line transporter, e.g. a pty, a net.Conn or an in-memory pipe,
+// instead of the serial device. config has the line settings and the read timeout.
+// Ports not returning serial.ErrTimeout on their own are adapted, by read deadlines if supported.
+type PortOpener func(config *serial.Config) (io.ReadWriteCloser, error)
+
+// FixedPort returns the opener of the open port, it can not be opened again once closed,
+// so the idle timeout of its transporter should be zero.
+func FixedPort(rwc io.ReadWriteCloser) PortOpener {
+	var mu sync.Mutex
+	used := false
+	return func(*serial.Config) (io.ReadWriteCloser, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if used {
+			return nil, fmt.Errorf("modbus: fixed port is already opened")
+		}
+		used = true
+		return rwc, nil
+	}
+}
+
+// openPort opens the port by the opener and adapts it to serial.ErrTimeout.
+func openPort(open PortOpener, config *serial.Config) (io.ReadWriteCloser, error) {
+	rwc, err := open(config)
+	if err != nil {
+		return nil, err
+	}
+	switch rwc.(type) {
+	case serial.Port, *RFC2217Port:
+		return rwc, nil
+	}
+	return newTimeoutPort(rwc, config.Timeout), nil
+}
+
+// timeoutPort returns serial.ErrTimeout from Read if nothing is received within the timeout,
+// as serial ports do. Read deadlines are used if the port supports them, otherwise the port
+// is read by a goroutine.
+type timeoutPort struct {
+	io.ReadWriteCloser
+	timeout  time.Duration
+	deadline interface{ SetReadDeadline(time.Time) error }
+
+	// reader goroutine
+	chunks  chan portChunk
+	pending []byte
+	err     error
+	done    chan struct{}
+	once    sync.Once
+}
+
+type portChunk struct {
+	data []byte
+	err  error
+}
+
+func newTimeoutPort(rwc io.ReadWriteCloser, timeout time.Duration) *timeoutPort {
+	p := &timeoutPort{ReadWriteCloser: rwc, timeout: timeout, done: make(chan struct{})}
+	if d, ok := rwc.(interface{ SetReadDeadline(time.Time) error }); ok && d.SetReadDeadline(time.Time{}) == nil {
+		p.deadline = d
+		return p
+	}
+	p.chunks = make(chan portChunk)
+	go p.read()
+	return p
+}
+
+// read passes the data of the port to Read until an error or Close.
+func (p *timeoutPort) read() {
+	for {
+		buf := make([]byte, rtuMaxSize)
+		n, err := p.ReadWriteCloser.Read(buf)
+		if n > 0 || err != nil {
+			select {
+			case p.chunks <- portChunk{data: buf[:n], err: err}:
+			case <-p.done:
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (p *timeoutPort) Read(b []byte) (n int, err error) {
+	if p.deadline != nil {
+		var deadline time.Time
+		if p.timeout > 0 {
+			deadline = time.Now().Add(p.timeout)
+		}
+		if err = p.deadline.SetReadDeadline(deadline); err != nil {
+			return
+		}
+		n, err = p.ReadWriteCloser.Read(b)
+		var ne net.Error
+		if errors.Is(err, os.ErrDeadlineExceeded) || errors.As(err, &ne) && ne.Timeout() {
+			err = serial.ErrTimeout
+		}
+		return
+	}
+	if len(p.pending) == 0 {
+		if p.err != nil {
+			return 0, p.err
+		}
+		var timeout <-chan time.Time
+		if p.timeout > 0 {
+			timer := time.NewTimer(p.timeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case chunk := <-p.chunks:
+			p.pending, p.err = chunk.data, chunk.err
+		case <-timeout:
+			return 0, serial.ErrTimeout
+		case <-p.done:
+			return 0, os.ErrClosed
+		}
+	}
+	n = copy(b, p.pending)
+	p.pending = p.pending[n:]
+	if n == 0 {
+		return 0, p.err
+	}
+	return n, nil
+}
+
+// SetReadTimeout changes the timeout of the next reads.
+func (p *timeoutPort) SetReadTimeout(timeout time.Duration) {
+	p.timeout = timeout
+}
+
+func (p *timeoutPort) Close() error {
+	p.once.Do(func() { close(p.done) })
+	return p.ReadWriteCloser.Close()
+}
@@ -0,0 +1,153 @@
+package modbus
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+// pipeDevice answers read holding registers requests of unit 1 on the port. reply returns the registers
+// of the nth request and the delay of the response, nil registers leave the request unanswered.
+func pipeDevice(mode string, port net.Conn, reply func(n int) (registers []byte, delay time.Duration)) {
+	mbc := NewSClient(1, mode)
+	buf := make([]byte, asciiMaxSize)
+	for n := 0; ; n++ {
+		length, err := port.Read(buf)
+		if err != nil {
+			return
+		}
+		if _, err = mbc.Decode(buf[:length]); err != nil {
+			continue
+		}
+		registers, delay := reply(n)
+		if registers == nil {
+			continue
+		}
+		adu, _ := mbc.Encode(&ProtocolDataUnit{
+			FunctionCode: FuncCodeReadHoldingRegisters,
+			Data:         append([]byte{byte(len(registers))}, registers...),
+		})
+		if delay > 0 {
+			// the pipe is synchronous, the next request is read while the late response waits for a reader
+			go func() {
+				time.Sleep(delay)
+				port.Write(adu)
+			}()
+			continue
+		}
+		if _, err = port.Write(adu); err != nil {
+			return
+		}
+	}
+}
+
+// portKinds are the ports of the two timeoutPort paths, net.Pipe supports read deadlines,
+// the wrapped pipe is read by a goroutine.
+var portKinds = map[string]func(net.Conn) io.ReadWriteCloser{
+	"deadline":  func(c net.Conn) io.ReadWriteCloser { return c },
+	"goroutine": func(c net.Conn) io.ReadWriteCloser { return struct{ io.ReadWriteCloser }{c} },
+}
+
+// connectPipe connects a transporter to the device over a pipe.
+func connectPipe(t *testing.T, mode, kind string, timeout int64, reply func(n int) ([]byte, time.Duration)) *MBTransporter {
+	t.Helper()
+	client, device := net.Pipe()
+	go pipeDevice(mode, device, reply)
+	mbt := NewTransporter()
+	if err := mbt.ConnectPort(mode, FixedPort(portKinds[kind](client)), 19200, 8, "E", 1, timeout, 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		mbt.Close()
+		device.Close()
+	})
+	return mbt
+}
+
+func TestTimeoutPort(t *testing.T) {
+	for kind, wrap := range portKinds {
+		client, device := net.Pipe()
+		port := newTimeoutPort(wrap(client), 50*time.Millisecond)
+		if (port.deadline != nil) != (kind == "deadline") {
+			t.Errorf("%v: deadline path %v", kind, port.deadline != nil)
+		}
+		start := time.Now()
+		buf := make([]byte, 8)
+		if _, err := port.Read(buf); err != serial.ErrTimeout {
+			t.Errorf("%v: read %v, expected %v", kind, err, serial.ErrTimeout)
+		}
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+			t.Errorf("%v: timed out after %v", kind, elapsed)
+		}
+		go device.Write([]byte{1, 2, 3})
+		if n, err := port.Read(buf); err != nil || !bytes.Equal(buf[:n], []byte{1, 2, 3}) {
+			t.Errorf("%v: read % x %v", kind, buf[:n], err)
+		}
+		port.Close()
+		if _, err := port.Read(buf); err == nil || err == serial.ErrTimeout {
+			t.Errorf("%v: read of closed port %v", kind, err)
+		}
+		device.Close()
+	}
+}
+
+func TestConnectPortRead(t *testing.T) {
+	for _, mode := range []string{"rtu", "ascii"} {
+		for kind := range portKinds {
+			mbt := connectPipe(t, mode, kind, 500, func(int) ([]byte, time.Duration) {
+				return []byte{0, 1, 0xFF, 0xFE}, 0
+			})
+			values, warn, err := NewSClient(1, mode).ReadValues(mbt, TableHoldingRegisters, 0, 2)
+			if warn != nil || err != nil || !bytes.Equal(values, []byte{0, 1, 0xFF, 0xFE}) {
+				t.Errorf("%v %v: values % x, %v %v", mode, kind, values, warn, err)
+			}
+		}
+	}
+}
+
+func TestConnectPortTimeout(t *testing.T) {
+	for _, mode := range []string{"rtu", "ascii"} {
+		for kind := range portKinds {
+			mbt := connectPipe(t, mode, kind, 100, func(int) ([]byte, time.Duration) {
+				return nil, 0
+			})
+			start := time.Now()
+			_, warn, err := NewSClient(1, mode).ReadValues(mbt, TableHoldingRegisters, 0, 2)
+			// RTU reports response failures as warnings
+			if err == nil && warn == nil {
+				t.Errorf("%v %v: no timeout", mode, kind)
+			}
+			if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > time.Second {
+				t.Errorf("%v %v: timed out after %v", mode, kind, elapsed)
+			}
+		}
+	}
+}
+
+func TestConnectPortLateResponse(t *testing.T) {
+	for kind := range portKinds {
+		// the first response arrives after the timeout, it would be taken as the response of the second request
+		mbt := connectPipe(t, "rtu", kind, 100, func(n int) ([]byte, time.Duration) {
+			if n == 0 {
+				return []byte{0xDE, 0xAD}, 150 * time.Millisecond
+			}
+			return []byte{0, 2}, 0
+		})
+		mbc := NewSClient(1, "rtu")
+		if _, warn, _ := mbc.ReadValues(mbt, TableHoldingRegisters, 0, 1); warn == nil {
+			t.Fatalf("%v: late response received in time", kind)
+		}
+		time.Sleep(100 * time.Millisecond)
+		values, warn, err := mbc.ReadValues(mbt, TableHoldingRegisters, 0, 1)
+		if warn != nil || err != nil || !bytes.Equal(values, []byte{0, 2}) {
+			t.Errorf("%v: values % x, %v %v", kind, values, warn, err)
+		}
+		if dropped := mbt.DroppedBytes(); dropped != 7 {
+			t.Errorf("%v: '%v' bytes dropped, expected the 7 of the late response", kind, dropped)
+		}
+	}
+}
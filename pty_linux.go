@@ -0,0 +1,93 @@
+//go:build linux
+
+package modbus
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Pty is a pseudo terminal pair for serial slaves without hardware, e.g. a simulator
+// serving RTU on Master while the transporter under test connects to Path:
+//  pty, _ := modbus.OpenPty()
+//  go modbus.NewSerialServer(sim).ServePort("rtu", modbus.FixedPort(pty.Master), 19200, 8, "E", 1)
+//  mbt.Connect("rtu", pty.Path, 19200, 8, "E", 1, 500, 0)
+// Both sides are raw, the line settings of the pair have no effect.
+type Pty struct {
+	Master *os.File
+	// Path of the slave device, e.g. /dev/pts/3
+	Path string
+
+	// slave keeps the pair open while no transporter is connected, reads of the master
+	// fail otherwise
+	slave *os.File
+}
+
+// OpenPty opens a new pseudo terminal pair.
+func OpenPty() (*Pty, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	var n uint32
+	if err = ioctl(master, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); err == nil {
+		var unlock int32
+		err = ioctl(master, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock)))
+	}
+	if err != nil {
+		master.Close()
+		return nil, fmt.Errorf("modbus: pty: %w", err)
+	}
+	pty := &Pty{Master: master, Path: fmt.Sprintf("/dev/pts/%d", n)}
+	if pty.slave, err = os.OpenFile(pty.Path, os.O_RDWR|syscall.O_NOCTTY, 0); err == nil {
+		err = makeRaw(pty.slave)
+	}
+	if err != nil {
+		pty.Close()
+		return nil, fmt.Errorf("modbus: pty: %w", err)
+	}
+	return pty, nil
+}
+
+// Close closes both sides of the pair.
+func (pty *Pty) Close() error {
+	if pty.slave != nil {
+		pty.slave.Close()
+	}
+	return pty.Master.Close()
+}
+
+// makeRaw disables echo, line editing and character translation of the terminal.
+func makeRaw(f *os.File) error {
+	var t syscall.Termios
+	if err := ioctl(f, syscall.TCGETS, uintptr(unsafe.Pointer(&t))); err != nil {
+		return err
+	}
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB
+	t.Cflag |= syscall.CS8
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+	return ioctl(f, syscall.TCSETS, uintptr(unsafe.Pointer(&t)))
+}
+
+// ioctl calls the request on the file, by its raw connection as Fd would set the file blocking
+// and disable read deadlines.
+func ioctl(f *os.File, request, arg uintptr) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	if e := rc.Control(func(fd uintptr) {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, arg); errno != 0 {
+			err = errno
+		}
+	}); e != nil {
+		return e
+	}
+	return err
+}
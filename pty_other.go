@@ -0,0 +1,24 @@
+//go:build !linux
+
+package modbus
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// Pty is a pseudo terminal pair, supported on linux only.
+type Pty struct {
+	Master *os.File
+	Path   string
+}
+
+// OpenPty returns an error, pseudo terminals are supported on linux only.
+func OpenPty() (*Pty, error) {
+	return nil, fmt.Errorf("modbus: pty is not supported on %v", runtime.GOOS)
+}
+
+func (pty *Pty) Close() error {
+	return pty.Master.Close()
+}
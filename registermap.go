@@ -0,0 +1,673 @@
+package modbus
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Access is the read/write access of a tag.
+type Access string
+
+const (
+	AccessRead      Access = "r"
+	AccessWrite     Access = "w"
+	AccessReadWrite Access = "rw"
+)
+
+// ParseAccess converts the access name to Access, empty name is read only.
+func ParseAccess(name string) (Access, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "r", "ro", "read":
+		return AccessRead, nil
+	case "w", "wo", "write":
+		return AccessWrite, nil
+	case "rw", "wr", "readwrite", "read/write":
+		return AccessReadWrite, nil
+	}
+	return "", fmt.Errorf("modbus: unknown access '%v'", name)
+}
+
+func (a *Access) UnmarshalText(text []byte) (err error) {
+	*a, err = ParseAccess(string(text))
+	return
+}
+
+func (a Access) CanRead() bool  { return a != AccessWrite }
+func (a Access) CanWrite() bool { return a == AccessWrite || a == AccessReadWrite }
+
+func (t Table) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *Table) UnmarshalText(text []byte) (err error) {
+	*t, err = ParseTable(string(text))
+	return
+}
+
+// Tag is a named value of the device.
+type Tag struct {
+	Name    string   `json:"name" yaml:"name"`
+	Table   Table    `json:"table" yaml:"table"`
+	Address uint16   `json:"address" yaml:"address"`
+	Type    DataType `json:"type" yaml:"type"`
+	// Count of registers, required for strings only
+	Length      uint16    `json:"length,omitempty" yaml:"length,omitempty"`
+	WordOrder   WordOrder `json:"word_order,omitempty" yaml:"word_order,omitempty"`
+	Scale       float64   `json:"scale,omitempty" yaml:"scale,omitempty"`
+	Unit        string    `json:"unit,omitempty" yaml:"unit,omitempty"`
+	Access      Access    `json:"access,omitempty" yaml:"access,omitempty"`
+	Description string    `json:"description,omitempty" yaml:"description,omitempty"`
+	// Allowed range of the scaled value, checked on write
+	Min *float64 `json:"min,omitempty" yaml:"min,omitempty"`
+	Max *float64 `json:"max,omitempty" yaml:"max,omitempty"`
+	// Names of coded values, accepted instead of the codes on write. Reads return the codes,
+	// the enum transform decodes them to names.
+	Enum map[string]int64 `json:"enum,omitempty" yaml:"enum,omitempty"`
+	// Transforms applied to the decoded value before Scale on read, reversed on write
+	Transforms Pipeline `json:"transform,omitempty" yaml:"transform,omitempty"`
+	// Flags and fields of a packed register, the value is the map of field values
+	Fields BitFields `json:"fields,omitempty" yaml:"fields,omitempty"`
+}
+
+// TagValue is the value of the tag with its engineering unit.
+type TagValue struct {
+	Value interface{}
+	Unit  string
+}
+
+func (v TagValue) String() string {
+	if v.Unit == "" {
+		return fmt.Sprint(v.Value)
+	}
+	return fmt.Sprintf("%v %v", v.Value, v.Unit)
+}
+
+// Quantity returns count of registers or bits of the tag.
+func (t *Tag) Quantity() uint16 {
+	if t.Table.IsBit() {
+		return 1
+	}
+	if n := t.Type.Registers(); n > 0 {
+		return n
+	}
+	return t.Length
+}
+
+// Point returns the range of the tag.
+func (t *Tag) Point() Point {
+	return Point{Table: t.Table, Address: t.Address, Length: t.Quantity()}
+}
+
+// Validate checks table, type and quantity of the tag.
+func (t *Tag) Validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("modbus: tag at %v:%v has no name", t.Table, t.Address)
+	}
+	if t.Table < TableCoils || t.Table > TableInputRegisters {
+		return fmt.Errorf("modbus: tag '%v' has unknown table '%v'", t.Name, t.Table)
+	}
+	if _, err := ParseDataType(string(t.Type)); err != nil {
+		return fmt.Errorf("modbus: tag '%v': %w", t.Name, err)
+	}
+	if _, err := ParseWordOrder(string(t.WordOrder)); err != nil {
+		return fmt.Errorf("modbus: tag '%v': %w", t.Name, err)
+	}
+	if t.Table.IsBit() && t.Type != TypeBool {
+		return fmt.Errorf("modbus: tag '%v' of %v table must be bool, not '%v'", t.Name, t.Table, t.Type)
+	}
+	quantity := t.Quantity()
+	if quantity < 1 || quantity > t.Table.MaxRead() {
+		return fmt.Errorf("modbus: tag '%v' quantity '%v' must be between '%v' and '%v'", t.Name, quantity, 1, t.Table.MaxRead())
+	}
+	if int(t.Address)+int(quantity) > 0x10000 {
+		return fmt.Errorf("modbus: tag '%v' exceeds address space", t.Name)
+	}
+	if t.Access.CanWrite() && !t.Table.IsWritable() {
+		return fmt.Errorf("modbus: tag '%v' of %v table can not be written", t.Name, t.Table)
+	}
+	if t.Min != nil && t.Max != nil && *t.Min > *t.Max {
+		return fmt.Errorf("modbus: tag '%v' min '%v' is greater than max '%v'", t.Name, *t.Min, *t.Max)
+	}
+	if len(t.Fields) > 0 {
+		if t.Table.IsBit() || t.Quantity() != 1 || t.Type == TypeBool || len(t.Transforms) > 0 || (t.Scale != 0 && t.Scale != 1) || len(t.Enum) > 0 {
+			return fmt.Errorf("modbus: tag '%v' with bit fields must be a 16-bit register without scale, transforms and coded values", t.Name)
+		}
+		if err := t.Fields.Validate(); err != nil {
+			return fmt.Errorf("modbus: tag '%v': %w", t.Name, err)
+		}
+	}
+	if len(t.Enum) > 0 && (t.Type == TypeString || t.Type == TypeFloat32 || t.Type == TypeFloat64 || (t.Scale != 0 && t.Scale != 1)) {
+		return fmt.Errorf("modbus: tag '%v' of type '%v' can not have coded values", t.Name, t.Type)
+	}
+	return nil
+}
+
+// CheckRange checks the scaled value against Min and Max of the tag.
+func (t *Tag) CheckRange(value interface{}) error {
+	if t.Min == nil && t.Max == nil {
+		return nil
+	}
+	v, err := toFloat64(value)
+	if err != nil {
+		return err
+	}
+	if (t.Min != nil && v < *t.Min) || (t.Max != nil && v > *t.Max) {
+		return fmt.Errorf("modbus: tag '%v' value '%v' is out of range", t.Name, value)
+	}
+	return nil
+}
+
+// Decode converts raw registers or bits of the tag to the scaled value.
+func (t *Tag) Decode(raw []byte) (interface{}, error) {
+	return t.DecodeWith(raw, nil)
+}
+
+// DecodeWith converts raw registers or bits of the tag to the scaled value,
+// env holds the values of tags referenced by the transforms.
+func (t *Tag) DecodeWith(raw []byte, env map[string]interface{}) (value interface{}, err error) {
+	if t.Table.IsBit() {
+		if len(raw) < 1 {
+			return nil, fmt.Errorf("modbus: tag '%v' value is empty", t.Name)
+		}
+		value = raw[0]&0x01 != 0
+	} else if len(t.Fields) > 0 {
+		if value, err = DecodeValue(TypeUint16, t.WordOrder, raw); err != nil {
+			return
+		}
+		return t.Fields.Decode(uint16(value.(uint64))), nil
+	} else if value, err = DecodeValue(t.Type, t.WordOrder, raw); err != nil {
+		return
+	}
+	if value, err = t.Transforms.Apply(value, env); err != nil {
+		return nil, fmt.Errorf("modbus: tag '%v': %w", t.Name, err)
+	}
+	if t.Scale == 0 || t.Scale == 1 {
+		return value, nil
+	}
+	f, err := toFloat64(value)
+	return f * t.Scale, err
+}
+
+// Encode converts the scaled value of the tag to raw registers or bits.
+// Values of tags with bit fields are maps of field values, fields not given are zero
+// as the whole register is encoded. WriteTag keeps them with read-modify-write instead.
+// Coded values may be given by name.
+func (t *Tag) Encode(value interface{}) ([]byte, error) {
+	return t.EncodeWith(value, nil)
+}
+
+// EncodeWith converts the scaled value of the tag to raw registers or bits,
+// env holds the values of tags referenced by the transforms.
+func (t *Tag) EncodeWith(value interface{}, env map[string]interface{}) ([]byte, error) {
+	if values, ok := value.(map[string]interface{}); ok && len(t.Fields) > 0 {
+		_, word, err := t.Fields.Encode(values)
+		if err != nil {
+			return nil, fmt.Errorf("modbus: tag '%v': %w", t.Name, err)
+		}
+		return EncodeValue(TypeUint16, t.WordOrder, word, 1)
+	}
+	if name, ok := value.(string); ok && len(t.Enum) > 0 {
+		if code, ok := t.Enum[name]; ok {
+			value = code
+		}
+	}
+	if err := t.CheckRange(value); err != nil {
+		return nil, err
+	}
+	if t.Scale != 0 && t.Scale != 1 {
+		f, err := toFloat64(value)
+		if err != nil {
+			return nil, err
+		}
+		value = f / t.Scale
+		if len(t.Transforms) == 0 && t.Type != TypeFloat32 && t.Type != TypeFloat64 {
+			value = math.Round(f / t.Scale)
+		}
+	}
+	value, err := t.Transforms.Reverse(value, env)
+	if err != nil {
+		return nil, fmt.Errorf("modbus: tag '%v': %w", t.Name, err)
+	}
+	if t.Table.IsBit() {
+		v, err := toBool(value)
+		if err != nil || !v {
+			return []byte{0x00}, err
+		}
+		return []byte{0x01}, nil
+	}
+	return EncodeValue(t.Type, t.WordOrder, value, t.Quantity())
+}
+
+// RegisterMap is the list of tags of a device type.
+type RegisterMap struct {
+	Tags []*Tag `json:"tags" yaml:"tags"`
+
+	planner *Planner
+	index   map[string]*Tag
+}
+
+func NewRegisterMap(tags ...*Tag) (*RegisterMap, error) {
+	rm := &RegisterMap{Tags: tags}
+	return rm, rm.Validate()
+}
+
+// LoadRegisterMap reads the register map file, format is chosen by extension (.json, .yaml, .yml, .csv).
+func LoadRegisterMap(path string) (*RegisterMap, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return ParseRegisterMapJSON(file)
+	case ".yaml", ".yml":
+		return ParseRegisterMapYAML(file)
+	case ".csv":
+		return ParseRegisterMapCSV(file)
+	}
+	return nil, fmt.Errorf("modbus: unknown register map format '%v'", filepath.Ext(path))
+}
+
+// ParseRegisterMapJSON reads {"tags": [...]} or a list of tags.
+func ParseRegisterMapJSON(r io.Reader) (*RegisterMap, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	rm := &RegisterMap{}
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		err = json.Unmarshal(data, &rm.Tags)
+	} else {
+		err = json.Unmarshal(data, rm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("modbus: register map: %w", err)
+	}
+	return rm, rm.Validate()
+}
+
+// ParseRegisterMapYAML reads tags: [...] or a list of tags.
+func ParseRegisterMapYAML(r io.Reader) (*RegisterMap, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err = yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("modbus: register map: %w", err)
+	}
+	rm := &RegisterMap{}
+	if len(doc.Content) > 0 {
+		switch content := doc.Content[0]; content.Kind {
+		case yaml.SequenceNode:
+			err = content.Decode(&rm.Tags)
+		case yaml.MappingNode:
+			err = content.Decode(rm)
+		default:
+			err = fmt.Errorf("document is neither a list of tags nor a mapping")
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("modbus: register map: %w", err)
+	}
+	return rm, rm.Validate()
+}
+
+// ParseRegisterMapCSV reads a table with the header row, columns are matched by name:
+//  name, table, address, type, length, word_order, scale, unit, access, description, min, max, enum, transform, fields
+// Coded values are listed as "OFF=0;ON=1", transforms and fields in the text form of Pipeline and BitFields.
+func ParseRegisterMapCSV(r io.Reader) (*RegisterMap, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("modbus: register map: %w", err)
+	}
+	if len(records) < 1 {
+		return nil, fmt.Errorf("modbus: register map has no header")
+	}
+	columns := make(map[string]int)
+	for n, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = n
+	}
+	for _, name := range []string{"name", "table", "address", "type"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("modbus: register map has no column '%v'", name)
+		}
+	}
+	rm := &RegisterMap{}
+	for line, record := range records[1:] {
+		get := func(name string) string {
+			if n, ok := columns[name]; ok && n < len(record) {
+				return strings.TrimSpace(record[n])
+			}
+			return ""
+		}
+		tag, err := parseCSVTag(get)
+		if err != nil {
+			return nil, fmt.Errorf("modbus: register map line %v: %w", line+2, err)
+		}
+		rm.Tags = append(rm.Tags, tag)
+	}
+	return rm, rm.Validate()
+}
+
+func parseCSVTag(get func(string) string) (tag *Tag, err error) {
+	tag = &Tag{Name: get("name"), Unit: get("unit"), Description: get("description")}
+	if tag.Table, err = ParseTable(get("table")); err != nil {
+		return
+	}
+	address, err := strconv.ParseUint(get("address"), 0, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address '%v'", get("address"))
+	}
+	tag.Address = uint16(address)
+	if tag.Type, err = ParseDataType(get("type")); err != nil {
+		return
+	}
+	if s := get("length"); s != "" {
+		length, err := strconv.ParseUint(s, 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid length '%v'", s)
+		}
+		tag.Length = uint16(length)
+	}
+	if tag.WordOrder, err = ParseWordOrder(get("word_order")); err != nil {
+		return
+	}
+	if s := get("scale"); s != "" {
+		if tag.Scale, err = strconv.ParseFloat(s, 64); err != nil {
+			return nil, fmt.Errorf("invalid scale '%v'", s)
+		}
+	}
+	if tag.Access, err = ParseAccess(get("access")); err != nil {
+		return
+	}
+	for _, limit := range []struct {
+		name  string
+		value **float64
+	}{{"min", &tag.Min}, {"max", &tag.Max}} {
+		if s := get(limit.name); s != "" {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %v '%v'", limit.name, s)
+			}
+			*limit.value = &v
+		}
+	}
+	if s := get("enum"); s != "" {
+		tag.Enum = make(map[string]int64)
+		for _, item := range strings.Split(s, ";") {
+			name, code, ok := strings.Cut(item, "=")
+			v, err := strconv.ParseInt(strings.TrimSpace(code), 0, 64)
+			if !ok || err != nil {
+				return nil, fmt.Errorf("invalid coded value '%v'", item)
+			}
+			tag.Enum[strings.TrimSpace(name)] = v
+		}
+	}
+	if tag.Transforms, err = ParsePipeline(get("transform")); err != nil {
+		return
+	}
+	if tag.Fields, err = ParseBitFields(get("fields")); err != nil {
+		return
+	}
+	return
+}
+
+// Validate checks the tags, duplicated names and overlapping addresses, and builds the name index.
+func (rm *RegisterMap) Validate() error {
+	index := make(map[string]*Tag, len(rm.Tags))
+	for _, tag := range rm.Tags {
+		if tag == nil {
+			return fmt.Errorf("modbus: register map has empty tag")
+		}
+		if tag.Access == "" {
+			tag.Access = AccessRead
+		}
+		if tag.WordOrder == "" {
+			tag.WordOrder = OrderABCD
+		}
+		if err := tag.Validate(); err != nil {
+			return err
+		}
+		if _, ok := index[tag.Name]; ok {
+			return fmt.Errorf("modbus: tag '%v' is duplicated", tag.Name)
+		}
+		index[tag.Name] = tag
+	}
+	for _, tag := range rm.Tags {
+		for _, name := range tag.Transforms.References() {
+			ref, ok := index[name]
+			if !ok {
+				return fmt.Errorf("modbus: tag '%v' references unknown tag '%v'", tag.Name, name)
+			}
+			if !ref.Access.CanRead() || len(ref.Transforms.References()) > 0 {
+				return fmt.Errorf("modbus: tag '%v' references tag '%v' which is write only or has references", tag.Name, name)
+			}
+		}
+	}
+	sorted := make([]*Tag, len(rm.Tags))
+	copy(sorted, rm.Tags)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Table != sorted[j].Table {
+			return sorted[i].Table < sorted[j].Table
+		}
+		return sorted[i].Address < sorted[j].Address
+	})
+	for n := 1; n < len(sorted); n++ {
+		prev, tag := sorted[n-1], sorted[n]
+		if prev.Table == tag.Table && int(prev.Address)+int(prev.Quantity()) > int(tag.Address) {
+			return fmt.Errorf("modbus: tag '%v' overlaps '%v' at %v:%v", tag.Name, prev.Name, tag.Table, tag.Address)
+		}
+	}
+	rm.index = index
+	return nil
+}
+
+// SetPlanner sets the planner used to read several tags.
+func (rm *RegisterMap) SetPlanner(planner *Planner) {
+	rm.planner = planner
+}
+
+// Tag returns the tag by name.
+// The index is built by Validate, which the constructors and loaders call once,
+// maps built as literals and not validated are searched in order.
+func (rm *RegisterMap) Tag(name string) (*Tag, bool) {
+	if rm.index == nil {
+		for _, tag := range rm.Tags {
+			if tag != nil && tag.Name == name {
+				return tag, true
+			}
+		}
+		return nil, false
+	}
+	tag, ok := rm.index[name]
+	return tag, ok
+}
+
+// Names returns names of the tags in map order.
+func (rm *RegisterMap) Names() []string {
+	names := make([]string, len(rm.Tags))
+	for n, tag := range rm.Tags {
+		names[n] = tag.Name
+	}
+	return names
+}
+
+func (rm *RegisterMap) tags(names []string) ([]*Tag, error) {
+	tags := make([]*Tag, len(names))
+	for n, name := range names {
+		tag, ok := rm.Tag(name)
+		if !ok {
+			return nil, fmt.Errorf("modbus: unknown tag '%v'", name)
+		}
+		if !tag.Access.CanRead() {
+			return nil, fmt.Errorf("modbus: tag '%v' is write only", name)
+		}
+		tags[n] = tag
+	}
+	return tags, nil
+}
+
+// ReadTag reads one tag from the device of the client.
+func (rm *RegisterMap) ReadTag(mbc *MBClient, mbt ApiSender, name string) (value interface{}, warn, err error) {
+	values, warn, err := rm.Read(mbc, mbt, name)
+	if err == nil && warn == nil {
+		value = values[name]
+	}
+	return
+}
+
+// Read reads the tags with the minimal count of requests, all readable tags if no names given.
+// Tags of failed requests are missing in values, the first warning is returned.
+func (rm *RegisterMap) Read(mbc *MBClient, mbt ApiSender, names ...string) (values map[string]interface{}, warn, err error) {
+	if len(names) == 0 {
+		for _, tag := range rm.Tags {
+			if tag.Access.CanRead() {
+				names = append(names, tag.Name)
+			}
+		}
+	}
+	tags, err := rm.tags(names)
+	if err != nil {
+		return
+	}
+	tags = withReferences(rm, tags)
+	points := make([]Point, len(tags))
+	for n, tag := range tags {
+		points[n] = tag.Point()
+	}
+	planner := rm.planner
+	if planner == nil {
+		planner = NewPlanner(0)
+	}
+	plan, err := planner.Plan(points)
+	if err != nil {
+		return
+	}
+	raw, warn, err := plan.Execute(mbc, mbt)
+	if err != nil {
+		return
+	}
+	values = make(map[string]interface{}, len(tags))
+	// referenced tags have no references and are decoded first
+	for _, refs := range []bool{false, true} {
+		for n, tag := range tags {
+			if raw[n] == nil || (len(tag.Transforms.References()) > 0) != refs {
+				continue
+			}
+			value, w := tag.DecodeWith(raw[n], values)
+			if w != nil {
+				if warn == nil {
+					warn = w
+				}
+				continue
+			}
+			values[tag.Name] = value
+		}
+	}
+	return
+}
+
+// ReadUnits reads the tags as Read and attaches the units of the tags to the values.
+func (rm *RegisterMap) ReadUnits(mbc *MBClient, mbt ApiSender, names ...string) (values map[string]TagValue, warn, err error) {
+	raw, warn, err := rm.Read(mbc, mbt, names...)
+	if err != nil {
+		return
+	}
+	values = make(map[string]TagValue, len(raw))
+	for name, value := range raw {
+		tag, _ := rm.Tag(name)
+		values[name] = TagValue{Value: value, Unit: tag.Unit}
+	}
+	return
+}
+
+// withReferences appends the tags referenced by transforms of the tags which are missing.
+func withReferences(rm *RegisterMap, tags []*Tag) []*Tag {
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		seen[tag.Name] = true
+	}
+	for _, tag := range tags {
+		for _, name := range tag.Transforms.References() {
+			if ref, ok := rm.Tag(name); ok && !seen[name] {
+				seen[name] = true
+				tags = append(tags, ref)
+			}
+		}
+	}
+	return tags
+}
+
+// env reads the values of tags referenced by transforms of the tags.
+func (rm *RegisterMap) env(mbc *MBClient, mbt ApiSender, tags ...*Tag) (env map[string]interface{}, warn, err error) {
+	var names []string
+	for _, tag := range tags {
+		names = append(names, tag.Transforms.References()...)
+	}
+	if len(names) == 0 {
+		return
+	}
+	env, warn, err = rm.Read(mbc, mbt, names...)
+	if err == nil && warn == nil {
+		for _, name := range names {
+			if _, ok := env[name]; !ok {
+				warn = fmt.Errorf("modbus: value of tag '%v' is missing", name)
+				return
+			}
+		}
+	}
+	return
+}
+
+// WriteTag writes the value of the tag to the device of the client.
+// Values of tags with bit fields may be maps of field values, only the given fields are changed
+// with read-modify-write of MBClient.ModifyRegister, unlike Tag.Encode which zeroes the others.
+func (rm *RegisterMap) WriteTag(mbc *MBClient, mbt ApiSender, name string, value interface{}) (warn, err error) {
+	tag, ok := rm.Tag(name)
+	if !ok {
+		return nil, fmt.Errorf("modbus: unknown tag '%v'", name)
+	}
+	if !tag.Access.CanWrite() {
+		return nil, fmt.Errorf("modbus: tag '%v' is read only", name)
+	}
+	if values, ok := value.(map[string]interface{}); ok && len(tag.Fields) > 0 {
+		andMask, orMask, err := tag.Fields.Encode(values)
+		if err != nil {
+			return nil, fmt.Errorf("modbus: tag '%v': %w", name, err)
+		}
+		if tag.WordOrder == OrderBADC || tag.WordOrder == OrderDCBA {
+			andMask, orMask = andMask>>8|andMask<<8, orMask>>8|orMask<<8
+		}
+		return mbc.ModifyRegister(mbt, tag.Address, andMask, orMask)
+	}
+	env, warn, err := rm.env(mbc, mbt, tag)
+	if err != nil || warn != nil {
+		return
+	}
+	raw, err := tag.EncodeWith(value, env)
+	if err != nil {
+		return
+	}
+	return mbc.WriteValues(mbt, tag.Table, tag.Address, tag.Quantity(), raw)
+}
+
+// WriteField changes one flag or field of the packed register of the tag.
+func (rm *RegisterMap) WriteField(mbc *MBClient, mbt ApiSender, name, field string, value interface{}) (warn, err error) {
+	return rm.WriteTag(mbc, mbt, name, map[string]interface{}{field: value})
+}
@@ -0,0 +1,78 @@
+package modbus
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRegisterMapYAML(t *testing.T) {
+	docs := map[string]string{
+		"mapping":           "tags:\n  - {name: a, table: holding, address: 1, type: uint16}\n",
+		"mapping with ---":  "---\ntags:\n  - {name: a, table: holding, address: 1, type: uint16}\n",
+		"list":              "- {name: a, table: holding, address: 1, type: uint16}\n",
+		"list with ---":     "---\n- {name: a, table: holding, address: 1, type: uint16}\n",
+		"list with comment": "# meter\n- {name: a, table: holding, address: 1, type: uint16}\n",
+	}
+	for name, doc := range docs {
+		rm, err := ParseRegisterMapYAML(strings.NewReader(doc))
+		if err != nil {
+			t.Errorf("%v: %v", name, err)
+			continue
+		}
+		if tag, ok := rm.Tag("a"); !ok || tag.Table != TableHoldingRegisters || tag.Address != 1 {
+			t.Errorf("%v: tags %+v", name, rm.Tags)
+		}
+	}
+	if _, err := ParseRegisterMapYAML(strings.NewReader("5\n")); err == nil {
+		t.Errorf("scalar document: error expected")
+	}
+}
+
+func TestTagEncodeNamedTypes(t *testing.T) {
+	type mode uint16
+	type level float32
+	tag := &Tag{Name: "mode", Table: TableHoldingRegisters, Type: TypeUint16, Enum: map[string]int64{"off": 0, "on": 1}}
+	for _, value := range []interface{}{mode(1), "on", 1} {
+		raw, err := tag.Encode(value)
+		if err != nil || len(raw) != 2 || raw[1] != 1 {
+			t.Errorf("%v (%T): % x %v", value, value, raw, err)
+		}
+	}
+	tag = &Tag{Name: "level", Table: TableHoldingRegisters, Type: TypeFloat32}
+	if _, err := tag.Encode(level(1.5)); err != nil {
+		t.Errorf("float: %v", err)
+	}
+}
+
+func TestRegisterMapTag(t *testing.T) {
+	tags := []*Tag{
+		{Name: "a", Table: TableHoldingRegisters, Address: 1, Type: TypeUint16},
+		{Name: "b", Table: TableHoldingRegisters, Address: 2, Type: TypeUint16},
+	}
+	literal := &RegisterMap{Tags: tags}
+	built, err := NewRegisterMap(tags...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, rm := range map[string]*RegisterMap{"literal": literal, "built": built} {
+		done := make(chan struct{})
+		// lookups of several goroutines do not modify the map
+		for n := 0; n < 4; n++ {
+			go func() {
+				defer func() { done <- struct{}{} }()
+				if tag, ok := rm.Tag("b"); !ok || tag.Address != 2 {
+					t.Errorf("%v: tag b %+v", name, tag)
+				}
+				if _, ok := rm.Tag("c"); ok {
+					t.Errorf("%v: unknown tag found", name)
+				}
+			}()
+		}
+		for n := 0; n < 4; n++ {
+			<-done
+		}
+	}
+	if literal.index != nil {
+		t.Errorf("lookup validated the map")
+	}
+}
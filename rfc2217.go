@@ -0,0 +1,335 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+// Telnet commands and options of RFC 854, 856, 858 and 2217.
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	telnetBinary  = 0
+	telnetSGA     = 3
+	telnetComPort = 44
+
+	comPortSetBaudRate = 1
+	comPortSetDataSize = 2
+	comPortSetParity   = 3
+	comPortSetStopSize = 4
+	comPortSetControl  = 5
+	// Responses of the server are the commands plus 100
+	comPortServerOffset = 100
+)
+
+// rfc2217Scheme prefixes the address of serial ports on RFC 2217 terminal servers,
+// e.g. "rfc2217://10.0.0.5:4001".
+const rfc2217Scheme = "rfc2217://"
+
+// rfc2217NegotiationTimeout is the maximum wait for the connection and the port settings.
+const rfc2217NegotiationTimeout = 5 * time.Second
+
+// telnet parser states
+const (
+	telnetStateData = iota
+	telnetStateIAC
+	telnetStateOption
+	telnetStateSB
+	telnetStateSBIAC
+)
+
+// RFC2217Port is a serial port of a terminal server speaking Telnet COM Port Control (RFC 2217).
+// Read returns serial.ErrTimeout if no data arrives within the timeout, as serial ports do,
+// so it is used by the RTU and ASCII transporters for addresses "rfc2217://host:port":
+//  mbt.Connect("rtu", "rfc2217://10.0.0.5:4001", 19200, 8, "E", 1, 500, 0)
+type RFC2217Port struct {
+	conn    net.Conn
+	timeout time.Duration
+
+	wmu sync.Mutex // writes of data and of negotiation replies
+	// parser
+	state   int
+	command byte
+	sb      []byte
+	data    []byte
+	// negotiation
+	comPort bool
+	refused bool
+	acks    map[byte]bool
+}
+
+// DialRFC2217 connects to the terminal server at config.Address, with or without scheme,
+// and sets baud rate, data bits, parity and stop bits of the remote port.
+// config.Timeout is the read timeout.
+func DialRFC2217(config *serial.Config) (*RFC2217Port, error) {
+	settings, err := comPortSettings(config)
+	if err != nil {
+		return nil, err
+	}
+	address := strings.TrimPrefix(config.Address, rfc2217Scheme)
+	conn, err := net.DialTimeout("tcp", address, rfc2217NegotiationTimeout)
+	if err != nil {
+		return nil, err
+	}
+	p := &RFC2217Port{conn: conn, timeout: config.Timeout, acks: make(map[byte]bool)}
+	if err = p.negotiate(settings); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// comPortSettings encodes the subnegotiations of the port settings, defaults are those of serial.Open.
+func comPortSettings(config *serial.Config) (settings [][]byte, err error) {
+	baud := config.BaudRate
+	if baud == 0 {
+		baud = 19200
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(baud))
+	dataBits := config.DataBits
+	if dataBits == 0 {
+		dataBits = 8
+	}
+	if dataBits < 5 || dataBits > 8 {
+		return nil, fmt.Errorf("modbus: unsupported character size %v", config.DataBits)
+	}
+	var parity byte
+	switch config.Parity {
+	case "N":
+		parity = 1
+	case "O":
+		parity = 2
+	case "", "E":
+		parity = 3
+	default:
+		return nil, fmt.Errorf("modbus: unsupported parity %v", config.Parity)
+	}
+	var stop byte
+	switch config.StopBits {
+	case 0, 1:
+		stop = 1
+	case 2:
+		stop = 2
+	default:
+		return nil, fmt.Errorf("modbus: unsupported stop bits %v", config.StopBits)
+	}
+	return [][]byte{
+		append([]byte{comPortSetBaudRate}, b[:]...),
+		{comPortSetDataSize, byte(dataBits)},
+		{comPortSetParity, parity},
+		{comPortSetStopSize, stop},
+		// no flow control
+		{comPortSetControl, 1},
+	}, nil
+}
+
+// negotiate enables the options and waits until the server confirmed the settings.
+func (p *RFC2217Port) negotiate(settings [][]byte) (err error) {
+	deadline := time.Now().Add(rfc2217NegotiationTimeout)
+	if err = p.write([]byte{
+		telnetIAC, telnetWILL, telnetComPort,
+		telnetIAC, telnetWILL, telnetBinary, telnetIAC, telnetDO, telnetBinary,
+		telnetIAC, telnetWILL, telnetSGA, telnetIAC, telnetDO, telnetSGA,
+	}); err != nil {
+		return
+	}
+	if err = p.await(deadline, func() bool { return p.comPort || p.refused }); err != nil {
+		return fmt.Errorf("modbus: rfc2217 server '%v' did not accept com port option: %w", p.conn.RemoteAddr(), err)
+	}
+	if p.refused {
+		return fmt.Errorf("modbus: rfc2217 server '%v' refused com port option", p.conn.RemoteAddr())
+	}
+	for _, setting := range settings {
+		frame := []byte{telnetIAC, telnetSB, telnetComPort}
+		frame = append(frame, escapeIAC(setting)...)
+		if err = p.write(append(frame, telnetIAC, telnetSE)); err != nil {
+			return
+		}
+	}
+	confirmed := func() bool {
+		for _, setting := range settings[:4] {
+			if !p.acks[setting[0]] {
+				return false
+			}
+		}
+		return true
+	}
+	if err = p.await(deadline, confirmed); err != nil {
+		return fmt.Errorf("modbus: rfc2217 server '%v' did not confirm port settings: %w", p.conn.RemoteAddr(), err)
+	}
+	return nil
+}
+
+// await reads until done or the deadline, data received meanwhile is kept.
+func (p *RFC2217Port) await(deadline time.Time, done func() bool) error {
+	var buf [256]byte
+	for !done() {
+		if err := p.conn.SetReadDeadline(deadline); err != nil {
+			return err
+		}
+		n, err := p.conn.Read(buf[:])
+		p.parse(buf[:n])
+		if err != nil && !done() {
+			return err
+		}
+	}
+	return nil
+}
+
+// parse decodes the telnet stream to data, answering option requests.
+func (p *RFC2217Port) parse(b []byte) {
+	for _, c := range b {
+		switch p.state {
+		case telnetStateData:
+			if c == telnetIAC {
+				p.state = telnetStateIAC
+			} else {
+				p.data = append(p.data, c)
+			}
+		case telnetStateIAC:
+			switch c {
+			case telnetIAC:
+				p.data = append(p.data, c)
+				p.state = telnetStateData
+			case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+				p.command = c
+				p.state = telnetStateOption
+			case telnetSB:
+				p.sb = p.sb[:0]
+				p.state = telnetStateSB
+			default:
+				// NOP, GA and other commands without option
+				p.state = telnetStateData
+			}
+		case telnetStateOption:
+			p.option(p.command, c)
+			p.state = telnetStateData
+		case telnetStateSB:
+			if c == telnetIAC {
+				p.state = telnetStateSBIAC
+			} else {
+				p.sb = append(p.sb, c)
+			}
+		case telnetStateSBIAC:
+			switch c {
+			case telnetIAC:
+				p.sb = append(p.sb, c)
+				p.state = telnetStateSB
+			case telnetSE:
+				p.subnegotiation(p.sb)
+				p.state = telnetStateData
+			default:
+				p.state = telnetStateData
+			}
+		}
+	}
+}
+
+// option handles an option request, the supported options are requested by negotiate
+// and need no reply.
+func (p *RFC2217Port) option(command, option byte) {
+	supported := option == telnetBinary || option == telnetSGA || option == telnetComPort
+	switch command {
+	case telnetDO:
+		if option == telnetComPort {
+			p.comPort = true
+		} else if !supported {
+			p.write([]byte{telnetIAC, telnetWONT, option})
+		}
+	case telnetDONT:
+		if option == telnetComPort {
+			p.refused = true
+		}
+	case telnetWILL:
+		if !supported || option == telnetComPort {
+			p.write([]byte{telnetIAC, telnetDONT, option})
+		}
+	}
+}
+
+// subnegotiation records the confirmations of the com port settings,
+// notifications of line and modem state are ignored.
+func (p *RFC2217Port) subnegotiation(sb []byte) {
+	if len(sb) >= 2 && sb[0] == telnetComPort && sb[1] > comPortServerOffset {
+		p.acks[sb[1]-comPortServerOffset] = true
+	}
+}
+
+func (p *RFC2217Port) write(b []byte) (err error) {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	_, err = p.conn.Write(b)
+	return
+}
+
+// Read reads the data of the remote port, serial.ErrTimeout is returned if nothing is received
+// within the timeout.
+func (p *RFC2217Port) Read(b []byte) (n int, err error) {
+	var deadline time.Time
+	if p.timeout > 0 {
+		deadline = time.Now().Add(p.timeout)
+	}
+	var buf [256]byte
+	for len(p.data) == 0 {
+		if err = p.conn.SetReadDeadline(deadline); err != nil {
+			return
+		}
+		n, err = p.conn.Read(buf[:])
+		p.parse(buf[:n])
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				err = serial.ErrTimeout
+			}
+			if len(p.data) == 0 {
+				return 0, err
+			}
+		}
+	}
+	n = copy(b, p.data)
+	p.data = p.data[n:]
+	return n, nil
+}
+
+// Write writes the data to the remote port, IAC bytes are escaped.
+func (p *RFC2217Port) Write(b []byte) (n int, err error) {
+	if err = p.write(escapeIAC(b)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// SetReadTimeout changes the timeout of the next reads.
+func (p *RFC2217Port) SetReadTimeout(timeout time.Duration) {
+	p.timeout = timeout
+}
+
+func (p *RFC2217Port) Close() error {
+	return p.conn.Close()
+}
+
+// escapeIAC doubles the IAC bytes of data.
+func escapeIAC(b []byte) []byte {
+	escaped := make([]byte, 0, len(b))
+	for _, c := range b {
+		if c == telnetIAC {
+			escaped = append(escaped, telnetIAC)
+		}
+		escaped = append(escaped, c)
+	}
+	return escaped
+}
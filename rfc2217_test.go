@@ -0,0 +1,250 @@
+package modbus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+// telnetStandIn is a loopback terminal server answering the COM Port Control option,
+// data received is echoed with the IAC bytes escaped and telnet commands interleaved.
+type telnetStandIn struct {
+	// Refuse answers WILL COM-PORT with DONT
+	Refuse bool
+
+	l        net.Listener
+	mu       sync.Mutex
+	settings map[byte][]byte
+	wire     []byte // data bytes as received, escaped
+	echo     chan []byte
+}
+
+func newTelnetStandIn(t *testing.T, refuse bool) *telnetStandIn {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &telnetStandIn{Refuse: refuse, l: l, settings: make(map[byte][]byte), echo: make(chan []byte, 16)}
+	t.Cleanup(func() { l.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *telnetStandIn) address() string {
+	return rfc2217Scheme + s.l.Addr().String()
+}
+
+func (s *telnetStandIn) serve() {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *telnetStandIn) handle(conn net.Conn) {
+	defer conn.Close()
+	var buf [256]byte
+	var pending, sb []byte
+	for {
+		n, err := conn.Read(buf[:])
+		if err != nil {
+			return
+		}
+		pending = append(pending, buf[:n]...)
+		var data []byte
+		// consume complete commands, an incomplete one is kept for the next read
+	parse:
+		for len(pending) > 0 {
+			c := pending[0]
+			if c != telnetIAC {
+				data = append(data, c)
+				s.record(c)
+				pending = pending[1:]
+				continue
+			}
+			if len(pending) < 2 {
+				break
+			}
+			switch pending[1] {
+			case telnetIAC:
+				data = append(data, telnetIAC)
+				s.record(telnetIAC, telnetIAC)
+				pending = pending[2:]
+			case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+				if len(pending) < 3 {
+					break parse
+				}
+				if pending[1] == telnetWILL && pending[2] == telnetComPort {
+					reply := byte(telnetDO)
+					if s.Refuse {
+						reply = telnetDONT
+					}
+					conn.Write([]byte{telnetIAC, reply, telnetComPort})
+				}
+				pending = pending[3:]
+			case telnetSB:
+				end := bytes.Index(pending, []byte{telnetIAC, telnetSE})
+				if end < 0 {
+					break parse
+				}
+				sb = bytes.ReplaceAll(pending[2:end], []byte{telnetIAC, telnetIAC}, []byte{telnetIAC})
+				pending = pending[end+2:]
+				if len(sb) >= 2 && sb[0] == telnetComPort {
+					s.mu.Lock()
+					s.settings[sb[1]] = append([]byte(nil), sb[2:]...)
+					s.mu.Unlock()
+					ack := append([]byte{telnetComPort, sb[1] + comPortServerOffset}, sb[2:]...)
+					conn.Write(append(append([]byte{telnetIAC, telnetSB}, escapeIAC(ack)...), telnetIAC, telnetSE))
+				}
+			default:
+				pending = pending[2:]
+			}
+		}
+		if len(data) > 0 {
+			// line state notification and NOP around the escaped echo
+			reply := []byte{telnetIAC, telnetSB, telnetComPort, 106, 0x60, telnetIAC, telnetSE, telnetIAC, 241}
+			reply = append(reply, escapeIAC(data)...)
+			conn.Write(append(reply, telnetIAC, 241))
+			s.echo <- data
+		}
+	}
+}
+
+func (s *telnetStandIn) record(b ...byte) {
+	s.mu.Lock()
+	s.wire = append(s.wire, b...)
+	s.mu.Unlock()
+}
+
+// setting returns the value of the setting, waiting a while as settings without ack may be pending.
+func (s *telnetStandIn) setting(command byte) []byte {
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		s.mu.Lock()
+		value, ok := s.settings[command]
+		s.mu.Unlock()
+		if ok || time.Now().After(deadline) {
+			return value
+		}
+	}
+}
+
+func TestRFC2217Negotiation(t *testing.T) {
+	s := newTelnetStandIn(t, false)
+	p, err := DialRFC2217(&serial.Config{Address: s.address(), BaudRate: 9600, DataBits: 7, Parity: "O", StopBits: 2, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if !p.comPort {
+		t.Errorf("com port option not enabled")
+	}
+	if baud := s.setting(comPortSetBaudRate); len(baud) != 4 || binary.BigEndian.Uint32(baud) != 9600 {
+		t.Errorf("baud rate % x", baud)
+	}
+	expected := map[byte]byte{comPortSetDataSize: 7, comPortSetParity: 2, comPortSetStopSize: 2, comPortSetControl: 1}
+	for command, value := range expected {
+		if setting := s.setting(command); len(setting) != 1 || setting[0] != value {
+			t.Errorf("setting '%v' % x, expected '%v'", command, setting, value)
+		}
+		if command != comPortSetControl && !p.acks[command] {
+			t.Errorf("setting '%v' not acknowledged", command)
+		}
+	}
+}
+
+func TestRFC2217Refused(t *testing.T) {
+	s := newTelnetStandIn(t, true)
+	p, err := DialRFC2217(&serial.Config{Address: s.address(), Timeout: time.Second})
+	if err == nil {
+		p.Close()
+		t.Fatalf("connected to a server refusing the com port option")
+	}
+	if !strings.Contains(err.Error(), "refused") {
+		t.Errorf("error %v", err)
+	}
+}
+
+func TestRFC2217Data(t *testing.T) {
+	s := newTelnetStandIn(t, false)
+	p, err := DialRFC2217(&serial.Config{Address: s.address(), Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	frame := []byte{0x01, telnetIAC, 0x03, telnetIAC, telnetIAC, 0x00}
+	if n, err := p.Write(frame); err != nil || n != len(frame) {
+		t.Fatalf("write: %v %v", n, err)
+	}
+	select {
+	case data := <-s.echo:
+		if !bytes.Equal(data, frame) {
+			t.Errorf("server received % x, expected % x", data, frame)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("nothing received by the server")
+	}
+	s.mu.Lock()
+	wire := s.wire
+	s.mu.Unlock()
+	if expected := escapeIAC(frame); !bytes.Equal(wire, expected) {
+		t.Errorf("sent % x, expected % x", wire, expected)
+	}
+
+	var received []byte
+	buf := make([]byte, 4)
+	for len(received) < len(frame) {
+		n, err := p.Read(buf)
+		if err != nil {
+			t.Fatalf("read: %v, received % x", err, received)
+		}
+		received = append(received, buf[:n]...)
+	}
+	if !bytes.Equal(received, frame) {
+		t.Errorf("read % x, expected % x", received, frame)
+	}
+}
+
+func TestRFC2217Parse(t *testing.T) {
+	stream := []byte{0x01, telnetIAC, telnetIAC, telnetIAC, 241, 0x02,
+		telnetIAC, telnetSB, telnetComPort, comPortSetParity + comPortServerOffset, telnetIAC, telnetIAC, telnetIAC, telnetSE,
+		telnetIAC, telnetDO, telnetComPort, 0x03}
+	// every split of the stream in two reads
+	for i := 0; i <= len(stream); i++ {
+		p := &RFC2217Port{acks: make(map[byte]bool)}
+		p.parse(stream[:i])
+		p.parse(stream[i:])
+		if expected := []byte{0x01, telnetIAC, 0x02, 0x03}; !bytes.Equal(p.data, expected) {
+			t.Errorf("split %v: data % x, expected % x", i, p.data, expected)
+		}
+		if !p.acks[comPortSetParity] || !p.comPort {
+			t.Errorf("split %v: acks %v, com port %v", i, p.acks, p.comPort)
+		}
+	}
+}
+
+func TestRFC2217ReadTimeout(t *testing.T) {
+	s := newTelnetStandIn(t, false)
+	p, err := DialRFC2217(&serial.Config{Address: s.address(), Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	start := time.Now()
+	if _, err = p.Read(make([]byte, 8)); err != serial.ErrTimeout {
+		t.Errorf("read: %v, expected %v", err, serial.ErrTimeout)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Errorf("timed out after %v", elapsed)
+	}
+}
@@ -0,0 +1,209 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// RouteRule maps the unit ids First..Last to the backend transporter.
+// Ids are rewritten to Target + (id - First) if Rewrite is set.
+// Mode (rtu, ascii, tcp) frames the requests for the backend and is detected from it if empty.
+type RouteRule struct {
+	First   byte
+	Last    byte
+	Backend ApiSender
+	Mode    string
+	Rewrite bool
+	Target  byte
+}
+
+// target returns the unit id used on the backend.
+func (rule *RouteRule) target(id byte) byte {
+	if !rule.Rewrite {
+		return id
+	}
+	return rule.Target + (id - rule.First)
+}
+
+// Router implements ApiTransporter forwarding each request to the backend of the unit id,
+// so devices on several serial ports and TCP gateways are addressed by id only.
+// Requests are framed in the mode of the router, they are re-framed if the backend uses
+// another mode or the id is rewritten. The first matching rule is used.
+type Router struct {
+	mode   string
+	Logger *log.Logger
+
+	mu    sync.RWMutex
+	rules []*RouteRule
+}
+
+// NewRouter returns the router for requests framed in mode (rtu, ascii, tcp) of the clients.
+func NewRouter(mode string) *Router {
+	mode = strings.ToLower(mode)
+	if mode != "tcp" && mode != "ascii" {
+		mode = "rtu"
+	}
+	return &Router{mode: mode}
+}
+
+// Add appends the rule.
+func (r *Router) Add(rule RouteRule) error {
+	if rule.Backend == nil {
+		return fmt.Errorf("modbus: route of units '%v'-'%v' has no backend", rule.First, rule.Last)
+	}
+	if rule.First > rule.Last {
+		return fmt.Errorf("modbus: route first unit '%v' is greater than last '%v'", rule.First, rule.Last)
+	}
+	if rule.Rewrite && int(rule.Target)+int(rule.Last-rule.First) > 255 {
+		return fmt.Errorf("modbus: route of units '%v'-'%v' rewrites beyond unit 255", rule.First, rule.Last)
+	}
+	if rule.Mode == "" {
+		if rule.Mode = transporterMode(rule.Backend); rule.Mode == "" {
+			return fmt.Errorf("modbus: mode of transporter '%T' is unknown", rule.Backend)
+		}
+	}
+	rule.Mode = strings.ToLower(rule.Mode)
+	r.mu.Lock()
+	r.rules = append(r.rules, &rule)
+	r.mu.Unlock()
+	return nil
+}
+
+// Route returns the rule of the unit id.
+func (r *Router) Route(id byte) (RouteRule, bool) {
+	if rule := r.match(id); rule != nil {
+		return *rule, true
+	}
+	return RouteRule{}, false
+}
+
+func (r *Router) match(id byte) *RouteRule {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, rule := range r.rules {
+		if id >= rule.First && id <= rule.Last {
+			return rule
+		}
+	}
+	return nil
+}
+
+// backends returns the distinct backends of the rules.
+func (r *Router) backends() (backends []ApiSender) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	seen := make(map[ApiSender]bool)
+	for _, rule := range r.rules {
+		if !seen[rule.Backend] {
+			seen[rule.Backend] = true
+			backends = append(backends, rule.Backend)
+		}
+	}
+	return
+}
+
+// Connect connects the backends which can be connected without arguments.
+func (r *Router) Connect() error {
+	for _, backend := range r.backends() {
+		if c, ok := backend.(interface{ Connect() error }); ok {
+			if err := c.Connect(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close closes the backends, the first error is returned.
+func (r *Router) Close() (err error) {
+	for _, backend := range r.backends() {
+		if c, ok := backend.(interface{ Close() error }); ok {
+			if e := c.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	return
+}
+
+func (r *Router) GetAddress() string {
+	return "router"
+}
+
+func (r *Router) SetLogger(logger *log.Logger) {
+	r.Logger = logger
+}
+
+func (r *Router) logf(format string, v ...interface{}) {
+	if r.Logger != nil {
+		r.Logger.Printf(format, v...)
+	}
+}
+
+// Spec returns the unit id and function code of the frame in the mode of the router.
+func (r *Router) Spec(aduReqRes []byte) (byte, byte) {
+	return frameSpec(r.mode, aduReqRes)
+}
+
+// Send forwards the request to the backend of the unit id.
+func (r *Router) Send(aduRequest []byte) (aduResponse []byte, warn, err error) {
+	id, _ := r.Spec(aduRequest)
+	rule := r.match(id)
+	if rule == nil {
+		err = fmt.Errorf("modbus: no route for unit id '%v'", id)
+		return
+	}
+	if rule.Mode == r.mode && !rule.Rewrite {
+		return rule.Backend.Send(aduRequest)
+	}
+	src := NewSClient(id, r.mode)
+	pdu, err := src.Decode(aduRequest)
+	if err != nil {
+		return
+	}
+	dst := NewSClient(rule.target(id), rule.Mode)
+	request, err := dst.Encode(pdu)
+	if err != nil {
+		return
+	}
+	r.logf("modbus: routing unit '%v' as '%v' % x\n", id, dst.GetID(), request)
+	response, warn, err := rule.Backend.Send(request)
+	if err != nil || warn != nil || response == nil {
+		return
+	}
+	if warn = dst.Verify(request, response); warn != nil {
+		return
+	}
+	if pdu, warn = dst.Decode(response); warn != nil {
+		return
+	}
+	if r.mode == "tcp" {
+		aduResponse = tcpFrame(binary.BigEndian.Uint16(aduRequest), id, pdu)
+		return
+	}
+	aduResponse, err = src.Encode(pdu)
+	return
+}
+
+// frameSpec returns the unit id and function code of the frame of the mode.
+func frameSpec(mode string, adu []byte) (id, function byte) {
+	switch strings.ToLower(mode) {
+	case "tcp":
+		if len(adu) > tcpHeaderSize {
+			return adu[6], adu[7]
+		}
+	case "ascii":
+		if len(adu) >= 5 {
+			id, _ = readHex(adu[1:])
+			function, _ = readHex(adu[3:])
+		}
+	default:
+		if len(adu) >= 2 {
+			return adu[0], adu[1]
+		}
+	}
+	return
+}
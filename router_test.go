@@ -0,0 +1,123 @@
+package modbus
+
+import (
+	"errors"
+	"testing"
+)
+
+// unitRecorder records the unit id of the requests passed to the RTU device.
+type unitRecorder struct {
+	device *registerDevice
+	units  []byte
+}
+
+func (u *unitRecorder) Send(aduRequest []byte) (aduResponse []byte, warn, err error) {
+	u.units = append(u.units, aduRequest[0])
+	return u.device.Send(aduRequest)
+}
+
+func TestRouterRewrite(t *testing.T) {
+	a, b := &unitRecorder{device: &registerDevice{}}, &unitRecorder{device: &registerDevice{}}
+	a.device.registers[0], b.device.registers[0] = 0xAAAA, 0xBBBB
+	tests := []struct {
+		mode    string
+		unit    byte
+		backend *unitRecorder
+		target  byte
+	}{
+		{"rtu", 3, a, 3},
+		{"rtu", 10, b, 1},
+		{"rtu", 12, b, 3},
+		{"tcp", 3, a, 3},
+		{"tcp", 11, b, 2},
+		{"ascii", 12, b, 3},
+	}
+	for _, test := range tests {
+		router := NewRouter(test.mode)
+		for _, rule := range []RouteRule{
+			{First: 1, Last: 5, Backend: a, Mode: "rtu"},
+			{First: 10, Last: 12, Backend: b, Mode: "rtu", Rewrite: true, Target: 1},
+		} {
+			if err := router.Add(rule); err != nil {
+				t.Fatal(err)
+			}
+		}
+		a.units, b.units = nil, nil
+		values, warn, err := NewSClient(test.unit, test.mode).ReadValues(router, TableHoldingRegisters, 0, 1)
+		if err != nil || warn != nil {
+			t.Errorf("%v unit %v: %v %v", test.mode, test.unit, warn, err)
+			continue
+		}
+		if len(test.backend.units) != 1 || test.backend.units[0] != test.target || len(a.units)+len(b.units) != 1 {
+			t.Errorf("%v unit %v: backend units %v and %v, '%v' expected", test.mode, test.unit, a.units, b.units, test.target)
+		}
+		if expected := byte(test.backend.device.registers[0]); len(values) != 2 || values[1] != expected {
+			t.Errorf("%v unit %v: values % x", test.mode, test.unit, values)
+		}
+	}
+}
+
+func TestRouterRules(t *testing.T) {
+	dev := &unitRecorder{device: &registerDevice{}}
+	router := NewRouter("rtu")
+	tests := []struct {
+		rule RouteRule
+		err  bool
+	}{
+		{RouteRule{First: 1, Last: 5, Backend: dev, Mode: "RTU"}, false},
+		{RouteRule{First: 3, Last: 8, Backend: dev, Mode: "rtu", Rewrite: true, Target: 100}, false},
+		{RouteRule{First: 250, Last: 255, Backend: dev, Mode: "rtu", Rewrite: true, Target: 251}, true},
+		{RouteRule{First: 5, Last: 1, Backend: dev, Mode: "rtu"}, true},
+		{RouteRule{First: 1, Last: 1, Mode: "rtu"}, true},
+		{RouteRule{First: 1, Last: 1, Backend: dev}, true},
+	}
+	for _, test := range tests {
+		if err := router.Add(test.rule); (err != nil) != test.err {
+			t.Errorf("%+v: %v", test.rule, err)
+		}
+	}
+	// the first matching rule is used
+	if rule, ok := router.Route(4); !ok || rule.Rewrite || rule.Mode != "rtu" {
+		t.Errorf("route of 4: %+v %v", rule, ok)
+	}
+	if rule, ok := router.Route(7); !ok || !rule.Rewrite || rule.target(7) != 104 {
+		t.Errorf("route of 7: %+v %v", rule, ok)
+	}
+	if _, ok := router.Route(9); ok {
+		t.Errorf("route of 9 found")
+	}
+	_, _, err := NewSClient(9, "rtu").ReadValues(router, TableHoldingRegisters, 0, 1)
+	if err == nil || len(dev.units) != 0 {
+		t.Errorf("unrouted unit: %v, backend units %v", err, dev.units)
+	}
+	// the response is verified against the rewritten request
+	router = NewRouter("rtu")
+	router.Add(RouteRule{First: 1, Last: 1, Backend: wrongUnit{dev.device}, Mode: "rtu", Rewrite: true, Target: 2})
+	if _, warn, err := NewSClient(1, "rtu").ReadValues(router, TableHoldingRegisters, 0, 1); err != nil || warn == nil {
+		t.Errorf("response of wrong unit: %v %v", warn, err)
+	}
+	silent := errors.New("no response")
+	router.Add(RouteRule{First: 3, Last: 3, Backend: faultySender{warn: silent}, Mode: "rtu", Rewrite: true, Target: 1})
+	if _, warn, _ := NewSClient(3, "rtu").ReadValues(router, TableHoldingRegisters, 0, 1); warn != silent {
+		t.Errorf("warning of backend %v", warn)
+	}
+}
+
+// wrongUnit answers requests of the device as unit 99.
+type wrongUnit struct {
+	device *registerDevice
+}
+
+func (w wrongUnit) Send(aduRequest []byte) (aduResponse []byte, warn, err error) {
+	response, warn, err := w.device.Send(aduRequest)
+	if err != nil || warn != nil {
+		return
+	}
+	mbc := NewSClient(99, "rtu")
+	pdu, err := NewSClient(response[0], "rtu").Decode(response)
+	if err != nil {
+		return
+	}
+	aduResponse, err = mbc.Encode(pdu)
+	return
+}
@@ -0,0 +1,349 @@
+package modbus
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PollResult is the outcome of one poll run.
+type PollResult struct {
+	Name     string
+	Client   *MBClient
+	Points   []Point
+	Values   [][]byte
+	Warn     error
+	Err      error
+	Started  time.Time
+	Duration time.Duration
+	// Delay of the start behind the scheduled time
+	Late time.Duration
+}
+
+// PollStats has counters of a poll.
+type PollStats struct {
+	Runs     uint64
+	Warnings uint64
+	Errors   uint64
+	// Cycles skipped because the bus was busy
+	Misses uint64
+	// Runs longer than the interval
+	Overruns     uint64
+	LastDuration time.Duration
+	AvgDuration  time.Duration
+	// Interval of the last cycle, stretched while the bus is oversubscribed
+	Interval time.Duration
+}
+
+type poll struct {
+	name     string
+	client   *MBClient
+	plan     *ReadPlan
+	interval time.Duration
+	handler  func(*PollResult)
+	next     time.Time
+	stats    PollStats
+}
+
+// Scheduler runs periodic reads on one bus. Polls are executed one by one in the order of their
+// deadline, so the bus is never used concurrently, and first runs are spread over the interval.
+// While the polls need more time than the bus has, all intervals are stretched by the load,
+// so every poll keeps its share of the bus.
+type Scheduler struct {
+	// Pause between transactions on the bus, changed by SetGap while running
+	Gap time.Duration
+	// Offset between first runs of polls
+	Spread time.Duration
+	Logger *log.Logger
+
+	mu      sync.Mutex
+	mbt     ApiSender
+	planner *Planner
+	polls   map[string]*poll
+	results chan *PollResult
+	dropped uint64
+	wake    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func NewScheduler(mbt ApiSender) *Scheduler {
+	return &Scheduler{
+		Spread:  50 * time.Millisecond,
+		mbt:     mbt,
+		planner: NewPlanner(0),
+		polls:   make(map[string]*poll),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// SetPlanner sets the planner used to build the requests of added polls.
+func (s *Scheduler) SetPlanner(planner *Planner) {
+	s.mu.Lock()
+	s.planner = planner
+	s.mu.Unlock()
+}
+
+// SetGap sets the pause between transactions on the bus.
+func (s *Scheduler) SetGap(gap time.Duration) {
+	s.mu.Lock()
+	s.Gap = gap
+	s.mu.Unlock()
+}
+
+func (s *Scheduler) SetLogger(logger *log.Logger) {
+	s.mu.Lock()
+	s.Logger = logger
+	s.mu.Unlock()
+}
+
+// logf writes to the logger. Caller must hold the mutex.
+func (s *Scheduler) logf(format string, v ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, v...)
+	}
+}
+
+// Add registers points of the device read every interval, handler may be nil.
+// A poll with the same name is replaced.
+func (s *Scheduler) Add(name string, client *MBClient, points []Point, interval time.Duration, handler func(*PollResult)) error {
+	if interval <= 0 {
+		return fmt.Errorf("modbus: poll '%v' interval must be positive", name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	plan, err := s.planner.Plan(points)
+	if err != nil {
+		return err
+	}
+	offset := time.Duration(len(s.polls)) * s.Spread % interval
+	s.polls[name] = &poll{
+		name:     name,
+		client:   client,
+		plan:     plan,
+		interval: interval,
+		handler:  handler,
+		next:     time.Now().Add(offset),
+	}
+	s.notify()
+	return nil
+}
+
+func (s *Scheduler) Remove(name string) {
+	s.mu.Lock()
+	delete(s.polls, name)
+	s.notify()
+	s.mu.Unlock()
+}
+
+// Results returns the channel of poll results, created with the buffer size on the first call.
+// Results are dropped when the channel is full.
+func (s *Scheduler) Results(size int) <-chan *PollResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.results == nil {
+		s.results = make(chan *PollResult, size)
+	}
+	return s.results
+}
+
+// Stats returns counters of the poll.
+func (s *Scheduler) Stats(name string) (stats PollStats, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p, ok := s.polls[name]; ok {
+		return p.stats, true
+	}
+	return
+}
+
+// DroppedResults returns count of results not delivered because the channel was full.
+func (s *Scheduler) DroppedResults() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.dropped
+}
+
+// Load estimates the bus utilisation from the average poll durations, above 1 the bus is oversubscribed
+// and the intervals are stretched by the load.
+func (s *Scheduler) Load() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.load()
+}
+
+func (s *Scheduler) load() (load float64) {
+	for _, p := range s.polls {
+		load += float64(p.stats.AvgDuration+s.Gap) / float64(p.interval)
+	}
+	return
+}
+
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.run(s.stop, s.done)
+}
+
+// Stop stops the scheduler and waits for the running poll.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop = nil
+	s.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// notify wakes up the scheduler after polls changed. Caller must hold the mutex.
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// earliest returns the poll with the nearest deadline. Caller must hold the mutex.
+func (s *Scheduler) earliest() *poll {
+	polls := make([]*poll, 0, len(s.polls))
+	for _, p := range s.polls {
+		polls = append(polls, p)
+	}
+	if len(polls) == 0 {
+		return nil
+	}
+	sort.Slice(polls, func(i, j int) bool {
+		if polls[i].next.Equal(polls[j].next) {
+			return polls[i].name < polls[j].name
+		}
+		return polls[i].next.Before(polls[j].next)
+	})
+	return polls[0]
+}
+
+func (s *Scheduler) run(stop, done chan struct{}) {
+	defer close(done)
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		p := s.earliest()
+		s.mu.Unlock()
+
+		wait := time.Hour
+		if p != nil {
+			wait = time.Until(p.next)
+		}
+		if wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-stop:
+				return
+			case <-s.wake:
+				if !timer.Stop() {
+					<-timer.C
+				}
+				continue
+			case <-timer.C:
+				continue
+			}
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if !s.execute(p) {
+			continue
+		}
+		s.mu.Lock()
+		gap := s.Gap
+		s.mu.Unlock()
+		if gap > 0 {
+			time.Sleep(gap)
+		}
+	}
+}
+
+// execute runs the poll, updates the statistics and delivers the result.
+// Polls removed or replaced since they were chosen are not run.
+func (s *Scheduler) execute(p *poll) (executed bool) {
+	s.mu.Lock()
+	current := s.polls[p.name] == p
+	s.mu.Unlock()
+	if !current {
+		return false
+	}
+	result := &PollResult{
+		Name:    p.name,
+		Client:  p.client,
+		Points:  p.plan.Points,
+		Started: time.Now(),
+	}
+	result.Late = result.Started.Sub(p.next)
+	result.Values, result.Warn, result.Err = p.plan.Execute(p.client, s.mbt)
+	result.Duration = time.Since(result.Started)
+
+	s.mu.Lock()
+	stats := &p.stats
+	stats.Runs++
+	if result.Warn != nil {
+		stats.Warnings++
+	}
+	if result.Err != nil {
+		stats.Errors++
+	}
+	if result.Duration > p.interval {
+		stats.Overruns++
+	}
+	stats.LastDuration = result.Duration
+	if stats.AvgDuration == 0 {
+		stats.AvgDuration = result.Duration
+	} else {
+		stats.AvgDuration = (stats.AvgDuration*7 + result.Duration) / 8
+	}
+	interval := p.interval
+	if load := s.load(); load > 1 {
+		interval = time.Duration(float64(interval) * load)
+		s.logf("modbus: bus is oversubscribed, load %.2f, poll '%v' interval stretched to %v", load, p.name, interval)
+	}
+	stats.Interval = interval
+	// Skip the cycles missed while the bus was busy instead of running them in a burst
+	p.next = p.next.Add(interval)
+	if now := time.Now(); p.next.Before(now) {
+		missed := now.Sub(p.next)/interval + 1
+		stats.Misses += uint64(missed)
+		p.next = p.next.Add(missed * interval)
+	}
+	results := s.results
+	if results != nil {
+		select {
+		case results <- result:
+		default:
+			s.dropped++
+		}
+	}
+	s.mu.Unlock()
+
+	if p.handler != nil {
+		p.handler(result)
+	}
+	return true
+}
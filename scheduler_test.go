@@ -0,0 +1,130 @@
+package modbus
+
+import (
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowDevice answers RTU reads of unit 1 with zero values after the delay, counting the requests.
+type slowDevice struct {
+	delay time.Duration
+
+	mu       sync.Mutex
+	requests int
+}
+
+func (d *slowDevice) Send(aduRequest []byte) (aduResponse []byte, warn, err error) {
+	d.mu.Lock()
+	d.requests++
+	d.mu.Unlock()
+	time.Sleep(d.delay)
+	mbc := NewSClient(1, "rtu")
+	pdu, err := mbc.Decode(aduRequest)
+	if err != nil {
+		return
+	}
+	quantity := int(pdu.Data[2])<<8 | int(pdu.Data[3])
+	aduResponse, err = mbc.Encode(&ProtocolDataUnit{FunctionCode: pdu.FunctionCode, Data: append([]byte{byte(2 * quantity)}, make([]byte, 2*quantity)...)})
+	return
+}
+
+func (d *slowDevice) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.requests
+}
+
+var schedulerPoints = []Point{{TableHoldingRegisters, 0, 2}}
+
+func TestSchedulerRuns(t *testing.T) {
+	dev := &slowDevice{}
+	s := NewScheduler(dev)
+	results := s.Results(10)
+	if err := s.Add("a", NewSClient(1, "rtu"), schedulerPoints, 20*time.Millisecond, nil); err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	for n := 0; n < 3; n++ {
+		select {
+		case result := <-results:
+			if result.Name != "a" || result.Err != nil || result.Warn != nil || len(result.Values) != 1 || len(result.Values[0]) != 4 {
+				t.Errorf("result %+v", result)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("'%v' results received", n)
+		}
+	}
+	s.Stop()
+	stats, ok := s.Stats("a")
+	if !ok || stats.Runs < 3 || stats.Errors != 0 || stats.Interval != 20*time.Millisecond {
+		t.Errorf("stats %+v", stats)
+	}
+	if err := s.Add("b", NewSClient(1, "rtu"), schedulerPoints, 0, nil); err == nil {
+		t.Errorf("zero interval accepted")
+	}
+}
+
+func TestSchedulerStretch(t *testing.T) {
+	// two polls of 15ms every 20ms need 150% of the bus
+	dev := &slowDevice{delay: 15 * time.Millisecond}
+	s := NewScheduler(dev)
+	for _, name := range []string{"a", "b"} {
+		if err := s.Add(name, NewSClient(1, "rtu"), schedulerPoints, 20*time.Millisecond, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Start()
+	time.Sleep(300 * time.Millisecond)
+	s.Stop()
+	if load := s.Load(); load < 1.2 {
+		t.Errorf("load %.2f", load)
+	}
+	for _, name := range []string{"a", "b"} {
+		stats, _ := s.Stats(name)
+		if stats.Interval < 25*time.Millisecond || stats.Interval > 60*time.Millisecond {
+			t.Errorf("%v: interval %v not stretched by the load", name, stats.Interval)
+		}
+		// every poll keeps its share of the bus
+		if stats.Runs < 3 {
+			t.Errorf("%v: '%v' runs", name, stats.Runs)
+		}
+	}
+}
+
+func TestSchedulerRemovedPoll(t *testing.T) {
+	dev := &slowDevice{}
+	s := NewScheduler(dev)
+	s.Add("a", NewSClient(1, "rtu"), schedulerPoints, time.Second, nil)
+	removed := s.polls["a"]
+	s.Remove("a")
+	if s.execute(removed) || dev.count() != 0 {
+		t.Errorf("removed poll executed")
+	}
+	s.Add("a", NewSClient(1, "rtu"), schedulerPoints, time.Second, nil)
+	replaced := s.polls["a"]
+	s.Add("a", NewSClient(1, "rtu"), schedulerPoints, time.Second, nil)
+	if s.execute(replaced) || dev.count() != 0 {
+		t.Errorf("replaced poll executed")
+	}
+	if !s.execute(s.polls["a"]) || dev.count() != 1 {
+		t.Errorf("current poll not executed")
+	}
+}
+
+func TestSchedulerSetters(t *testing.T) {
+	dev := &slowDevice{delay: time.Millisecond}
+	s := NewScheduler(dev)
+	s.Add("a", NewSClient(1, "rtu"), schedulerPoints, 2*time.Millisecond, nil)
+	s.Start()
+	defer s.Stop()
+	logger := log.New(io.Discard, "", 0)
+	for n := 0; n < 50; n++ {
+		s.SetGap(time.Duration(n%3) * time.Millisecond)
+		s.SetLogger(logger)
+		s.SetPlanner(NewPlanner(uint16(n)))
+		time.Sleep(time.Millisecond)
+	}
+}
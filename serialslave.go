@@ -0,0 +1,219 @@
+package modbus
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+const (
+	// slaveASCIITimeout is the read timeout of ASCII slave ports, frames are delimited by CRLF.
+	slaveASCIITimeout = 100 * time.Millisecond
+	// slavePollInterval is the longest wait for Close while the RTU slave port is silent.
+	slavePollInterval = 100 * time.Millisecond
+)
+
+// serialSlave is the serving loop of a serial slave port, shared by Bridge and SerialServer.
+type serialSlave struct {
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+}
+
+// serve opens the port in mode (rtu, ascii), by open if set, and answers the request frames
+// by handle until close. Nil response of handle is not sent.
+func (ss *serialSlave) serve(mode string, open PortOpener, address string, baudrate, databits int, parity string, stopbits int,
+	logger *log.Logger, handle func(mode string, frame []byte, received time.Time) []byte) error {
+	var port *serialPort
+	var read func() ([]byte, error)
+	mode = strings.ToLower(mode)
+	switch mode {
+	case "rtu":
+		rtu := &rtuTransporter{}
+		rtu.Set(address, baudrate, databits, parity, stopbits, 0, 0)
+		rtu.open = open
+		if err := rtu.Connect(); err != nil {
+			return err
+		}
+		port = &rtu.serialPort
+		read = func() (frame []byte, err error) {
+			if frame, err = rtu.readFrame(time.Now().Add(slavePollInterval)); err == serial.ErrTimeout {
+				err = nil
+			}
+			return
+		}
+	case "ascii":
+		ascii := &asciiTransporter{}
+		ascii.Set(address, baudrate, databits, parity, stopbits, int64(slaveASCIITimeout/time.Millisecond), 0)
+		ascii.open = open
+		if err := ascii.Connect(); err != nil {
+			return err
+		}
+		port = &ascii.serialPort
+		reader := &asciiRequestReader{ascii: ascii}
+		read = func() ([]byte, error) {
+			return reader.read(time.Now().Add(slavePollInterval))
+		}
+	default:
+		return fmt.Errorf("modbus: serial slave mode '%v' is not rtu or ascii", mode)
+	}
+	port.Logger = logger
+	ss.mu.Lock()
+	if ss.closed || ss.done != nil {
+		ss.mu.Unlock()
+		port.Close()
+		return fmt.Errorf("modbus: serial slave is closed or serving")
+	}
+	done := make(chan struct{})
+	ss.done = done
+	ss.mu.Unlock()
+	defer func() {
+		port.Close()
+		close(done)
+	}()
+	// the port is polled and closed by this goroutine only
+	for {
+		frame, err := read()
+		if err != nil {
+			return err
+		}
+		ss.mu.Lock()
+		closed := ss.closed
+		ss.mu.Unlock()
+		if closed {
+			return nil
+		}
+		if len(frame) == 0 {
+			continue
+		}
+		if response := handle(mode, frame, time.Now()); response != nil {
+			if _, err := port.port.Write(response); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// close stops serve and waits until the port is closed.
+func (ss *serialSlave) close() {
+	ss.mu.Lock()
+	ss.closed = true
+	done := ss.done
+	ss.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+// asciiRequestReader reads request frames of an ASCII slave port.
+type asciiRequestReader struct {
+	ascii  *asciiTransporter
+	data   [asciiMaxSize]byte
+	length int
+}
+
+// read reads a request frame from the last ':' to CRLF, bytes before are dropped.
+// Empty frame is returned if the line is silent, an incomplete frame is dropped then,
+// or at the deadline while a frame is received, it is continued by the next read.
+func (r *asciiRequestReader) read(deadline time.Time) (frame []byte, err error) {
+	for time.Now().Before(deadline) {
+		n, err := r.ascii.port.Read(r.data[r.length:])
+		if err == serial.ErrTimeout {
+			if r.length > 0 {
+				r.ascii.serialPort.logf("modbus: dropped %v bytes of incomplete frame\n", r.length)
+				r.length = 0
+			}
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.length += n
+		// ':' is not used in the frame body, an incomplete frame is dropped on the next start
+		if start := strings.LastIndexByte(string(r.data[:r.length]), asciiStart[0]); start < 0 {
+			r.length = 0
+		} else if start > 0 {
+			r.length = copy(r.data[:], r.data[start:r.length])
+		}
+		if r.length > len(asciiEnd) && string(r.data[r.length-len(asciiEnd):r.length]) == asciiEnd {
+			frame = append([]byte(nil), r.data[:r.length]...)
+			r.length = 0
+			return frame, nil
+		}
+		if r.length >= asciiMaxSize {
+			r.ascii.serialPort.logf("modbus: dropped %v bytes without end of frame\n", r.length)
+			r.length = 0
+		}
+	}
+	return nil, nil
+}
+
+// SerialServer is the Modbus RTU and ASCII slave core, requests of the serial port are
+// answered by the handler in order:
+//  srv := modbus.NewSerialServer(handler)
+//  srv.ListenAndServe("rtu", "/dev/ttyUSB0", 19200, 8, "E", 1)
+// Requests the handler does not answer, broadcasts included, get no response.
+type SerialServer struct {
+	Handler Handler
+	Logger  *log.Logger
+
+	slave serialSlave
+}
+
+func NewSerialServer(handler Handler) *SerialServer {
+	return &SerialServer{Handler: handler}
+}
+
+// ListenAndServe opens the serial port in mode (rtu, ascii) and serves the requests until Close.
+func (s *SerialServer) ListenAndServe(mode, address string, baudrate, databits int, parity string, stopbits int) error {
+	return s.slave.serve(mode, nil, address, baudrate, databits, parity, stopbits, s.Logger, s.serve)
+}
+
+// ServePort serves the requests on the port opened by open, e.g. a pty, until Close.
+func (s *SerialServer) ServePort(mode string, open PortOpener, baudrate, databits int, parity string, stopbits int) error {
+	if open == nil {
+		return fmt.Errorf("modbus: port opener is nil")
+	}
+	return s.slave.serve(mode, open, "port", baudrate, databits, parity, stopbits, s.Logger, s.serve)
+}
+
+// Close stops serving and waits until the serial port is closed.
+func (s *SerialServer) Close() error {
+	s.slave.close()
+	return nil
+}
+
+func (s *SerialServer) logf(format string, v ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, v...)
+	}
+}
+
+// serve answers the request frame by the handler, nil if not answered.
+func (s *SerialServer) serve(mode string, frame []byte, received time.Time) []byte {
+	id, _ := frameSpec(mode, frame)
+	sc := NewSClient(id, mode)
+	if mode == "ascii" && sc.Verify(frame, frame) != nil || mode == "rtu" && len(frame) < rtuMinSize {
+		s.logf("modbus: serial server dropped invalid frame % x\n", frame)
+		return nil
+	}
+	pdu, err := sc.Decode(frame)
+	if err != nil {
+		s.logf("modbus: serial server dropped frame: %v\n", err)
+		return nil
+	}
+	response := s.Handler.ServeModbus(&Request{UnitID: id, PDU: pdu})
+	if response == nil || id == 0 {
+		return nil
+	}
+	adu, err := sc.Encode(response)
+	if err != nil {
+		s.logf("modbus: serial server unit '%v': %v\n", id, err)
+		return nil
+	}
+	return adu
+}
@@ -0,0 +1,89 @@
+package modbus
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSerialSlaveCloseIncompleteFrame(t *testing.T) {
+	for _, mode := range []string{"rtu", "ascii"} {
+		master, port := net.Pipe()
+		var slave serialSlave
+		served := make(chan error, 1)
+		go func() {
+			served <- slave.serve(mode, FixedPort(port), "port", 19200, 8, "E", 1, nil, func(string, []byte, time.Time) []byte {
+				return nil
+			})
+		}()
+		// start of a frame without end, the line stays noisy until close
+		stop := make(chan struct{})
+		go func() {
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				master.SetWriteDeadline(time.Now().Add(10 * time.Millisecond))
+				master.Write([]byte(":0103"))
+				time.Sleep(time.Millisecond)
+			}
+		}()
+		time.Sleep(50 * time.Millisecond)
+		closed := make(chan struct{})
+		go func() {
+			slave.close()
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%v: close hangs", mode)
+		}
+		close(stop)
+		if err := <-served; err != nil {
+			t.Errorf("%v: %v", mode, err)
+		}
+		master.Close()
+	}
+}
+
+func TestSerialSlaveServe(t *testing.T) {
+	for _, mode := range []string{"rtu", "ascii"} {
+		master, port := net.Pipe()
+		var slave serialSlave
+		served := make(chan error, 1)
+		go func() {
+			served <- slave.serve(mode, FixedPort(port), "port", 19200, 8, "E", 1, nil, func(mode string, frame []byte, _ time.Time) []byte {
+				// echo of the request
+				return frame
+			})
+		}()
+		request, err := NewSClient(1, mode).ReadHoldingRegisters(0, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// ASCII frames are written in chunks, noise before the frame is dropped
+		chunks := [][]byte{request}
+		if mode == "ascii" {
+			chunks = [][]byte{[]byte("noise"), request[:5], request[5:]}
+		}
+		for _, chunk := range chunks {
+			master.Write(chunk)
+			time.Sleep(time.Millisecond)
+		}
+		response := make([]byte, len(request))
+		master.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := io.ReadFull(master, response); err != nil || !bytes.Equal(response, request) {
+			t.Errorf("%v: response % x, %v", mode, response, err)
+		}
+		slave.close()
+		if err := <-served; err != nil {
+			t.Errorf("%v: %v", mode, err)
+		}
+		master.Close()
+	}
+}
@@ -0,0 +1,205 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Request is a request received by the server.
+type Request struct {
+	TransactionID uint16
+	UnitID        byte
+	PDU           *ProtocolDataUnit
+	RemoteAddr    net.Addr
+}
+
+// Handler answers requests of the server. Nil response is not sent, as for broadcasts.
+type Handler interface {
+	ServeModbus(req *Request) *ProtocolDataUnit
+}
+
+// HandlerFunc adapts the function to Handler.
+type HandlerFunc func(req *Request) *ProtocolDataUnit
+
+func (f HandlerFunc) ServeModbus(req *Request) *ProtocolDataUnit {
+	return f(req)
+}
+
+// ExceptionResponse returns the exception response to the request function.
+func ExceptionResponse(function, exceptionCode byte) *ProtocolDataUnit {
+	return &ProtocolDataUnit{FunctionCode: function | 0x80, Data: []byte{exceptionCode}}
+}
+
+// Server is the Modbus TCP server core, each connection is served by a goroutine
+// and its requests are answered in order.
+type Server struct {
+	Handler Handler
+	// Connections idle longer are closed, zero disables
+	IdleTimeout time.Duration
+	// Maximum count of connections, zero is unlimited
+	MaxConns int
+	Logger   *log.Logger
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+func NewServer(handler Handler) *Server {
+	return &Server{Handler: handler, IdleTimeout: tcpIdleTimeout}
+}
+
+// ListenAndServe listens on the TCP address and serves connections until Close.
+func (s *Server) ListenAndServe(address string) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections of the listener until Close, the listener is closed on return.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return fmt.Errorf("modbus: server is closed")
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closed || (s.MaxConns > 0 && len(s.conns) >= s.MaxConns) {
+			s.mu.Unlock()
+			s.logf("modbus: refusing connection from %v, limit of '%v' connections", conn.RemoteAddr(), s.MaxConns)
+			conn.Close()
+			continue
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops the listeners, closes the connections and waits for their goroutines.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+// Conns returns count of open connections.
+func (s *Server) Conns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+func (s *Server) logf(format string, v ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, v...)
+	}
+}
+
+// serveConn reads requests of the connection and writes the responses with the same transaction id.
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+		s.wg.Done()
+	}()
+	var header [tcpHeaderSize]byte
+	for {
+		if s.IdleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(s.IdleTimeout))
+		}
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			if err != io.EOF {
+				s.logf("modbus: connection %v: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		protocol := binary.BigEndian.Uint16(header[2:])
+		length := int(binary.BigEndian.Uint16(header[4:]))
+		if protocol != tcpProtocolIdentifier || length < 2 || length > tcpMaxLength-tcpHeaderSize+1 {
+			s.logf("modbus: connection %v: invalid header % x", conn.RemoteAddr(), header)
+			return
+		}
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			s.logf("modbus: connection %v: %v", conn.RemoteAddr(), err)
+			return
+		}
+		req := &Request{
+			TransactionID: binary.BigEndian.Uint16(header[:]),
+			UnitID:        header[6],
+			PDU:           &ProtocolDataUnit{FunctionCode: pdu[0], Data: pdu[1:]},
+			RemoteAddr:    conn.RemoteAddr(),
+		}
+		res := s.Handler.ServeModbus(req)
+		if res == nil {
+			continue
+		}
+		if _, err := conn.Write(tcpFrame(req.TransactionID, req.UnitID, res)); err != nil {
+			s.logf("modbus: connection %v: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// tcpFrame encodes the PDU with the MBAP header.
+func tcpFrame(transactionID uint16, unitID byte, pdu *ProtocolDataUnit) []byte {
+	adu := make([]byte, tcpHeaderSize+1+len(pdu.Data))
+	binary.BigEndian.PutUint16(adu, transactionID)
+	binary.BigEndian.PutUint16(adu[2:], tcpProtocolIdentifier)
+	binary.BigEndian.PutUint16(adu[4:], uint16(2+len(pdu.Data)))
+	adu[6] = unitID
+	adu[tcpHeaderSize] = pdu.FunctionCode
+	copy(adu[tcpHeaderSize+1:], pdu.Data)
+	return adu
+}
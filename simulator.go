@@ -0,0 +1,783 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// simInterval is the default update interval of the generators.
+const simInterval = 100 * time.Millisecond
+
+// Simulator is the Handler of simulated devices, each unit id has its own register image
+// of coils, discrete inputs, holding and input registers:
+//  sim := modbus.NewSimulator()
+//  dev, _ := sim.Add(1, rm)
+//  dev.Set("setpoint", 21.5)
+//  dev.Animate("voltage", modbus.Sine(230, 5, 10*time.Second))
+//  sim.Start(100 * time.Millisecond)
+//  modbus.NewServer(sim).ListenAndServe("127.0.0.1:5020")
+// Requests to units not simulated are not answered as on a bus without the device,
+// broadcast writes change all devices.
+type Simulator struct {
+	Logger *log.Logger
+
+	mu    sync.Mutex
+	units map[byte]*SimDevice
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func NewSimulator() *Simulator {
+	return &Simulator{units: make(map[byte]*SimDevice)}
+}
+
+// Add adds the device of the unit id, tags of the register map are addressed by name.
+// The register map may be nil.
+func (sim *Simulator) Add(unit byte, rm *RegisterMap) (*SimDevice, error) {
+	if unit == 0 || unit > 247 {
+		return nil, fmt.Errorf("modbus: simulated unit id '%v' must be between '%v' and '%v'", unit, 1, 247)
+	}
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	if _, ok := sim.units[unit]; ok {
+		return nil, fmt.Errorf("modbus: unit '%v' is already simulated", unit)
+	}
+	dev := newSimDevice(unit, rm)
+	dev.sim = sim
+	sim.units[unit] = dev
+	return dev, nil
+}
+
+// Device returns the device of the unit id.
+func (sim *Simulator) Device(unit byte) (*SimDevice, bool) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	dev, ok := sim.units[unit]
+	return dev, ok
+}
+
+// devices returns the devices of the unit id, all for broadcasts.
+func (sim *Simulator) devices(unit byte) []*SimDevice {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	if unit != 0 {
+		if dev, ok := sim.units[unit]; ok {
+			return []*SimDevice{dev}
+		}
+		return nil
+	}
+	devices := make([]*SimDevice, 0, len(sim.units))
+	for _, dev := range sim.units {
+		devices = append(devices, dev)
+	}
+	return devices
+}
+
+// Start updates the animated tags of all devices every interval, default 100ms, until Stop.
+// Elapsed time of the generators starts now.
+func (sim *Simulator) Start(interval time.Duration) {
+	if interval <= 0 {
+		interval = simInterval
+	}
+	sim.Stop()
+	sim.mu.Lock()
+	stop, done := make(chan struct{}), make(chan struct{})
+	sim.stop, sim.done = stop, done
+	sim.mu.Unlock()
+	go func() {
+		defer close(done)
+		start := time.Now()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		sim.update(0)
+		for {
+			select {
+			case now := <-ticker.C:
+				sim.update(now.Sub(start))
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the updates of Start, the values are kept.
+func (sim *Simulator) Stop() {
+	sim.mu.Lock()
+	stop, done := sim.stop, sim.done
+	sim.stop, sim.done = nil, nil
+	sim.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (sim *Simulator) update(elapsed time.Duration) {
+	for _, dev := range sim.devices(0) {
+		dev.update(elapsed)
+	}
+}
+
+func (sim *Simulator) ServeModbus(req *Request) *ProtocolDataUnit {
+	devices := sim.devices(req.UnitID)
+	if len(devices) == 0 {
+		return nil
+	}
+	var response *ProtocolDataUnit
+	for _, dev := range devices {
+		response = dev.serve(req.PDU)
+	}
+	if req.UnitID == 0 {
+		return nil
+	}
+	return response
+}
+
+// SimDevice is the register image of a simulated device.
+// Values written by clients to animated tags are overwritten by the next update.
+type SimDevice struct {
+	Unit byte
+	Map  *RegisterMap
+
+	mu      sync.Mutex
+	bits    [2][]bool   // coils, discrete inputs
+	regs    [2][]uint16 // holding, input registers
+	strict  bool
+	defined [4][]bool // addresses covered by tags of Map, by table
+	anims   []simAnimation
+	sim     *Simulator
+}
+
+type simAnimation struct {
+	tag *Tag
+	gen Generator
+}
+
+func newSimDevice(unit byte, rm *RegisterMap) *SimDevice {
+	dev := &SimDevice{Unit: unit, Map: rm}
+	for i := range dev.bits {
+		dev.bits[i] = make([]bool, 0x10000)
+		dev.regs[i] = make([]uint16, 0x10000)
+	}
+	return dev
+}
+
+func (dev *SimDevice) logf(format string, v ...interface{}) {
+	if dev.sim != nil && dev.sim.Logger != nil {
+		dev.sim.Logger.Printf(format, v...)
+	}
+}
+
+// SetStrict answers requests of addresses not covered by the tags of the register map
+// with exception IllegalDataAddress, as real devices do. All addresses are served otherwise.
+func (dev *SimDevice) SetStrict(strict bool) {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	dev.strict = strict
+	if !strict || dev.defined[0] != nil {
+		return
+	}
+	for i := range dev.defined {
+		dev.defined[i] = make([]bool, 0x10000)
+	}
+	if dev.Map == nil {
+		return
+	}
+	for _, tag := range dev.Map.Tags {
+		for i := 0; i < int(tag.Quantity()); i++ {
+			dev.defined[tag.Table-TableCoils][int(tag.Address)+i] = true
+		}
+	}
+}
+
+// Set sets the scaled value of the tag of the register map, as written by the device itself,
+// e.g. measurements in input registers.
+func (dev *SimDevice) Set(name string, value interface{}) error {
+	tag, err := dev.tag(name)
+	if err != nil {
+		return err
+	}
+	return dev.SetTag(tag, value)
+}
+
+// Get returns the scaled value of the tag of the register map.
+func (dev *SimDevice) Get(name string) (interface{}, error) {
+	tag, err := dev.tag(name)
+	if err != nil {
+		return nil, err
+	}
+	return dev.GetTag(tag)
+}
+
+// SetTag sets the scaled value of the tag, which needs not be in the register map.
+func (dev *SimDevice) SetTag(tag *Tag, value interface{}) error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	raw, err := tag.EncodeWith(value, dev.env(tag))
+	if err != nil {
+		return err
+	}
+	dev.store(tag.Table, tag.Address, tag.Quantity(), raw)
+	return nil
+}
+
+// GetTag returns the scaled value of the tag, which needs not be in the register map.
+func (dev *SimDevice) GetTag(tag *Tag) (interface{}, error) {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	return tag.DecodeWith(dev.load(tag.Table, tag.Address, tag.Quantity()), dev.env(tag))
+}
+
+// SetRaw sets registers or bits from address, registers as big endian bytes and bits packed LSB first.
+func (dev *SimDevice) SetRaw(table Table, address, quantity uint16, values []byte) error {
+	if table < TableCoils || table > TableInputRegisters || int(address)+int(quantity) > 0x10000 {
+		return fmt.Errorf("modbus: range %v:%v+%v is invalid", table, address, quantity)
+	}
+	if size := rawSize(table, quantity); len(values) < size {
+		return fmt.Errorf("modbus: values of %v:%v+%v need '%v' bytes, not '%v'", table, address, quantity, size, len(values))
+	}
+	dev.mu.Lock()
+	dev.store(table, address, quantity, values)
+	dev.mu.Unlock()
+	return nil
+}
+
+// Raw returns registers or bits from address, registers as big endian bytes and bits packed LSB first.
+func (dev *SimDevice) Raw(table Table, address, quantity uint16) ([]byte, error) {
+	if table < TableCoils || table > TableInputRegisters || int(address)+int(quantity) > 0x10000 {
+		return nil, fmt.Errorf("modbus: range %v:%v+%v is invalid", table, address, quantity)
+	}
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	return dev.load(table, address, quantity), nil
+}
+
+// Animate sets the tag of the register map to the values of the generator on every update of
+// Simulator.Start. Values out of range of the tag are skipped.
+func (dev *SimDevice) Animate(name string, gen Generator) error {
+	tag, err := dev.tag(name)
+	if err != nil {
+		return err
+	}
+	return dev.AnimateTag(tag, gen)
+}
+
+// AnimateTag animates the tag, which needs not be in the register map.
+// A previous animation of the tag is replaced.
+func (dev *SimDevice) AnimateTag(tag *Tag, gen Generator) error {
+	if err := tag.Validate(); err != nil {
+		return err
+	}
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	for i := range dev.anims {
+		if dev.anims[i].tag.Name == tag.Name {
+			dev.anims[i].gen = gen
+			return nil
+		}
+	}
+	dev.anims = append(dev.anims, simAnimation{tag: tag, gen: gen})
+	return nil
+}
+
+func (dev *SimDevice) tag(name string) (*Tag, error) {
+	if dev.Map != nil {
+		if tag, ok := dev.Map.Tag(name); ok {
+			return tag, nil
+		}
+	}
+	return nil, fmt.Errorf("modbus: unit '%v' has no tag '%v'", dev.Unit, name)
+}
+
+// update sets the animated tags to the values of their generators.
+func (dev *SimDevice) update(elapsed time.Duration) {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	for _, a := range dev.anims {
+		var value interface{} = a.gen.Value(elapsed)
+		if a.tag.Table.IsBit() || a.tag.Type == TypeBool {
+			value = value.(float64) != 0
+		}
+		raw, err := a.tag.EncodeWith(value, dev.env(a.tag))
+		if err != nil {
+			dev.logf("modbus: simulated unit '%v': %v\n", dev.Unit, err)
+			continue
+		}
+		dev.store(a.tag.Table, a.tag.Address, a.tag.Quantity(), raw)
+	}
+}
+
+// env returns the values of the tags referenced by the transforms of the tag.
+// Caller must hold the mutex.
+func (dev *SimDevice) env(tag *Tag) map[string]interface{} {
+	names := tag.Transforms.References()
+	if len(names) == 0 || dev.Map == nil {
+		return nil
+	}
+	env := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		if ref, ok := dev.Map.Tag(name); ok {
+			if value, err := ref.Decode(dev.load(ref.Table, ref.Address, ref.Quantity())); err == nil {
+				env[name] = value
+			}
+		}
+	}
+	return env
+}
+
+// load returns registers as big endian bytes or bits packed LSB first. Caller must hold the mutex.
+func (dev *SimDevice) load(table Table, address, quantity uint16) []byte {
+	values := make([]byte, rawSize(table, quantity))
+	if table.IsBit() {
+		bits := dev.bits[table-TableCoils]
+		for i := 0; i < int(quantity); i++ {
+			if bits[int(address)+i] {
+				values[i/8] |= 1 << uint(i%8)
+			}
+		}
+		return values
+	}
+	regs := dev.regs[table-TableHoldingRegisters]
+	for i := 0; i < int(quantity); i++ {
+		binary.BigEndian.PutUint16(values[i*2:], regs[int(address)+i])
+	}
+	return values
+}
+
+// store sets registers from big endian bytes or bits packed LSB first. Caller must hold the mutex.
+func (dev *SimDevice) store(table Table, address, quantity uint16, values []byte) {
+	if table.IsBit() {
+		bits := dev.bits[table-TableCoils]
+		for i := 0; i < int(quantity); i++ {
+			bits[int(address)+i] = values[i/8]&(1<<uint(i%8)) != 0
+		}
+		return
+	}
+	regs := dev.regs[table-TableHoldingRegisters]
+	for i := 0; i < int(quantity); i++ {
+		regs[int(address)+i] = binary.BigEndian.Uint16(values[i*2:])
+	}
+}
+
+// covered reports whether the range is served. Caller must hold the mutex.
+func (dev *SimDevice) covered(table Table, address, quantity uint16) bool {
+	if !dev.strict {
+		return true
+	}
+	defined := dev.defined[table-TableCoils]
+	for i := 0; i < int(quantity); i++ {
+		if !defined[int(address)+i] {
+			return false
+		}
+	}
+	return true
+}
+
+func rawSize(table Table, quantity uint16) int {
+	if table.IsBit() {
+		return (int(quantity) + 7) / 8
+	}
+	return int(quantity) * 2
+}
+
+// serve answers the request of FC 1-6, 15, 16, 22 and 23.
+func (dev *SimDevice) serve(pdu *ProtocolDataUnit) *ProtocolDataUnit {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	data := pdu.Data
+	if table, address, quantity, ok := virtualRead(pdu); ok {
+		if quantity == 0 || quantity > table.MaxRead() || int(address)+int(quantity) > 0x10000 {
+			return ExceptionResponse(pdu.FunctionCode, ExceptionCodeIllegalDataValue)
+		}
+		if !dev.covered(table, address, quantity) {
+			return ExceptionResponse(pdu.FunctionCode, ExceptionCodeIllegalDataAddress)
+		}
+		values := dev.load(table, address, quantity)
+		return &ProtocolDataUnit{FunctionCode: pdu.FunctionCode, Data: append([]byte{byte(len(values))}, values...)}
+	}
+	switch pdu.FunctionCode {
+	case FuncCodeMaskWriteRegister:
+		if len(data) != 6 {
+			return ExceptionResponse(pdu.FunctionCode, ExceptionCodeIllegalDataValue)
+		}
+		address := binary.BigEndian.Uint16(data)
+		if !dev.covered(TableHoldingRegisters, address, 1) {
+			return ExceptionResponse(pdu.FunctionCode, ExceptionCodeIllegalDataAddress)
+		}
+		andMask, orMask := binary.BigEndian.Uint16(data[2:]), binary.BigEndian.Uint16(data[4:])
+		regs := dev.regs[0]
+		regs[address] = regs[address]&andMask | orMask&^andMask
+		return &ProtocolDataUnit{FunctionCode: pdu.FunctionCode, Data: data}
+	case FuncCodeReadWriteMultipleRegisters:
+		if len(data) < 9 {
+			return ExceptionResponse(pdu.FunctionCode, ExceptionCodeIllegalDataValue)
+		}
+		readAddress, readQuantity := binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+		writeAddress, writeQuantity := binary.BigEndian.Uint16(data[4:]), binary.BigEndian.Uint16(data[6:])
+		if readQuantity == 0 || readQuantity > 125 || writeQuantity == 0 || writeQuantity > 121 ||
+			int(readAddress)+int(readQuantity) > 0x10000 || int(writeAddress)+int(writeQuantity) > 0x10000 ||
+			int(data[8]) != int(writeQuantity)*2 || len(data) != 9+int(data[8]) {
+			return ExceptionResponse(pdu.FunctionCode, ExceptionCodeIllegalDataValue)
+		}
+		if !dev.covered(TableHoldingRegisters, readAddress, readQuantity) || !dev.covered(TableHoldingRegisters, writeAddress, writeQuantity) {
+			return ExceptionResponse(pdu.FunctionCode, ExceptionCodeIllegalDataAddress)
+		}
+		// the write is performed before the read
+		dev.store(TableHoldingRegisters, writeAddress, writeQuantity, data[9:])
+		values := dev.load(TableHoldingRegisters, readAddress, readQuantity)
+		return &ProtocolDataUnit{FunctionCode: pdu.FunctionCode, Data: append([]byte{byte(len(values))}, values...)}
+	case FuncCodeWriteSingleCoil, FuncCodeWriteSingleRegister, FuncCodeWriteMultipleCoils, FuncCodeWriteMultipleRegisters:
+		table, address, quantity, values, ok := virtualWrite(pdu)
+		maxWrite := uint16(123)
+		if table.IsBit() {
+			maxWrite = 1968
+		}
+		if !ok || quantity == 0 || quantity > maxWrite || int(address)+int(quantity) > 0x10000 {
+			return ExceptionResponse(pdu.FunctionCode, ExceptionCodeIllegalDataValue)
+		}
+		if !dev.covered(table, address, quantity) {
+			return ExceptionResponse(pdu.FunctionCode, ExceptionCodeIllegalDataAddress)
+		}
+		dev.store(table, address, quantity, values)
+		return &ProtocolDataUnit{FunctionCode: pdu.FunctionCode, Data: data[:4]}
+	}
+	return ExceptionResponse(pdu.FunctionCode, ExceptionCodeIllegalFunction)
+}
+
+// Generator is the waveform of an animated tag, Value returns the value at the time elapsed
+// since Simulator.Start.
+type Generator interface {
+	Value(elapsed time.Duration) float64
+}
+
+// GeneratorFunc adapts the function to Generator.
+type GeneratorFunc func(elapsed time.Duration) float64
+
+func (f GeneratorFunc) Value(elapsed time.Duration) float64 {
+	return f(elapsed)
+}
+
+// Constant returns the value.
+func Constant(value float64) Generator {
+	return GeneratorFunc(func(time.Duration) float64 { return value })
+}
+
+// Ramp rises from from to to within the period and starts over, zero period rises once.
+func Ramp(from, to float64, period time.Duration) Generator {
+	return GeneratorFunc(func(elapsed time.Duration) float64 {
+		if period <= 0 {
+			return to
+		}
+		return from + (to-from)*float64(elapsed%period)/float64(period)
+	})
+}
+
+// Sine oscillates around offset by amplitude with the period.
+func Sine(offset, amplitude float64, period time.Duration) Generator {
+	return GeneratorFunc(func(elapsed time.Duration) float64 {
+		if period <= 0 {
+			return offset
+		}
+		return offset + amplitude*math.Sin(2*math.Pi*float64(elapsed%period)/float64(period))
+	})
+}
+
+// Counter counts from start by step every interval.
+func Counter(start, step float64, interval time.Duration) Generator {
+	return GeneratorFunc(func(elapsed time.Duration) float64 {
+		if interval <= 0 {
+			return start
+		}
+		return start + step*float64(elapsed/interval)
+	})
+}
+
+// RandomWalk changes the value from start by a random step of at most step on every update,
+// kept within min and max.
+func RandomWalk(start, step, min, max float64) Generator {
+	var mu sync.Mutex
+	value := start
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return GeneratorFunc(func(time.Duration) float64 {
+		mu.Lock()
+		defer mu.Unlock()
+		value += (rnd.Float64()*2 - 1) * step
+		value = math.Max(min, math.Min(max, value))
+		return value
+	})
+}
+
+// Playback replays recorded values, the value of a time is that of the last record not later.
+// It starts over after the last record if Loop is set, the last value is kept otherwise.
+type Playback struct {
+	Times  []time.Duration
+	Values []float64
+	Loop   bool
+}
+
+func (p *Playback) Value(elapsed time.Duration) float64 {
+	if len(p.Times) == 0 {
+		return 0
+	}
+	if last := p.Times[len(p.Times)-1]; p.Loop && last > 0 {
+		elapsed %= last
+	}
+	i := sort.Search(len(p.Times), func(i int) bool { return p.Times[i] > elapsed })
+	if i == 0 {
+		return p.Values[0]
+	}
+	return p.Values[i-1]
+}
+
+// LoadPlayback reads the column of the CSV file, see ParsePlayback.
+func LoadPlayback(path, column string) (*Playback, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParsePlayback(file, column)
+}
+
+// ParsePlayback reads records of the time in seconds from the start and values, e.g.
+//  time,voltage,current
+//  0,230.1,4.2
+//  0.5,229.8,4.4
+// The column is chosen by the header name, the first value column if empty.
+// Files without header have the value in the second column. Records must be in order of time.
+func ParsePlayback(r io.Reader, column string) (*Playback, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("modbus: playback: %w", err)
+	}
+	index := 1
+	if len(records) > 0 {
+		if _, err := strconv.ParseFloat(records[0][0], 64); err != nil {
+			header := records[0]
+			records = records[1:]
+			if column != "" {
+				index = -1
+				for i, name := range header {
+					if strings.EqualFold(strings.TrimSpace(name), column) {
+						index = i
+					}
+				}
+			}
+		} else if column != "" {
+			return nil, fmt.Errorf("modbus: playback has no header for column '%v'", column)
+		}
+	}
+	if index < 1 {
+		return nil, fmt.Errorf("modbus: playback has no column '%v'", column)
+	}
+	p := &Playback{Loop: true}
+	for i, record := range records {
+		if len(record) <= index {
+			return nil, fmt.Errorf("modbus: playback record '%v' has no column '%v'", i+1, index+1)
+		}
+		seconds, err := strconv.ParseFloat(record[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("modbus: playback record '%v': %w", i+1, err)
+		}
+		value, err := strconv.ParseFloat(record[index], 64)
+		if err != nil {
+			return nil, fmt.Errorf("modbus: playback record '%v': %w", i+1, err)
+		}
+		t := time.Duration(seconds * float64(time.Second))
+		if n := len(p.Times); n > 0 && t < p.Times[n-1] {
+			return nil, fmt.Errorf("modbus: playback record '%v' is earlier than the previous", i+1)
+		}
+		p.Times = append(p.Times, t)
+		p.Values = append(p.Values, value)
+	}
+	if len(p.Times) == 0 {
+		return nil, fmt.Errorf("modbus: playback has no records")
+	}
+	return p, nil
+}
+
+// ParseGenerator converts the generator spec to Generator:
+//  const=5
+//  ramp=0:100:10s        from, to, period
+//  sine=230:5:10s        offset, amplitude, period
+//  walk=50:0.5:0:100     start, step, min, max
+//  counter=0:1:1s        start, step, interval
+//  csv=trend.csv         file of ParsePlayback, csv=trend.csv:voltage for a column
+// Relative paths of csv files are relative to dir.
+func ParseGenerator(spec, dir string) (Generator, error) {
+	kind, args := spec, ""
+	if i := strings.IndexByte(spec, '='); i >= 0 {
+		kind, args = spec[:i], spec[i+1:]
+	}
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	if kind == "csv" {
+		path, column := args, ""
+		if i := strings.LastIndexByte(args, ':'); i >= 0 && !strings.ContainsAny(args[i:], `/\`) && i > 1 {
+			path, column = args[:i], args[i+1:]
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		return LoadPlayback(path, column)
+	}
+	fields := strings.Split(args, ":")
+	// number arguments and the duration argument, if any
+	arg := func(n int, duration bool) (numbers []float64, d time.Duration, err error) {
+		if len(fields) != n {
+			return nil, 0, fmt.Errorf("modbus: generator '%v' needs '%v' arguments", spec, n)
+		}
+		last := n
+		if duration {
+			last--
+			if d, err = time.ParseDuration(strings.TrimSpace(fields[last])); err != nil {
+				return nil, 0, fmt.Errorf("modbus: generator '%v': %w", spec, err)
+			}
+		}
+		for _, field := range fields[:last] {
+			v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil {
+				return nil, 0, fmt.Errorf("modbus: generator '%v': %w", spec, err)
+			}
+			numbers = append(numbers, v)
+		}
+		return
+	}
+	switch kind {
+	case "const", "constant":
+		v, _, err := arg(1, false)
+		if err != nil {
+			return nil, err
+		}
+		return Constant(v[0]), nil
+	case "ramp":
+		v, d, err := arg(3, true)
+		if err != nil {
+			return nil, err
+		}
+		return Ramp(v[0], v[1], d), nil
+	case "sine", "sin":
+		v, d, err := arg(3, true)
+		if err != nil {
+			return nil, err
+		}
+		return Sine(v[0], v[1], d), nil
+	case "walk", "randomwalk", "random_walk":
+		v, _, err := arg(4, false)
+		if err != nil {
+			return nil, err
+		}
+		if v[2] > v[3] {
+			return nil, fmt.Errorf("modbus: generator '%v' min is greater than max", spec)
+		}
+		return RandomWalk(v[0], v[1], v[2], v[3]), nil
+	case "counter":
+		v, d, err := arg(3, true)
+		if err != nil {
+			return nil, err
+		}
+		return Counter(v[0], v[1], d), nil
+	}
+	return nil, fmt.Errorf("modbus: unknown generator '%v'", spec)
+}
+
+// SimulatorConfig is the configuration file of the simulated devices, e.g. in YAML:
+//  devices:
+//    - unit: 1
+//      map: meter.csv
+//      strict: true
+//      values:
+//        setpoint: 21.5
+//      generators:
+//        voltage: sine=230:5:10s
+//        energy: counter=0:1:1s
+type SimulatorConfig struct {
+	Devices []SimDeviceConfig `json:"devices" yaml:"devices"`
+}
+
+// SimDeviceConfig is a simulated device of SimulatorConfig, the map and csv files are
+// relative to the configuration file.
+type SimDeviceConfig struct {
+	Unit       byte                   `json:"unit" yaml:"unit"`
+	Map        string                 `json:"map,omitempty" yaml:"map,omitempty"`
+	Strict     bool                   `json:"strict,omitempty" yaml:"strict,omitempty"`
+	Values     map[string]interface{} `json:"values,omitempty" yaml:"values,omitempty"`
+	Generators map[string]string      `json:"generators,omitempty" yaml:"generators,omitempty"`
+}
+
+// LoadSimulator reads the configuration file (.json, .yaml, .yml) and returns the simulator
+// of its devices, not started.
+func LoadSimulator(path string) (*Simulator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config SimulatorConfig
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".yaml", ".yml":
+		// JSON is read as YAML, numbers of values are kept as int or float64
+		err = yaml.Unmarshal(data, &config)
+	default:
+		return nil, fmt.Errorf("modbus: unknown simulator config format '%v'", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("modbus: simulator config: %w", err)
+	}
+	return NewSimulatorConfig(&config, filepath.Dir(path))
+}
+
+// NewSimulatorConfig returns the simulator of the configured devices, relative paths are
+// relative to dir.
+func NewSimulatorConfig(config *SimulatorConfig, dir string) (*Simulator, error) {
+	sim := NewSimulator()
+	for _, dc := range config.Devices {
+		var rm *RegisterMap
+		if dc.Map != "" {
+			path := dc.Map
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			var err error
+			if rm, err = LoadRegisterMap(path); err != nil {
+				return nil, fmt.Errorf("modbus: simulated unit '%v': %w", dc.Unit, err)
+			}
+		}
+		dev, err := sim.Add(dc.Unit, rm)
+		if err != nil {
+			return nil, err
+		}
+		dev.SetStrict(dc.Strict)
+		for name, value := range dc.Values {
+			if err = dev.Set(name, value); err != nil {
+				return nil, fmt.Errorf("modbus: simulated unit '%v': %w", dc.Unit, err)
+			}
+		}
+		for name, spec := range dc.Generators {
+			gen, err := ParseGenerator(spec, dir)
+			if err == nil {
+				err = dev.Animate(name, gen)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("modbus: simulated unit '%v': %w", dc.Unit, err)
+			}
+		}
+	}
+	return sim, nil
+}
@@ -0,0 +1,106 @@
+package modbus
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+const simulatorCSV = `name,table,address,type,access,enum,min,max
+mode,holding,0,uint16,rw,off=0;on=1;auto=2,,
+setpoint,holding,1,int16,rw,,-50,50
+power,input,0,float32,r,,,
+`
+
+// testSimulator returns the simulator of a device at unit 1 in strict mode.
+func testSimulator(t *testing.T) (*Simulator, *SimDevice) {
+	t.Helper()
+	rm, err := ParseRegisterMapCSV(strings.NewReader(simulatorCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := NewSimulator()
+	dev, err := sim.Add(1, rm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev.SetStrict(true)
+	return sim, dev
+}
+
+// exerciseSimulator reads, writes and animates the tags of the simulated device through the transporter.
+func exerciseSimulator(t *testing.T, name string, sim *Simulator, dev *SimDevice, mbt *MBTransporter, mode string) {
+	t.Helper()
+	mbc := NewSClient(1, mode)
+	rm := dev.Map
+
+	if err := dev.Set("setpoint", -7); err != nil {
+		t.Fatal(err)
+	}
+	values, warn, err := rm.Read(mbc, mbt, "setpoint")
+	if warn != nil || err != nil || values["setpoint"] != int64(-7) {
+		t.Errorf("%v: read %v (%T), %v %v", name, values["setpoint"], values["setpoint"], warn, err)
+	}
+	if warn, err = rm.WriteTag(mbc, mbt, "mode", "auto"); warn != nil || err != nil {
+		t.Errorf("%v: write %v %v", name, warn, err)
+	}
+	if mode, err := dev.Get("mode"); err != nil || mode != uint64(2) {
+		t.Errorf("%v: device mode %v (%T) %v", name, mode, mode, err)
+	}
+	// not covered by the register map
+	if _, warn, err = mbc.ReadValues(mbt, TableHoldingRegisters, 10, 1); err != nil || exceptionOf(warn) != ExceptionCodeIllegalDataAddress {
+		t.Errorf("%v: read of undefined address %v %v", name, warn, err)
+	}
+
+	if err = dev.Animate("power", Constant(42.5)); err != nil {
+		t.Fatal(err)
+	}
+	sim.Start(10 * time.Millisecond)
+	defer sim.Stop()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(20 * time.Millisecond) {
+		values, warn, err = rm.Read(mbc, mbt, "power")
+		if warn != nil || err != nil {
+			t.Fatalf("%v: read %v %v", name, warn, err)
+		}
+		if power, ok := values["power"].(float64); ok && power == 42.5 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%v: animated power %v (%T)", name, values["power"], values["power"])
+		}
+	}
+}
+
+func TestSimulatorTCP(t *testing.T) {
+	sim, dev := testSimulator(t)
+	exerciseSimulator(t, "tcp", sim, dev, serveLoopback(t, sim), "tcp")
+}
+
+func TestSimulatorPty(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("pty is supported on linux only")
+	}
+	pty, err := OpenPty()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer pty.Close()
+	sim, dev := testSimulator(t)
+	srv := NewSerialServer(sim)
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.ServePort("rtu", FixedPort(pty.Master), 19200, 8, "E", 1)
+	}()
+	mbt := NewTransporter()
+	if err = mbt.Connect("rtu", pty.Path, 19200, 8, "E", 1, 500, 0); err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	exerciseSimulator(t, "rtu pty", sim, dev, mbt, "rtu")
+	mbt.Close()
+	srv.Close()
+	if err = <-served; err != nil {
+		t.Errorf("serve: %v", err)
+	}
+}
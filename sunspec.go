@@ -0,0 +1,615 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// sunspecMarker is the "SunS" identifier at the base address of SunSpec devices.
+const sunspecMarker = 0x53756e53
+
+// sunspecEndID is the model id of the end marker of the model chain.
+const sunspecEndID = 0xFFFF
+
+// SunSpecBases are the base addresses probed for the SunSpec marker, in order.
+var SunSpecBases = []uint16{40000, 50000, 0}
+
+// SunSpecType is the SunSpec type of a point.
+type SunSpecType string
+
+const (
+	SunSpecInt16      SunSpecType = "int16"
+	SunSpecUint16     SunSpecType = "uint16"
+	SunSpecCount      SunSpecType = "count"
+	SunSpecAcc16      SunSpecType = "acc16"
+	SunSpecEnum16     SunSpecType = "enum16"
+	SunSpecBitfield16 SunSpecType = "bitfield16"
+	SunSpecPad        SunSpecType = "pad"
+	SunSpecSunssf     SunSpecType = "sunssf"
+	SunSpecInt32      SunSpecType = "int32"
+	SunSpecUint32     SunSpecType = "uint32"
+	SunSpecAcc32      SunSpecType = "acc32"
+	SunSpecEnum32     SunSpecType = "enum32"
+	SunSpecBitfield32 SunSpecType = "bitfield32"
+	SunSpecInt64      SunSpecType = "int64"
+	SunSpecUint64     SunSpecType = "uint64"
+	SunSpecAcc64      SunSpecType = "acc64"
+	SunSpecFloat32    SunSpecType = "float32"
+	SunSpecString     SunSpecType = "string"
+)
+
+// Registers returns count of registers of the type, zero for strings of any length.
+func (st SunSpecType) Registers() uint16 {
+	switch st {
+	case SunSpecInt32, SunSpecUint32, SunSpecAcc32, SunSpecEnum32, SunSpecBitfield32, SunSpecFloat32:
+		return 2
+	case SunSpecInt64, SunSpecUint64, SunSpecAcc64:
+		return 4
+	case SunSpecString:
+		return 0
+	}
+	return 1
+}
+
+// SunSpecPoint describes a point of a SunSpec model, offset is relative to the model data.
+type SunSpecPoint struct {
+	Name   string
+	Type   SunSpecType
+	Offset uint16
+	Size   uint16
+	Units  string
+	Scale  string // name of the scale factor point
+}
+
+// SunSpecModelDef describes the fixed block and the optional repeating block of a model.
+type SunSpecModelDef struct {
+	ID     uint16
+	Name   string
+	Fixed  []SunSpecPoint
+	Repeat []SunSpecPoint
+}
+
+// FixedSize returns count of registers of the fixed block.
+func (md *SunSpecModelDef) FixedSize() uint16 { return sunspecSize(md.Fixed) }
+
+// RepeatSize returns count of registers of the repeating block, zero if there is none.
+func (md *SunSpecModelDef) RepeatSize() uint16 { return sunspecSize(md.Repeat) }
+
+func sunspecSize(points []SunSpecPoint) uint16 {
+	if len(points) == 0 {
+		return 0
+	}
+	last := points[len(points)-1]
+	return last.Offset + last.Size
+}
+
+// SunSpecHeader is an entry of the model chain, address is the first register of the model data.
+type SunSpecHeader struct {
+	ID      uint16
+	Length  uint16
+	Address uint16
+}
+
+// SunSpecValue is the decoded point.
+// Raw is int64, uint64, float64 or string; Value is Raw with the scale factor applied (float64).
+// Points with the "not implemented" sentinel or an unimplemented scale factor have Implemented false.
+type SunSpecValue struct {
+	SunSpecPoint
+	Raw         interface{}
+	Value       interface{}
+	Implemented bool
+}
+
+// SunSpecGroup is the decoded fixed or repeating block of a model.
+type SunSpecGroup struct {
+	Points []*SunSpecValue
+	index  map[string]*SunSpecValue
+}
+
+// Point returns the value of the point by name.
+func (g *SunSpecGroup) Point(name string) (*SunSpecValue, bool) {
+	v, ok := g.index[name]
+	return v, ok
+}
+
+// Float returns the implemented numeric point as float64.
+func (g *SunSpecGroup) Float(name string) (float64, bool) {
+	v, ok := g.index[name]
+	if !ok || !v.Implemented {
+		return 0, false
+	}
+	f, err := toFloat64(v.Value)
+	return f, err == nil
+}
+
+// String returns the implemented string point.
+func (g *SunSpecGroup) String(name string) (string, bool) {
+	v, ok := g.index[name]
+	if !ok || !v.Implemented {
+		return "", false
+	}
+	s, ok := v.Value.(string)
+	return s, ok
+}
+
+// SunSpecModel is the decoded model. Unknown models have only Raw data.
+type SunSpecModel struct {
+	SunSpecHeader
+	Name  string
+	Known bool
+	SunSpecGroup
+	Repeats []*SunSpecGroup
+	Raw     []byte
+}
+
+// SunSpecDevice is the tree of models of a device.
+type SunSpecDevice struct {
+	Base   uint16
+	Models []*SunSpecModel
+}
+
+// Model returns the first model with the id.
+func (d *SunSpecDevice) Model(id uint16) (*SunSpecModel, bool) {
+	for _, m := range d.Models {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+// SunSpecClient discovers and reads SunSpec models of a device.
+type SunSpecClient struct {
+	Client      *MBClient
+	Transporter ApiSender
+	// Base is the located base address, valid after Locate.
+	Base    uint16
+	located bool
+}
+
+func NewSunSpecClient(mbc *MBClient, mbt ApiSender) *SunSpecClient {
+	return &SunSpecClient{Client: mbc, Transporter: mbt}
+}
+
+// Locate finds the base address with the "SunS" marker, probing SunSpecBases.
+// Warning is returned if no base address has the marker.
+func (sc *SunSpecClient) Locate() (base uint16, warn, err error) {
+	for _, base = range SunSpecBases {
+		values, w, e := sc.Client.ReadValues(sc.Transporter, TableHoldingRegisters, base, 2)
+		if e != nil {
+			err = e
+			return
+		}
+		if w == nil && binary.BigEndian.Uint32(values) == sunspecMarker {
+			sc.Base, sc.located = base, true
+			return
+		}
+	}
+	base, warn = 0, fmt.Errorf("modbus: sunspec marker not found at %v", SunSpecBases)
+	return
+}
+
+// Scan walks the model chain from the base address to the end marker.
+func (sc *SunSpecClient) Scan() (headers []SunSpecHeader, warn, err error) {
+	if !sc.located {
+		if _, warn, err = sc.Locate(); err != nil || warn != nil {
+			return
+		}
+	}
+	address := int(sc.Base) + 2
+	for address+2 <= 0x10000 {
+		values, w, e := sc.Client.ReadValues(sc.Transporter, TableHoldingRegisters, uint16(address), 2)
+		if e != nil || w != nil {
+			warn, err = w, e
+			return
+		}
+		h := SunSpecHeader{
+			ID:      binary.BigEndian.Uint16(values),
+			Length:  binary.BigEndian.Uint16(values[2:]),
+			Address: uint16(address + 2),
+		}
+		if h.ID == sunspecEndID {
+			return
+		}
+		if address+2+int(h.Length) > 0x10000 {
+			warn = fmt.Errorf("modbus: sunspec model '%v' at '%v' exceeds address space", h.ID, address)
+			return
+		}
+		headers = append(headers, h)
+		address += 2 + int(h.Length)
+	}
+	warn = fmt.Errorf("modbus: sunspec end marker not found")
+	return
+}
+
+// Read scans the model chain and reads all models.
+func (sc *SunSpecClient) Read() (device *SunSpecDevice, warn, err error) {
+	headers, warn, err := sc.Scan()
+	if err != nil || warn != nil {
+		return
+	}
+	device = &SunSpecDevice{Base: sc.Base}
+	for _, h := range headers {
+		m, w, e := sc.ReadModel(h)
+		if e != nil || w != nil {
+			warn, err = w, e
+			return
+		}
+		device.Models = append(device.Models, m)
+	}
+	return
+}
+
+// ReadModel reads the registers of the model and decodes them.
+func (sc *SunSpecClient) ReadModel(h SunSpecHeader) (m *SunSpecModel, warn, err error) {
+	var raw []byte
+	limit := int(TableHoldingRegisters.MaxRead())
+	for offset := 0; offset < int(h.Length); offset += limit {
+		quantity := int(h.Length) - offset
+		if quantity > limit {
+			quantity = limit
+		}
+		values, w, e := sc.Client.ReadValues(sc.Transporter, TableHoldingRegisters, h.Address+uint16(offset), uint16(quantity))
+		if e != nil || w != nil {
+			warn, err = w, e
+			return
+		}
+		raw = append(raw, values...)
+	}
+	m, err = DecodeSunSpecModel(h, raw)
+	return
+}
+
+// DecodeSunSpecModel decodes the model data by the known definition.
+// Repeating blocks are counted from the model length, points beyond the length are left out.
+func DecodeSunSpecModel(h SunSpecHeader, raw []byte) (*SunSpecModel, error) {
+	if len(raw) != int(h.Length)*2 {
+		return nil, fmt.Errorf("modbus: sunspec model '%v' needs '%v' bytes, got '%v'", h.ID, int(h.Length)*2, len(raw))
+	}
+	m := &SunSpecModel{SunSpecHeader: h, Raw: raw}
+	md, ok := sunspecModels[h.ID]
+	if !ok {
+		return m, nil
+	}
+	m.Name, m.Known = md.Name, true
+	fixed := md.FixedSize()
+	if fixed > h.Length {
+		fixed = h.Length
+	}
+	m.SunSpecGroup = decodeSunSpecGroup(md.Fixed, raw[:int(fixed)*2], nil)
+	if size := md.RepeatSize(); size > 0 {
+		for offset := int(fixed) * 2; offset+int(size)*2 <= len(raw); offset += int(size) * 2 {
+			g := decodeSunSpecGroup(md.Repeat, raw[offset:offset+int(size)*2], &m.SunSpecGroup)
+			m.Repeats = append(m.Repeats, &g)
+		}
+	}
+	return m, nil
+}
+
+// decodeSunSpecGroup decodes the points of the block, scale factors are looked up
+// in the block first and in the parent (fixed) block then.
+func decodeSunSpecGroup(points []SunSpecPoint, raw []byte, parent *SunSpecGroup) SunSpecGroup {
+	g := SunSpecGroup{index: map[string]*SunSpecValue{}}
+	for _, p := range points {
+		end := int(p.Offset+p.Size) * 2
+		if end > len(raw) {
+			break
+		}
+		v := &SunSpecValue{SunSpecPoint: p}
+		v.Raw, v.Implemented = decodeSunSpecPoint(p.Type, raw[int(p.Offset)*2:end])
+		v.Value = v.Raw
+		g.Points = append(g.Points, v)
+		g.index[p.Name] = v
+	}
+	for _, v := range g.Points {
+		if v.Scale == "" || !v.Implemented {
+			continue
+		}
+		sf, ok := g.index[v.Scale]
+		if !ok && parent != nil {
+			sf, ok = parent.index[v.Scale]
+		}
+		if !ok || !sf.Implemented {
+			v.Implemented = false
+			continue
+		}
+		f, _ := toFloat64(v.Raw)
+		exp, _ := toInt64(sf.Raw)
+		v.Value = f * math.Pow10(int(exp))
+	}
+	return g
+}
+
+// decodeSunSpecPoint decodes the registers of the point and checks the "not implemented" sentinel.
+func decodeSunSpecPoint(st SunSpecType, b []byte) (value interface{}, implemented bool) {
+	switch st {
+	case SunSpecInt16, SunSpecSunssf:
+		v := int16(binary.BigEndian.Uint16(b))
+		return int64(v), v != math.MinInt16
+	case SunSpecUint16, SunSpecCount, SunSpecEnum16, SunSpecBitfield16:
+		v := binary.BigEndian.Uint16(b)
+		return uint64(v), v != math.MaxUint16
+	case SunSpecAcc16:
+		v := binary.BigEndian.Uint16(b)
+		return uint64(v), v != 0
+	case SunSpecPad:
+		return uint64(binary.BigEndian.Uint16(b)), false
+	case SunSpecInt32:
+		v := int32(binary.BigEndian.Uint32(b))
+		return int64(v), v != math.MinInt32
+	case SunSpecUint32, SunSpecEnum32, SunSpecBitfield32:
+		v := binary.BigEndian.Uint32(b)
+		return uint64(v), v != math.MaxUint32
+	case SunSpecAcc32:
+		v := binary.BigEndian.Uint32(b)
+		return uint64(v), v != 0
+	case SunSpecInt64:
+		v := int64(binary.BigEndian.Uint64(b))
+		return v, v != math.MinInt64
+	case SunSpecUint64, SunSpecAcc64:
+		v := binary.BigEndian.Uint64(b)
+		return v, v != 0
+	case SunSpecFloat32:
+		v := math.Float32frombits(binary.BigEndian.Uint32(b))
+		return float64(v), !math.IsNaN(float64(v))
+	case SunSpecString:
+		v := strings.TrimRight(string(b), "\x00 ")
+		return v, v != ""
+	}
+	return nil, false
+}
+
+// sunspecModels are the known model definitions by id.
+var sunspecModels = map[uint16]*SunSpecModelDef{}
+
+// RegisterSunSpecModel adds or replaces the model definition used for decoding.
+// Offsets and sizes of points are computed from their order and types, strings need Size.
+func RegisterSunSpecModel(md *SunSpecModelDef) {
+	sunspecLayout(md.Fixed)
+	sunspecLayout(md.Repeat)
+	sunspecModels[md.ID] = md
+}
+
+// SunSpecModelDefOf returns the known model definition.
+func SunSpecModelDefOf(id uint16) (*SunSpecModelDef, bool) {
+	md, ok := sunspecModels[id]
+	return md, ok
+}
+
+func sunspecLayout(points []SunSpecPoint) {
+	offset := uint16(0)
+	for n := range points {
+		if size := points[n].Type.Registers(); size > 0 {
+			points[n].Size = size
+		}
+		points[n].Offset = offset
+		offset += points[n].Size
+	}
+}
+
+// ssp is a short constructor of points for model definitions.
+func ssp(name string, st SunSpecType, units, scale string) SunSpecPoint {
+	return SunSpecPoint{Name: name, Type: st, Units: units, Scale: scale}
+}
+
+// sss is a short constructor of string points.
+func sss(name string, size uint16) SunSpecPoint {
+	return SunSpecPoint{Name: name, Type: SunSpecString, Size: size}
+}
+
+// sssf is a short constructor of scale factor points.
+func sssf(name string) SunSpecPoint {
+	return SunSpecPoint{Name: name, Type: SunSpecSunssf}
+}
+
+func init() {
+	RegisterSunSpecModel(&SunSpecModelDef{ID: 1, Name: "common", Fixed: []SunSpecPoint{
+		sss("Mn", 16), sss("Md", 16), sss("Opt", 8), sss("Vr", 8), sss("SN", 16),
+		ssp("DA", SunSpecUint16, "", ""),
+		ssp("Pad", SunSpecPad, "", ""),
+	}})
+	for id, name := range map[uint16]string{101: "inverter_1p", 102: "inverter_sp", 103: "inverter_3p"} {
+		RegisterSunSpecModel(&SunSpecModelDef{ID: id, Name: name, Fixed: sunspecInverter()})
+	}
+	for id, name := range map[uint16]string{111: "inverter_1p_float", 112: "inverter_sp_float", 113: "inverter_3p_float"} {
+		RegisterSunSpecModel(&SunSpecModelDef{ID: id, Name: name, Fixed: sunspecInverterFloat()})
+	}
+	RegisterSunSpecModel(&SunSpecModelDef{ID: 120, Name: "nameplate", Fixed: []SunSpecPoint{
+		ssp("DERTyp", SunSpecEnum16, "", ""),
+		ssp("WRtg", SunSpecUint16, "W", "WRtg_SF"), sssf("WRtg_SF"),
+		ssp("VARtg", SunSpecUint16, "VA", "VARtg_SF"), sssf("VARtg_SF"),
+		ssp("VArRtgQ1", SunSpecInt16, "var", "VArRtg_SF"), ssp("VArRtgQ2", SunSpecInt16, "var", "VArRtg_SF"),
+		ssp("VArRtgQ3", SunSpecInt16, "var", "VArRtg_SF"), ssp("VArRtgQ4", SunSpecInt16, "var", "VArRtg_SF"), sssf("VArRtg_SF"),
+		ssp("ARtg", SunSpecUint16, "A", "ARtg_SF"), sssf("ARtg_SF"),
+		ssp("PFRtgQ1", SunSpecInt16, "cos", "PFRtg_SF"), ssp("PFRtgQ2", SunSpecInt16, "cos", "PFRtg_SF"),
+		ssp("PFRtgQ3", SunSpecInt16, "cos", "PFRtg_SF"), ssp("PFRtgQ4", SunSpecInt16, "cos", "PFRtg_SF"), sssf("PFRtg_SF"),
+		ssp("WHRtg", SunSpecUint16, "Wh", "WHRtg_SF"), sssf("WHRtg_SF"),
+		ssp("AhrRtg", SunSpecUint16, "AH", "AhrRtg_SF"), sssf("AhrRtg_SF"),
+		ssp("MaxChaRte", SunSpecUint16, "W", "MaxChaRte_SF"), sssf("MaxChaRte_SF"),
+		ssp("MaxDisChaRte", SunSpecUint16, "W", "MaxDisChaRte_SF"), sssf("MaxDisChaRte_SF"),
+		ssp("Pad", SunSpecPad, "", ""),
+	}})
+	RegisterSunSpecModel(&SunSpecModelDef{ID: 121, Name: "settings", Fixed: []SunSpecPoint{
+		ssp("WMax", SunSpecUint16, "W", "WMax_SF"),
+		ssp("VRef", SunSpecUint16, "V", "VRef_SF"),
+		ssp("VRefOfs", SunSpecInt16, "V", "VRefOfs_SF"),
+		ssp("VMax", SunSpecUint16, "V", "VMinMax_SF"),
+		ssp("VMin", SunSpecUint16, "V", "VMinMax_SF"),
+		ssp("VAMax", SunSpecUint16, "VA", "VAMax_SF"),
+		ssp("VArMaxQ1", SunSpecInt16, "var", "VArMax_SF"), ssp("VArMaxQ2", SunSpecInt16, "var", "VArMax_SF"),
+		ssp("VArMaxQ3", SunSpecInt16, "var", "VArMax_SF"), ssp("VArMaxQ4", SunSpecInt16, "var", "VArMax_SF"),
+		ssp("WGra", SunSpecUint16, "% WMax/sec", "WGra_SF"),
+		ssp("PFMinQ1", SunSpecInt16, "cos", "PFMin_SF"), ssp("PFMinQ2", SunSpecInt16, "cos", "PFMin_SF"),
+		ssp("PFMinQ3", SunSpecInt16, "cos", "PFMin_SF"), ssp("PFMinQ4", SunSpecInt16, "cos", "PFMin_SF"),
+		ssp("VArAct", SunSpecEnum16, "", ""),
+		ssp("ClcTotVA", SunSpecEnum16, "", ""),
+		ssp("MaxRmpRte", SunSpecUint16, "% WGra", "MaxRmpRte_SF"),
+		ssp("ECPNomHz", SunSpecUint16, "Hz", "ECPNomHz_SF"),
+		ssp("ConnPh", SunSpecEnum16, "", ""),
+		sssf("WMax_SF"), sssf("VRef_SF"), sssf("VRefOfs_SF"), sssf("VMinMax_SF"), sssf("VAMax_SF"),
+		sssf("VArMax_SF"), sssf("WGra_SF"), sssf("PFMin_SF"), sssf("MaxRmpRte_SF"), sssf("ECPNomHz_SF"),
+	}})
+	RegisterSunSpecModel(&SunSpecModelDef{ID: 122, Name: "status", Fixed: []SunSpecPoint{
+		ssp("PVConn", SunSpecBitfield16, "", ""),
+		ssp("StorConn", SunSpecBitfield16, "", ""),
+		ssp("ECPConn", SunSpecBitfield16, "", ""),
+		ssp("ActWh", SunSpecAcc64, "Wh", ""),
+		ssp("ActVAh", SunSpecAcc64, "VAh", ""),
+		ssp("ActVArhQ1", SunSpecAcc64, "varh", ""), ssp("ActVArhQ2", SunSpecAcc64, "varh", ""),
+		ssp("ActVArhQ3", SunSpecAcc64, "varh", ""), ssp("ActVArhQ4", SunSpecAcc64, "varh", ""),
+		ssp("VArAval", SunSpecInt16, "var", "VArAval_SF"), sssf("VArAval_SF"),
+		ssp("WAval", SunSpecUint16, "W", "WAval_SF"), sssf("WAval_SF"),
+		ssp("StSetLimMsk", SunSpecBitfield32, "", ""),
+		ssp("StActCtl", SunSpecBitfield32, "", ""),
+		sss("TmSrc", 4),
+		ssp("Tms", SunSpecUint32, "Secs", ""),
+		ssp("RtSt", SunSpecBitfield16, "", ""),
+		ssp("Ris", SunSpecUint16, "ohms", "Ris_SF"), sssf("Ris_SF"),
+	}})
+	RegisterSunSpecModel(&SunSpecModelDef{ID: 123, Name: "controls", Fixed: []SunSpecPoint{
+		ssp("Conn_WinTms", SunSpecUint16, "Secs", ""),
+		ssp("Conn_RvrtTms", SunSpecUint16, "Secs", ""),
+		ssp("Conn", SunSpecEnum16, "", ""),
+		ssp("WMaxLimPct", SunSpecUint16, "% WMax", "WMaxLimPct_SF"),
+		ssp("WMaxLimPct_WinTms", SunSpecUint16, "Secs", ""),
+		ssp("WMaxLimPct_RvrtTms", SunSpecUint16, "Secs", ""),
+		ssp("WMaxLimPct_RmpTms", SunSpecUint16, "Secs", ""),
+		ssp("WMaxLim_Ena", SunSpecEnum16, "", ""),
+		ssp("OutPFSet", SunSpecInt16, "cos", "OutPFSet_SF"),
+		ssp("OutPFSet_WinTms", SunSpecUint16, "Secs", ""),
+		ssp("OutPFSet_RvrtTms", SunSpecUint16, "Secs", ""),
+		ssp("OutPFSet_RmpTms", SunSpecUint16, "Secs", ""),
+		ssp("OutPFSet_Ena", SunSpecEnum16, "", ""),
+		ssp("VArWMaxPct", SunSpecInt16, "% WMax", "VArPct_SF"),
+		ssp("VArMaxPct", SunSpecInt16, "% VArMax", "VArPct_SF"),
+		ssp("VArAvalPct", SunSpecInt16, "% VArAval", "VArPct_SF"),
+		ssp("VArPct_WinTms", SunSpecUint16, "Secs", ""),
+		ssp("VArPct_RvrtTms", SunSpecUint16, "Secs", ""),
+		ssp("VArPct_RmpTms", SunSpecUint16, "Secs", ""),
+		ssp("VArPct_Mod", SunSpecEnum16, "", ""),
+		ssp("VArPct_Ena", SunSpecEnum16, "", ""),
+		sssf("WMaxLimPct_SF"), sssf("OutPFSet_SF"), sssf("VArPct_SF"),
+	}})
+	RegisterSunSpecModel(&SunSpecModelDef{ID: 124, Name: "storage", Fixed: []SunSpecPoint{
+		ssp("WChaMax", SunSpecUint16, "W", "WChaMax_SF"),
+		ssp("WChaGra", SunSpecUint16, "% WChaMax/sec", "WChaDisChaGra_SF"),
+		ssp("WDisChaGra", SunSpecUint16, "% WChaMax/sec", "WChaDisChaGra_SF"),
+		ssp("StorCtl_Mod", SunSpecBitfield16, "", ""),
+		ssp("VAChaMax", SunSpecUint16, "VA", "VAChaMax_SF"),
+		ssp("MinRsvPct", SunSpecUint16, "% WChaMax", "MinRsvPct_SF"),
+		ssp("ChaState", SunSpecUint16, "% AhrRtg", "ChaState_SF"),
+		ssp("StorAval", SunSpecUint16, "AH", "StorAval_SF"),
+		ssp("InBatV", SunSpecUint16, "V", "InBatV_SF"),
+		ssp("ChaSt", SunSpecEnum16, "", ""),
+		ssp("OutWRte", SunSpecInt16, "% WDisChaMax", "InOutWRte_SF"),
+		ssp("InWRte", SunSpecInt16, "% WChaMax", "InOutWRte_SF"),
+		ssp("InOutWRte_WinTms", SunSpecUint16, "Secs", ""),
+		ssp("InOutWRte_RvrtTms", SunSpecUint16, "Secs", ""),
+		ssp("InOutWRte_RmpTms", SunSpecUint16, "Secs", ""),
+		ssp("ChaGriSet", SunSpecEnum16, "", ""),
+		sssf("WChaMax_SF"), sssf("WChaDisChaGra_SF"), sssf("VAChaMax_SF"), sssf("MinRsvPct_SF"),
+		sssf("ChaState_SF"), sssf("StorAval_SF"), sssf("InBatV_SF"), sssf("InOutWRte_SF"),
+	}})
+	RegisterSunSpecModel(&SunSpecModelDef{ID: 160, Name: "mppt", Fixed: []SunSpecPoint{
+		sssf("DCA_SF"), sssf("DCV_SF"), sssf("DCW_SF"), sssf("DCWH_SF"),
+		ssp("Evt", SunSpecBitfield32, "", ""),
+		ssp("N", SunSpecCount, "", ""),
+		ssp("TmsPer", SunSpecUint16, "", ""),
+	}, Repeat: []SunSpecPoint{
+		ssp("ID", SunSpecUint16, "", ""),
+		sss("IDStr", 8),
+		ssp("DCA", SunSpecUint16, "A", "DCA_SF"),
+		ssp("DCV", SunSpecUint16, "V", "DCV_SF"),
+		ssp("DCW", SunSpecUint16, "W", "DCW_SF"),
+		ssp("DCWH", SunSpecAcc32, "Wh", "DCWH_SF"),
+		ssp("Tms", SunSpecUint32, "Secs", ""),
+		ssp("Tmp", SunSpecInt16, "C", ""),
+		ssp("DCSt", SunSpecEnum16, "", ""),
+		ssp("DCEvt", SunSpecBitfield32, "", ""),
+	}})
+	for id, name := range map[uint16]string{201: "ac_meter_1p", 202: "ac_meter_sp", 203: "ac_meter_3p_wye", 204: "ac_meter_3p_delta"} {
+		RegisterSunSpecModel(&SunSpecModelDef{ID: id, Name: name, Fixed: sunspecMeter()})
+	}
+}
+
+// sunspecInverter returns the points of integer inverter models 101-103.
+func sunspecInverter() []SunSpecPoint {
+	return []SunSpecPoint{
+		ssp("A", SunSpecUint16, "A", "A_SF"),
+		ssp("AphA", SunSpecUint16, "A", "A_SF"), ssp("AphB", SunSpecUint16, "A", "A_SF"), ssp("AphC", SunSpecUint16, "A", "A_SF"),
+		sssf("A_SF"),
+		ssp("PPVphAB", SunSpecUint16, "V", "V_SF"), ssp("PPVphBC", SunSpecUint16, "V", "V_SF"), ssp("PPVphCA", SunSpecUint16, "V", "V_SF"),
+		ssp("PhVphA", SunSpecUint16, "V", "V_SF"), ssp("PhVphB", SunSpecUint16, "V", "V_SF"), ssp("PhVphC", SunSpecUint16, "V", "V_SF"),
+		sssf("V_SF"),
+		ssp("W", SunSpecInt16, "W", "W_SF"), sssf("W_SF"),
+		ssp("Hz", SunSpecUint16, "Hz", "Hz_SF"), sssf("Hz_SF"),
+		ssp("VA", SunSpecInt16, "VA", "VA_SF"), sssf("VA_SF"),
+		ssp("VAr", SunSpecInt16, "var", "VAr_SF"), sssf("VAr_SF"),
+		ssp("PF", SunSpecInt16, "Pct", "PF_SF"), sssf("PF_SF"),
+		ssp("WH", SunSpecAcc32, "Wh", "WH_SF"), sssf("WH_SF"),
+		ssp("DCA", SunSpecUint16, "A", "DCA_SF"), sssf("DCA_SF"),
+		ssp("DCV", SunSpecUint16, "V", "DCV_SF"), sssf("DCV_SF"),
+		ssp("DCW", SunSpecInt16, "W", "DCW_SF"), sssf("DCW_SF"),
+		ssp("TmpCab", SunSpecInt16, "C", "Tmp_SF"), ssp("TmpSnk", SunSpecInt16, "C", "Tmp_SF"),
+		ssp("TmpTrns", SunSpecInt16, "C", "Tmp_SF"), ssp("TmpOt", SunSpecInt16, "C", "Tmp_SF"),
+		sssf("Tmp_SF"),
+		ssp("St", SunSpecEnum16, "", ""),
+		ssp("StVnd", SunSpecEnum16, "", ""),
+		ssp("Evt1", SunSpecBitfield32, "", ""), ssp("Evt2", SunSpecBitfield32, "", ""),
+		ssp("EvtVnd1", SunSpecBitfield32, "", ""), ssp("EvtVnd2", SunSpecBitfield32, "", ""),
+		ssp("EvtVnd3", SunSpecBitfield32, "", ""), ssp("EvtVnd4", SunSpecBitfield32, "", ""),
+	}
+}
+
+// sunspecInverterFloat returns the points of float inverter models 111-113.
+func sunspecInverterFloat() []SunSpecPoint {
+	var points []SunSpecPoint
+	for _, p := range []struct{ name, units string }{
+		{"A", "A"}, {"AphA", "A"}, {"AphB", "A"}, {"AphC", "A"},
+		{"PPVphAB", "V"}, {"PPVphBC", "V"}, {"PPVphCA", "V"}, {"PhVphA", "V"}, {"PhVphB", "V"}, {"PhVphC", "V"},
+		{"W", "W"}, {"Hz", "Hz"}, {"VA", "VA"}, {"VAr", "var"}, {"PF", "Pct"}, {"WH", "Wh"},
+		{"DCA", "A"}, {"DCV", "V"}, {"DCW", "W"},
+		{"TmpCab", "C"}, {"TmpSnk", "C"}, {"TmpTrns", "C"}, {"TmpOt", "C"},
+	} {
+		points = append(points, ssp(p.name, SunSpecFloat32, p.units, ""))
+	}
+	return append(points,
+		ssp("St", SunSpecEnum16, "", ""),
+		ssp("StVnd", SunSpecEnum16, "", ""),
+		ssp("Evt1", SunSpecBitfield32, "", ""), ssp("Evt2", SunSpecBitfield32, "", ""),
+		ssp("EvtVnd1", SunSpecBitfield32, "", ""), ssp("EvtVnd2", SunSpecBitfield32, "", ""),
+		ssp("EvtVnd3", SunSpecBitfield32, "", ""), ssp("EvtVnd4", SunSpecBitfield32, "", ""),
+	)
+}
+
+// sunspecMeter returns the points of integer meter models 201-204.
+func sunspecMeter() []SunSpecPoint {
+	var points []SunSpecPoint
+	phases := func(st SunSpecType, units, scale string, names ...string) {
+		for _, name := range names {
+			points = append(points, ssp(name, st, units, scale))
+		}
+		points = append(points, sssf(scale))
+	}
+	phases(SunSpecInt16, "A", "A_SF", "A", "AphA", "AphB", "AphC")
+	phases(SunSpecInt16, "V", "V_SF", "PhV", "PhVphA", "PhVphB", "PhVphC", "PPV", "PPVphAB", "PPVphBC", "PPVphCA")
+	phases(SunSpecInt16, "Hz", "Hz_SF", "Hz")
+	phases(SunSpecInt16, "W", "W_SF", "W", "WphA", "WphB", "WphC")
+	phases(SunSpecInt16, "VA", "VA_SF", "VA", "VAphA", "VAphB", "VAphC")
+	phases(SunSpecInt16, "var", "VAR_SF", "VAR", "VARphA", "VARphB", "VARphC")
+	phases(SunSpecInt16, "Pct", "PF_SF", "PF", "PFphA", "PFphB", "PFphC")
+	phases(SunSpecAcc32, "Wh", "TotWh_SF",
+		"TotWhExp", "TotWhExpPhA", "TotWhExpPhB", "TotWhExpPhC",
+		"TotWhImp", "TotWhImpPhA", "TotWhImpPhB", "TotWhImpPhC")
+	phases(SunSpecAcc32, "VAh", "TotVAh_SF",
+		"TotVAhExp", "TotVAhExpPhA", "TotVAhExpPhB", "TotVAhExpPhC",
+		"TotVAhImp", "TotVAhImpPhA", "TotVAhImpPhB", "TotVAhImpPhC")
+	phases(SunSpecAcc32, "varh", "TotVArh_SF",
+		"TotVArhImpQ1", "TotVArhImpQ1PhA", "TotVArhImpQ1PhB", "TotVArhImpQ1PhC",
+		"TotVArhImpQ2", "TotVArhImpQ2PhA", "TotVArhImpQ2PhB", "TotVArhImpQ2PhC",
+		"TotVArhExpQ3", "TotVArhExpQ3PhA", "TotVArhExpQ3PhB", "TotVArhExpQ3PhC",
+		"TotVArhExpQ4", "TotVArhExpQ4PhA", "TotVArhExpQ4PhB", "TotVArhExpQ4PhC")
+	return append(points, ssp("Evt", SunSpecBitfield32, "", ""))
+}
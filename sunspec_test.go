@@ -0,0 +1,173 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// sunspecImage builds the registers of a model chain.
+type sunspecImage []uint16
+
+func (img *sunspecImage) model(id uint16, data []uint16) {
+	*img = append(append(*img, id, uint16(len(data))), data...)
+}
+
+// sunspecString returns the string padded with zeros to size registers.
+func sunspecString(s string, size int) []uint16 {
+	b := make([]byte, 2*size)
+	copy(b, s)
+	regs := make([]uint16, size)
+	for n := range regs {
+		regs[n] = binary.BigEndian.Uint16(b[2*n:])
+	}
+	return regs
+}
+
+// sunspecPoints places values by point name at their offsets in the block, other points are not implemented.
+func sunspecPoints(points []SunSpecPoint, values map[string][]uint16) []uint16 {
+	regs := make([]uint16, sunspecSize(points))
+	for _, p := range points {
+		if v, ok := values[p.Name]; ok {
+			copy(regs[p.Offset:], v)
+			continue
+		}
+		switch p.Type {
+		case SunSpecInt16, SunSpecSunssf, SunSpecPad:
+			regs[p.Offset] = 0x8000
+		case SunSpecUint16, SunSpecCount, SunSpecEnum16, SunSpecBitfield16:
+			regs[p.Offset] = 0xFFFF
+		case SunSpecUint32, SunSpecEnum32, SunSpecBitfield32:
+			regs[p.Offset], regs[p.Offset+1] = 0xFFFF, 0xFFFF
+		}
+	}
+	return regs
+}
+
+// sunspecDevice serves the model chain at the base address of a simulated device.
+func sunspecDevice(t *testing.T, base uint16) *MBTransporter {
+	t.Helper()
+	common, _ := SunSpecModelDefOf(1)
+	inverter, _ := SunSpecModelDefOf(103)
+	mppt, _ := SunSpecModelDefOf(160)
+
+	img := sunspecImage{0x5375, 0x6e53}
+	img.model(1, sunspecPoints(common.Fixed, map[string][]uint16{
+		"Mn": sunspecString("Acme", 16), "Md": sunspecString("Inv5000", 16), "SN": sunspecString("SN123", 16), "DA": {1},
+	}))
+	img.model(103, sunspecPoints(inverter.Fixed, map[string][]uint16{
+		"A": {123}, "A_SF": {0xFFFF}, "W": {5000}, "W_SF": {0}, "Hz": {5001}, "Hz_SF": {0xFFFE}, "St": {4},
+		"WH": {0x0001, 0x0000}, "WH_SF": {0},
+	}))
+	fixed := sunspecPoints(mppt.Fixed, map[string][]uint16{"DCA_SF": {0xFFFE}, "DCV_SF": {0xFFFF}, "N": {2}})
+	pv1 := sunspecPoints(mppt.Repeat, map[string][]uint16{"ID": {1}, "IDStr": sunspecString("PV1", 8), "DCA": {812}, "DCV": {3905}})
+	pv2 := sunspecPoints(mppt.Repeat, map[string][]uint16{"ID": {2}, "IDStr": sunspecString("PV2", 8), "DCV": {3811}})
+	img.model(160, append(append(fixed, pv1...), pv2...))
+	// unknown model longer than one read
+	img.model(64001, make([]uint16, 130))
+	img = append(img, 0xFFFF, 0)
+
+	raw := make([]byte, 2*len(img))
+	for n, v := range img {
+		binary.BigEndian.PutUint16(raw[2*n:], v)
+	}
+	sim := NewSimulator()
+	dev, err := sim.Add(1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = dev.SetRaw(TableHoldingRegisters, base, uint16(len(img)), raw); err != nil {
+		t.Fatal(err)
+	}
+	return serveLoopback(t, sim)
+}
+
+func TestSunSpecRead(t *testing.T) {
+	for _, base := range []uint16{40000, 50000} {
+		sc := NewSunSpecClient(NewSClient(1, "tcp"), sunspecDevice(t, base))
+		device, warn, err := sc.Read()
+		if warn != nil || err != nil {
+			t.Fatalf("%v: %v %v", base, warn, err)
+		}
+		if device.Base != base || len(device.Models) != 4 {
+			t.Fatalf("%v: base '%v', '%v' models", base, device.Base, len(device.Models))
+		}
+
+		common, ok := device.Model(1)
+		if !ok || !common.Known || common.Name != "common" {
+			t.Fatalf("%v: common model %+v", base, common)
+		}
+		for name, expected := range map[string]string{"Mn": "Acme", "Md": "Inv5000", "SN": "SN123"} {
+			if s, ok := common.String(name); !ok || s != expected {
+				t.Errorf("%v: %v '%v', expected '%v'", base, name, s, expected)
+			}
+		}
+		if _, ok := common.String("Vr"); ok {
+			t.Errorf("%v: empty string implemented", base)
+		}
+
+		inverter, ok := device.Model(103)
+		if !ok || inverter.Name != "inverter_3p" || inverter.Address != base+2+2+66+2 {
+			t.Fatalf("%v: inverter model %+v", base, inverter.SunSpecHeader)
+		}
+		for name, expected := range map[string]float64{"A": 12.3, "W": 5000, "Hz": 50.01, "WH": 65536, "St": 4} {
+			if f, ok := inverter.Float(name); !ok || f < expected-1e-9 || f > expected+1e-9 {
+				t.Errorf("%v: %v '%v' %v, expected '%v'", base, name, f, ok, expected)
+			}
+		}
+		// not implemented value, value with not implemented scale factor
+		for _, name := range []string{"VA", "DCV"} {
+			if _, ok := inverter.Float(name); ok {
+				t.Errorf("%v: %v implemented", base, name)
+			}
+		}
+
+		mppt, ok := device.Model(160)
+		if !ok || len(mppt.Repeats) != 2 {
+			t.Fatalf("%v: mppt model %+v", base, mppt)
+		}
+		if n, ok := mppt.Float("N"); !ok || n != 2 {
+			t.Errorf("%v: N %v", base, n)
+		}
+		expected := []struct {
+			id       string
+			dca, dcv float64
+			hasDCA   bool
+		}{{"PV1", 8.12, 390.5, true}, {"PV2", 0, 381.1, false}}
+		for n, e := range expected {
+			g := mppt.Repeats[n]
+			id, _ := g.String("IDStr")
+			dca, hasDCA := g.Float("DCA")
+			dcv, _ := g.Float("DCV")
+			if id != e.id || hasDCA != e.hasDCA || dca < e.dca-1e-9 || dca > e.dca+1e-9 || dcv < e.dcv-1e-9 || dcv > e.dcv+1e-9 {
+				t.Errorf("%v: module %v: '%v' DCA '%v' %v DCV '%v'", base, n, id, dca, hasDCA, dcv)
+			}
+		}
+
+		unknown, ok := device.Model(64001)
+		if !ok || unknown.Known || len(unknown.Raw) != 260 {
+			t.Errorf("%v: unknown model %+v", base, unknown.SunSpecHeader)
+		}
+	}
+}
+
+func TestSunSpecLocateMissing(t *testing.T) {
+	sim := NewSimulator()
+	if _, err := sim.Add(1, nil); err != nil {
+		t.Fatal(err)
+	}
+	sc := NewSunSpecClient(NewSClient(1, "tcp"), serveLoopback(t, sim))
+	if _, warn, err := sc.Locate(); warn == nil || err != nil {
+		t.Errorf("device without marker: %v %v", warn, err)
+	}
+}
+
+func TestDecodeSunSpecModelLength(t *testing.T) {
+	if _, err := DecodeSunSpecModel(SunSpecHeader{ID: 1, Length: 66}, make([]byte, 10)); err == nil {
+		t.Errorf("short data: no error")
+	}
+	// model shorter than the definition, points beyond the length are left out
+	m, err := DecodeSunSpecModel(SunSpecHeader{ID: 1, Length: 16}, make([]byte, 32))
+	if err != nil || len(m.Points) != 1 {
+		t.Errorf("truncated model: %v points, %v", len(m.Points), err)
+	}
+}
@@ -0,0 +1,450 @@
+package modbus
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Transform converts the decoded value of a tag to the engineering value (Apply)
+// and back for writing (Reverse). Env holds the values of other tags of the register map
+// read before, e.g. scale factor registers.
+type Transform interface {
+	Apply(value interface{}, env map[string]interface{}) (interface{}, error)
+	Reverse(value interface{}, env map[string]interface{}) (interface{}, error)
+	String() string
+}
+
+// Pipeline is the list of transforms applied in order on read and in reverse order on write.
+// The text form is the transforms joined with '|':
+//  scale=0.1            value*0.1
+//  linear=0.1:-40       value*0.1-40
+//  span=0:4095:0:100    raw range 0..4095 mapped to 0..100
+//  sf=W_SF              value*10^W_SF, W_SF is the name of the scale factor tag
+//  mask=0x0F00          (value&0x0F00)>>8, shifted by the trailing zeros of the mask
+//  shift=4              value>>4
+//  bcd                  binary coded decimal digits
+//  enum=off=0;on=1      codes to names
+//  clamp=0:100          limits, a side may be empty
+type Pipeline []Transform
+
+// ParsePipeline parses the text form of the pipeline.
+func ParsePipeline(text string) (p Pipeline, err error) {
+	for _, item := range strings.Split(text, "|") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		t, err := ParseTransform(item)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, t)
+	}
+	return
+}
+
+// ParseTransform parses the text form of one transform, see Pipeline.
+func ParseTransform(text string) (Transform, error) {
+	key, value, _ := strings.Cut(strings.TrimSpace(text), "=")
+	key = strings.ToLower(strings.TrimSpace(key))
+	args := strings.Split(value, ":")
+	floats := func(n int) ([]float64, error) {
+		if len(args) != n {
+			return nil, fmt.Errorf("modbus: transform '%v' needs '%v' arguments", text, n)
+		}
+		fs := make([]float64, n)
+		for i, arg := range args {
+			f, err := strconv.ParseFloat(strings.TrimSpace(arg), 64)
+			if err != nil {
+				return nil, fmt.Errorf("modbus: invalid argument '%v' of transform '%v'", arg, text)
+			}
+			fs[i] = f
+		}
+		return fs, nil
+	}
+	switch key {
+	case "scale":
+		fs, err := floats(1)
+		if err != nil {
+			return nil, err
+		}
+		return &Linear{Scale: fs[0]}, nil
+	case "offset":
+		fs, err := floats(1)
+		if err != nil {
+			return nil, err
+		}
+		return &Linear{Scale: 1, Offset: fs[0]}, nil
+	case "linear":
+		fs, err := floats(2)
+		if err != nil {
+			return nil, err
+		}
+		return &Linear{Scale: fs[0], Offset: fs[1]}, nil
+	case "span":
+		fs, err := floats(4)
+		if err != nil {
+			return nil, err
+		}
+		if fs[0] == fs[1] {
+			return nil, fmt.Errorf("modbus: transform '%v' has empty raw range", text)
+		}
+		return &Span{RawMin: fs[0], RawMax: fs[1], Min: fs[2], Max: fs[3]}, nil
+	case "sf":
+		if strings.TrimSpace(value) == "" {
+			return nil, fmt.Errorf("modbus: transform '%v' needs the scale factor tag", text)
+		}
+		return &ScaleFactor{Tag: strings.TrimSpace(value)}, nil
+	case "mask", "shift":
+		n, err := strconv.ParseUint(strings.TrimSpace(value), 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("modbus: invalid argument '%v' of transform '%v'", value, text)
+		}
+		if key == "shift" {
+			if n > 63 {
+				return nil, fmt.Errorf("modbus: shift of transform '%v' is greater than 63", text)
+			}
+			return &BitMask{Shift: uint(n)}, nil
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("modbus: mask of transform '%v' is zero", text)
+		}
+		return &BitMask{Mask: n, Shift: uint(bits.TrailingZeros64(n))}, nil
+	case "bcd":
+		return BCD{}, nil
+	case "enum":
+		e := &EnumMap{Names: map[int64]string{}}
+		for _, item := range strings.Split(value, ";") {
+			name, code, ok := strings.Cut(item, "=")
+			v, err := strconv.ParseInt(strings.TrimSpace(code), 0, 64)
+			if !ok || err != nil {
+				return nil, fmt.Errorf("modbus: invalid coded value '%v' of transform '%v'", item, text)
+			}
+			e.Names[v] = strings.TrimSpace(name)
+		}
+		return e, nil
+	case "clamp":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("modbus: transform '%v' needs '%v' arguments", text, 2)
+		}
+		c := &Clamp{}
+		for i, limit := range []**float64{&c.Min, &c.Max} {
+			if s := strings.TrimSpace(args[i]); s != "" {
+				f, err := strconv.ParseFloat(s, 64)
+				if err != nil {
+					return nil, fmt.Errorf("modbus: invalid argument '%v' of transform '%v'", s, text)
+				}
+				*limit = &f
+			}
+		}
+		return c, nil
+	}
+	return nil, fmt.Errorf("modbus: unknown transform '%v'", text)
+}
+
+// Apply converts the decoded value to the engineering value.
+func (p Pipeline) Apply(value interface{}, env map[string]interface{}) (_ interface{}, err error) {
+	for _, t := range p {
+		if value, err = t.Apply(value, env); err != nil {
+			return nil, fmt.Errorf("modbus: transform '%v': %w", t, err)
+		}
+	}
+	return value, nil
+}
+
+// Reverse converts the engineering value to the value for encoding.
+func (p Pipeline) Reverse(value interface{}, env map[string]interface{}) (_ interface{}, err error) {
+	for n := len(p) - 1; n >= 0; n-- {
+		if value, err = p[n].Reverse(value, env); err != nil {
+			return nil, fmt.Errorf("modbus: transform '%v': %w", p[n], err)
+		}
+	}
+	return value, nil
+}
+
+// References returns the names of tags needed in env.
+func (p Pipeline) References() (names []string) {
+	for _, t := range p {
+		if sf, ok := t.(*ScaleFactor); ok {
+			names = append(names, sf.Tag)
+		}
+	}
+	return
+}
+
+func (p Pipeline) String() string {
+	items := make([]string, len(p))
+	for n, t := range p {
+		items[n] = t.String()
+	}
+	return strings.Join(items, "|")
+}
+
+func (p Pipeline) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *Pipeline) UnmarshalText(text []byte) (err error) {
+	*p, err = ParsePipeline(string(text))
+	return
+}
+
+// Linear scales the value: value*Scale + Offset.
+type Linear struct {
+	Scale  float64
+	Offset float64
+}
+
+func (l *Linear) Apply(value interface{}, env map[string]interface{}) (interface{}, error) {
+	f, err := toFloat64(value)
+	return f*l.Scale + l.Offset, err
+}
+
+func (l *Linear) Reverse(value interface{}, env map[string]interface{}) (interface{}, error) {
+	f, err := toFloat64(value)
+	if err != nil {
+		return nil, err
+	}
+	if l.Scale == 0 {
+		return nil, fmt.Errorf("scale is zero")
+	}
+	return (f - l.Offset) / l.Scale, nil
+}
+
+func (l *Linear) String() string {
+	if l.Offset == 0 {
+		return "scale=" + formatFloat(l.Scale)
+	}
+	if l.Scale == 1 {
+		return "offset=" + formatFloat(l.Offset)
+	}
+	return "linear=" + formatFloat(l.Scale) + ":" + formatFloat(l.Offset)
+}
+
+// Span maps the raw range to the engineering range linearly.
+type Span struct {
+	RawMin, RawMax float64
+	Min, Max       float64
+}
+
+func (s *Span) Apply(value interface{}, env map[string]interface{}) (interface{}, error) {
+	f, err := toFloat64(value)
+	return s.Min + (f-s.RawMin)*(s.Max-s.Min)/(s.RawMax-s.RawMin), err
+}
+
+func (s *Span) Reverse(value interface{}, env map[string]interface{}) (interface{}, error) {
+	f, err := toFloat64(value)
+	if err != nil {
+		return nil, err
+	}
+	if s.Max == s.Min {
+		return nil, fmt.Errorf("engineering range is empty")
+	}
+	return s.RawMin + (f-s.Min)*(s.RawMax-s.RawMin)/(s.Max-s.Min), nil
+}
+
+func (s *Span) String() string {
+	return "span=" + formatFloat(s.RawMin) + ":" + formatFloat(s.RawMax) + ":" + formatFloat(s.Min) + ":" + formatFloat(s.Max)
+}
+
+// ScaleFactor scales the value by 10 to the power of the value of the tag, as SunSpec sunssf.
+type ScaleFactor struct {
+	Tag string
+}
+
+func (sf *ScaleFactor) exponent(env map[string]interface{}) (float64, error) {
+	v, ok := env[sf.Tag]
+	if !ok {
+		return 0, fmt.Errorf("value of scale factor tag '%v' is missing", sf.Tag)
+	}
+	e, err := toInt64(v)
+	if err != nil {
+		return 0, err
+	}
+	if e < -10 || e > 10 {
+		return 0, fmt.Errorf("scale factor '%v' is out of range", e)
+	}
+	return math.Pow10(int(e)), nil
+}
+
+func (sf *ScaleFactor) Apply(value interface{}, env map[string]interface{}) (interface{}, error) {
+	m, err := sf.exponent(env)
+	if err != nil {
+		return nil, err
+	}
+	f, err := toFloat64(value)
+	return f * m, err
+}
+
+func (sf *ScaleFactor) Reverse(value interface{}, env map[string]interface{}) (interface{}, error) {
+	m, err := sf.exponent(env)
+	if err != nil {
+		return nil, err
+	}
+	f, err := toFloat64(value)
+	return f / m, err
+}
+
+func (sf *ScaleFactor) String() string { return "sf=" + sf.Tag }
+
+// BitMask extracts the bits of Mask shifted right by Shift, zero Mask keeps all bits.
+// Reverse shifts the value back, other bits of the register are zero.
+type BitMask struct {
+	Mask  uint64
+	Shift uint
+}
+
+func (bm *BitMask) Apply(value interface{}, env map[string]interface{}) (interface{}, error) {
+	v, err := toUint64(value)
+	if err != nil {
+		return nil, err
+	}
+	if bm.Mask != 0 {
+		v &= bm.Mask
+	}
+	return v >> bm.Shift, nil
+}
+
+func (bm *BitMask) Reverse(value interface{}, env map[string]interface{}) (interface{}, error) {
+	v, err := toUint64(value)
+	if err != nil {
+		return nil, err
+	}
+	r := v << bm.Shift
+	if r>>bm.Shift != v || (bm.Mask != 0 && r&^bm.Mask != 0) {
+		return nil, fmt.Errorf("value '%v' does not fit the bits", v)
+	}
+	return r, nil
+}
+
+func (bm *BitMask) String() string {
+	if bm.Mask == 0 {
+		return "shift=" + strconv.FormatUint(uint64(bm.Shift), 10)
+	}
+	return fmt.Sprintf("mask=0x%X", bm.Mask)
+}
+
+// BCD decodes binary coded decimal digits, 0x1234 is 1234.
+type BCD struct{}
+
+func (BCD) Apply(value interface{}, env map[string]interface{}) (interface{}, error) {
+	v, err := toUint64(value)
+	if err != nil {
+		return nil, err
+	}
+	var r, m uint64 = 0, 1
+	for ; v > 0; v >>= 4 {
+		d := v & 0x0F
+		if d > 9 {
+			return nil, fmt.Errorf("invalid BCD digit '%X'", d)
+		}
+		r += d * m
+		m *= 10
+	}
+	return r, nil
+}
+
+func (BCD) Reverse(value interface{}, env map[string]interface{}) (interface{}, error) {
+	v, err := toUint64(value)
+	if err != nil {
+		return nil, err
+	}
+	var r uint64
+	for shift := uint(0); v > 0; shift += 4 {
+		if shift >= 64 {
+			return nil, fmt.Errorf("value overflows BCD")
+		}
+		r |= (v % 10) << shift
+		v /= 10
+	}
+	return r, nil
+}
+
+func (BCD) String() string { return "bcd" }
+
+// EnumMap converts codes to names, unknown codes are kept as numbers.
+// Reverse accepts names and numbers.
+type EnumMap struct {
+	Names map[int64]string
+}
+
+func (e *EnumMap) Apply(value interface{}, env map[string]interface{}) (interface{}, error) {
+	v, err := toInt64(value)
+	if err != nil {
+		return nil, err
+	}
+	if name, ok := e.Names[v]; ok {
+		return name, nil
+	}
+	return v, nil
+}
+
+func (e *EnumMap) Reverse(value interface{}, env map[string]interface{}) (interface{}, error) {
+	if s, ok := value.(string); ok {
+		for code, name := range e.Names {
+			if name == s {
+				return code, nil
+			}
+		}
+		if _, err := strconv.ParseInt(s, 0, 64); err != nil {
+			return nil, fmt.Errorf("unknown name '%v'", s)
+		}
+	}
+	return toInt64(value)
+}
+
+func (e *EnumMap) String() string {
+	codes := make([]int64, 0, len(e.Names))
+	for code := range e.Names {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	items := make([]string, len(codes))
+	for n, code := range codes {
+		items[n] = e.Names[code] + "=" + strconv.FormatInt(code, 10)
+	}
+	return "enum=" + strings.Join(items, ";")
+}
+
+// Clamp limits the value to Min and Max in both directions.
+type Clamp struct {
+	Min *float64
+	Max *float64
+}
+
+func (c *Clamp) Apply(value interface{}, env map[string]interface{}) (interface{}, error) {
+	f, err := toFloat64(value)
+	if err != nil {
+		return nil, err
+	}
+	if c.Min != nil && f < *c.Min {
+		f = *c.Min
+	}
+	if c.Max != nil && f > *c.Max {
+		f = *c.Max
+	}
+	return f, nil
+}
+
+func (c *Clamp) Reverse(value interface{}, env map[string]interface{}) (interface{}, error) {
+	return c.Apply(value, env)
+}
+
+func (c *Clamp) String() string {
+	var min, max string
+	if c.Min != nil {
+		min = formatFloat(*c.Min)
+	}
+	if c.Max != nil {
+		max = formatFloat(*c.Max)
+	}
+	return "clamp=" + min + ":" + max
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
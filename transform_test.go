@@ -0,0 +1,131 @@
+package modbus
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestEnumMapTransform(t *testing.T) {
+	p, err := ParsePipeline("enum=off=0;on=1;auto=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := p.String(); s != "enum=off=0;on=1;auto=2" {
+		t.Errorf("string %v", s)
+	}
+	tag := &Tag{Name: "mode", Table: TableHoldingRegisters, Type: TypeUint16, Transforms: p}
+	for raw, expected := range map[uint16]interface{}{0: "off", 2: "auto", 7: int64(7)} {
+		value, err := tag.Decode([]byte{byte(raw >> 8), byte(raw)})
+		if err != nil || value != expected {
+			t.Errorf("decode %v: %v (%T) %v, expected %v", raw, value, value, err, expected)
+		}
+	}
+	for _, value := range []interface{}{"auto", 2, "2"} {
+		raw, err := tag.Encode(value)
+		if err != nil || !bytes.Equal(raw, []byte{0, 2}) {
+			t.Errorf("encode %v (%T): % x %v", value, value, raw, err)
+		}
+	}
+	if _, err = tag.Encode("manual"); err == nil {
+		t.Errorf("encode of unknown name: no error")
+	}
+	for _, spec := range []string{"enum=off", "enum=off=x", "enum="} {
+		if _, err = ParseTransform(spec); err == nil {
+			t.Errorf("%v: error expected", spec)
+		}
+	}
+}
+
+// sameValue compares numbers as float64 with tolerance of rounding, other values exactly.
+func sameValue(a, b interface{}) bool {
+	if _, ok := a.(string); !ok {
+		fa, erra := toFloat64(a)
+		fb, errb := toFloat64(b)
+		if erra == nil && errb == nil {
+			return math.Abs(fa-fb) < 1e-9
+		}
+	}
+	return a == b
+}
+
+func TestPipeline(t *testing.T) {
+	env := map[string]interface{}{"W_SF": int64(-2)}
+	tests := []struct {
+		spec  string
+		raw   interface{} // decoded value, Apply gives value
+		value interface{} // engineering value, Reverse gives back
+		back  interface{}
+	}{
+		{"scale=0.1", uint64(215), 21.5, 215},
+		{"offset=-273.15", uint64(300), 26.85, 300},
+		{"linear=0.1:-40", uint64(600), 20, 600},
+		{"span=0:4095:0:100", uint64(4095), 100, 4095},
+		{"span=4095:0:0:100", uint64(0), 100, 0},
+		{"sf=W_SF", int64(12345), 123.45, 12345},
+		{"mask=0xF00|bcd", uint64(0x0950), uint64(9), uint64(0x0900)},
+		{"shift=4|scale=0.5", uint64(0x30), 1.5, uint64(0x30)},
+		{"bcd|clamp=0:100", uint64(0x0150), 100, uint64(0x0100)},
+		{"bcd|clamp=:", uint64(0x0150), 150, uint64(0x0150)},
+		{"mask=0xFF|enum=off=0;on=1", uint64(0x0301), "on", uint64(0x01)},
+		{"mask=0xFF|enum=off=0;on=1", uint64(0x0307), int64(7), uint64(0x07)},
+		{"scale=0.1|offset=5|clamp=:50", uint64(600), 50, 450},
+	}
+	for _, test := range tests {
+		p, err := ParsePipeline(test.spec)
+		if err != nil {
+			t.Errorf("%v: %v", test.spec, err)
+			continue
+		}
+		if s := p.String(); s != test.spec {
+			t.Errorf("%v: string '%v'", test.spec, s)
+		}
+		value, err := p.Apply(test.raw, env)
+		if err != nil || !sameValue(value, test.value) {
+			t.Errorf("%v: apply %v: %v (%T) %v, expected %v", test.spec, test.raw, value, value, err, test.value)
+		}
+		back, err := p.Reverse(test.value, env)
+		if err != nil || !sameValue(back, test.back) {
+			t.Errorf("%v: reverse %v: %v (%T) %v, expected %v", test.spec, test.value, back, back, err, test.back)
+		}
+	}
+}
+
+func TestPipelineErrors(t *testing.T) {
+	tests := []struct {
+		spec    string
+		value   interface{}
+		reverse bool
+	}{
+		{"sf=W_SF", uint64(1), false},
+		{"sf=W_SF", 1.0, true},
+		{"bcd", uint64(0x1A), false},
+		{"mask=0xF00", uint64(16), true},
+		{"shift=60", uint64(32), true},
+		{"enum=off=0", "manual", true},
+		{"span=0:100:5:5", 5.0, true},
+		{"scale=0", 1.0, true},
+		{"scale=2|bcd", uint64(0x1F), false},
+	}
+	for _, test := range tests {
+		p, err := ParsePipeline(test.spec)
+		if err != nil {
+			t.Errorf("%v: %v", test.spec, err)
+			continue
+		}
+		var value interface{}
+		if test.reverse {
+			value, err = p.Reverse(test.value, nil)
+		} else {
+			value, err = p.Apply(test.value, nil)
+		}
+		if err == nil {
+			t.Errorf("%v: %v of %v gives %v, error expected", test.spec, map[bool]string{false: "apply", true: "reverse"}[test.reverse], test.value, value)
+		}
+	}
+	for _, spec := range []string{"scale", "linear=1", "span=1:1:0:10", "sf=", "mask=0", "shift=64", "clamp=1", "clamp=x:", "round=1", "scale=0.1|mask=x"} {
+		if _, err := ParsePipeline(spec); err == nil {
+			t.Errorf("%v: error expected", spec)
+		}
+	}
+}
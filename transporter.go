@@ -24,6 +24,11 @@ type ApiTransporter interface {
 	SetLogger(*log.Logger)
 }
 
+// ApiSender sends requests, implemented by MBTransporter and all transporters.
+type ApiSender interface {
+	Send([]byte) ([]byte, error, error)
+}
+
 type MBTransporter struct {
 	// mode string
 	// addr        string
@@ -100,8 +105,13 @@ func NewTransporter() *MBTransporter {
 // 	mbt.idletimeout = idletimeout
 // }
 
+// Connect opens the transporter of mode rtu, ascii, tcp or unix. Mode unix connects to Mux
+// at the socket path, also given as "unix:///path" in mode or address.
 func (mbt *MBTransporter) Connect(mode, address string, baudrate, databits int, parity string, stopbits int, timeout, idletimeout int64) error {
 	mbt.success = false
+	if strings.HasPrefix(mode, "unix://") {
+		mode, address = "unix", mode
+	}
 	switch strings.ToLower(mode) {
 	case "rtu":
 		rtu := rtuTransporter{}
@@ -130,14 +140,103 @@ func (mbt *MBTransporter) Connect(mode, address string, baudrate, databits int,
 		mbt.ApiTransporter = &ascii
 		mbt.success = true
 		return nil
+	case "unix":
+		ux := unixTransporter{}
+		ux.Set(address, timeout, idletimeout)
+		if err := ux.Connect(); err != nil {
+			return err
+		}
+		mbt.ApiTransporter = &ux
+		mbt.success = true
+		return nil
 	}
 	return fmt.Errorf("unknown connection type")
 }
 
+// ConnectPort opens the transporter of mode rtu or ascii over the port of the opener instead of
+// a serial device, framing and timing are those of the device. The line settings are passed
+// to the opener and determine the RTU frame timeouts.
+func (mbt *MBTransporter) ConnectPort(mode string, open PortOpener, baudrate, databits int, parity string, stopbits int, timeout, idletimeout int64) error {
+	mbt.success = false
+	if open == nil {
+		return fmt.Errorf("modbus: port opener is nil")
+	}
+	switch strings.ToLower(mode) {
+	case "rtu":
+		rtu := rtuTransporter{}
+		rtu.Set("port", baudrate, databits, parity, stopbits, timeout, idletimeout)
+		rtu.open = open
+		if err := rtu.Connect(); err != nil {
+			return err
+		}
+		mbt.ApiTransporter = &rtu
+	case "ascii":
+		ascii := asciiTransporter{}
+		ascii.Set("port", baudrate, databits, parity, stopbits, timeout, idletimeout)
+		ascii.open = open
+		if err := ascii.Connect(); err != nil {
+			return err
+		}
+		mbt.ApiTransporter = &ascii
+	default:
+		return fmt.Errorf("modbus: port mode '%v' is not rtu or ascii", mode)
+	}
+	mbt.success = true
+	return nil
+}
+
 func (mbt *MBTransporter) FirstConnectSuccess() bool {
 	return mbt.success
 }
 
+// SetFrameTimeouts sets the RTU inter-character (t1.5) and inter-frame (t3.5) silence.
+// Zero values are calculated from the baud rate, the spec fixes 750us and 1750us above 19200 baud.
+func (mbt *MBTransporter) SetFrameTimeouts(charTimeout, frameTimeout time.Duration) {
+	if rtu, ok := mbt.ApiTransporter.(*rtuTransporter); ok {
+		rtu.SetFrameTimeouts(charTimeout, frameTimeout)
+	}
+}
+
+// DroppedBytes returns total count of noise bytes discarded by RTU transporter while receiving.
+func (mbt *MBTransporter) DroppedBytes() uint64 {
+	if rtu, ok := mbt.ApiTransporter.(*rtuTransporter); ok {
+		return rtu.DroppedBytes()
+	}
+	return 0
+}
+
+// SetEcho enables suppression of the local echo of half-duplex RS-485 adapters, serial line transporters only.
+func (mbt *MBTransporter) SetEcho(echo bool) {
+	if sp, ok := mbt.ApiTransporter.(interface{ SetEcho(bool) }); ok {
+		sp.SetEcho(echo)
+	}
+}
+
+// SetTurnaroundDelay sets the delay after a broadcast request, serial line transporters only.
+func (mbt *MBTransporter) SetTurnaroundDelay(delay time.Duration) {
+	if sp, ok := mbt.ApiTransporter.(interface{ SetTurnaroundDelay(time.Duration) }); ok {
+		sp.SetTurnaroundDelay(delay)
+	}
+}
+
+// SetBusLock enables the advisory lock file held during each transaction, serial line transporters only.
+// See SerialLockFile for the conventional path of a device.
+func (mbt *MBTransporter) SetBusLock(path string, timeout time.Duration) {
+	if sp, ok := mbt.ApiTransporter.(interface {
+		SetBusLock(string, time.Duration)
+	}); ok {
+		sp.SetBusLock(path, timeout)
+	}
+}
+
+// SetPriority sets the priority (PriorityAuto, PriorityBulk, PriorityNormal, PriorityInteractive)
+// of the requests queued by Mux, unix transporter only.
+func (mbt *MBTransporter) SetPriority(priority byte) {
+	if ux, ok := mbt.ApiTransporter.(*unixTransporter); ok {
+		ux.SetPriority(priority)
+	}
+}
+
 // func (mbt *MBTransporter) Send(aduRequest []byte) (aduResponse []byte, warn, err error) {
 // 	return mbt.t.Send(aduRequest)
 // }
@@ -185,8 +284,18 @@ type serialPort struct {
 
 	Logger      *log.Logger
 	IdleTimeout time.Duration
+	// Delay after a broadcast request, before the next request is sent
+	TurnaroundDelay time.Duration
+	// Adapter echoes transmitted bytes back to the receiver (half-duplex RS-485)
+	Echo bool
+	// Lock file held during each transaction, shared with other processes on the bus
+	busLock *busLock
 
 	mu sync.Mutex
+	// readTimeout overrides the read timeout of the opened port, Config.Timeout is used if zero
+	readTimeout time.Duration
+	// open opens the port instead of the serial device if set
+	open PortOpener
 	// port is platform-dependent data structure for serial port.
 	port         io.ReadWriteCloser
 	lastActivity time.Time
@@ -200,10 +309,23 @@ func (mb *serialPort) Connect() (err error) {
 	return mb.connect()
 }
 
-// connect connects to the serial port if it is not connected. Caller must hold the mutex.
+// connect connects to the serial port if it is not connected, addresses "rfc2217://host:port"
+// are ports of RFC 2217 terminal servers. The opener replaces the device if set. Caller must hold the mutex.
 func (mb *serialPort) connect() error {
 	if mb.port == nil {
-		port, err := serial.Open(&mb.Config)
+		config := mb.Config
+		if mb.readTimeout > 0 {
+			config.Timeout = mb.readTimeout
+		}
+		var port io.ReadWriteCloser
+		var err error
+		if mb.open != nil {
+			port, err = openPort(mb.open, &config)
+		} else if strings.HasPrefix(config.Address, rfc2217Scheme) {
+			port, err = DialRFC2217(&config)
+		} else {
+			port, err = serial.Open(&config)
+		}
 		if err != nil {
 			return err
 		}
@@ -234,6 +356,103 @@ func (mb *serialPort) logf(format string, v ...interface{}) {
 	}
 }
 
+func (mb *serialPort) SetTurnaroundDelay(delay time.Duration) {
+	mb.mu.Lock()
+	mb.TurnaroundDelay = delay
+	mb.mu.Unlock()
+}
+
+func (mb *serialPort) SetEcho(echo bool) {
+	mb.mu.Lock()
+	mb.Echo = echo
+	mb.mu.Unlock()
+}
+
+// SetBusLock enables the advisory lock file held during each transaction, so separate
+// processes can share the bus, empty path disables. Locks of processes not running are
+// released by the system. Zero timeout waits for ever.
+func (mb *serialPort) SetBusLock(path string, timeout time.Duration) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if path == "" {
+		mb.busLock = nil
+		return
+	}
+	mb.busLock = &busLock{Path: path, Timeout: timeout}
+}
+
+// lockBus acquires the bus lock if enabled. Caller must hold the mutex.
+func (mb *serialPort) lockBus() error {
+	if mb.busLock == nil {
+		return nil
+	}
+	return mb.busLock.acquire()
+}
+
+// unlockBus releases the bus lock if enabled. Caller must hold the mutex.
+func (mb *serialPort) unlockBus() {
+	if mb.busLock == nil {
+		return
+	}
+	if err := mb.busLock.release(); err != nil {
+		mb.logf("modbus: bus lock: %v\n", err)
+	}
+}
+
+// responseTimeout returns Timeout, or the default if not set.
+func (mb *serialPort) responseTimeout() time.Duration {
+	if mb.Timeout > 0 {
+		return mb.Timeout
+	}
+	return serialTimeout
+}
+
+// readEcho reads back the transmitted request if the adapter echoes it,
+// a different echo means a collision on the bus. Caller must hold the mutex.
+func (mb *serialPort) readEcho(aduRequest []byte) (err error) {
+	if !mb.Echo {
+		return
+	}
+	deadline := time.Now().Add(mb.responseTimeout())
+	var n int
+	echo := make([]byte, len(aduRequest))
+	length := 0
+	for length < len(echo) {
+		n, err = mb.port.Read(echo[length:])
+		length += n
+		if err == serial.ErrTimeout && time.Now().Before(deadline) {
+			continue
+		}
+		if err == serial.ErrTimeout {
+			return fmt.Errorf("modbus: echo of request not received, '%v' of '%v' bytes", length, len(echo))
+		}
+		if err != nil {
+			return
+		}
+	}
+	if !bytes.Equal(echo, aduRequest) {
+		err = fmt.Errorf("modbus: echo '% x' does not match request '% x', bus collision", echo, aduRequest)
+	}
+	return
+}
+
+// broadcast sends the request to all slaves and waits the turnaround delay,
+// no response is expected. Caller must hold the mutex.
+func (mb *serialPort) broadcast(aduRequest []byte) (warn, err error) {
+	if _, err = mb.port.Write(aduRequest); err != nil {
+		return
+	}
+	if warn = mb.readEcho(aduRequest); warn != nil {
+		return
+	}
+	delay := mb.TurnaroundDelay
+	if delay <= 0 {
+		delay = serialTurnaroundDelay
+	}
+	time.Sleep(delay)
+	return
+}
+
 func (mb *serialPort) startCloseTimer() {
 	if mb.IdleTimeout <= 0 {
 		return
@@ -263,6 +482,28 @@ func (mb *serialPort) closeIdle() {
 // rtuSerialTransporter implements Transporter interface.
 type rtuTransporter struct {
 	serialPort
+	// Inter-character (t1.5) and inter-frame (t3.5) silence, calculated from baud rate if zero
+	CharTimeout  time.Duration
+	FrameTimeout time.Duration
+
+	// Count of noise bytes dropped while receiving
+	dropped uint64
+	// Input may hold a late response of a failed request, it is flushed before the next request
+	stale bool
+}
+
+// Connect opens the port with the t3.5 read timeout used for frame detection.
+func (rtu *rtuTransporter) Connect() (err error) {
+	rtu.serialPort.mu.Lock()
+	defer rtu.serialPort.mu.Unlock()
+
+	return rtu.connect()
+}
+
+// connect connects to the serial port if it is not connected. Caller must hold the mutex.
+func (rtu *rtuTransporter) connect() error {
+	_, rtu.serialPort.readTimeout = rtu.frameTimeouts()
+	return rtu.serialPort.connect()
 }
 
 func (rtu *rtuTransporter) GetAddress() string {
@@ -287,101 +528,260 @@ func (rtu *rtuTransporter) Spec(aduReqRes []byte) (byte, byte) {
 	return aduReqRes[0], aduReqRes[1]
 }
 
+// Send sends the request and reads the response.
+// Broadcast requests (slave id 0) are not answered, aduResponse is nil for them.
 func (rtu *rtuTransporter) Send(aduRequest []byte) (aduResponse []byte, warn, err error) {
+	rtu.serialPort.mu.Lock()
+	defer rtu.serialPort.mu.Unlock()
+
+	if len(aduRequest) < rtuMinSize {
+		err = fmt.Errorf("modbus: request length '%v' does not meet minimum '%v'", len(aduRequest), rtuMinSize)
+		return
+	}
+	if err = verifySerialAddress(rtu.Spec(aduRequest)); err != nil {
+		return
+	}
 	// Make sure port is connected
-	if err = rtu.serialPort.connect(); err != nil {
+	if err = rtu.connect(); err != nil {
 		return
 	}
 	// Start the timer to close when idle
 	rtu.serialPort.lastActivity = time.Now()
 	rtu.serialPort.startCloseTimer()
+	// Hold the bus against other processes for the transaction
+	if err = rtu.serialPort.lockBus(); err != nil {
+		return
+	}
+	defer rtu.serialPort.unlockBus()
+	if isBroadcast(rtu.Spec(aduRequest)) {
+		rtu.serialPort.logf("modbus: broadcasting % x\n", aduRequest)
+		warn, err = rtu.serialPort.broadcast(aduRequest)
+		return
+	}
+	// Drop stale input before the request
+	rtu.flush()
 	// Send the request
 	rtu.serialPort.logf("modbus: sending % x\n", aduRequest)
+	rtu.stale = true
 	if _, err = rtu.port.Write(aduRequest); err != nil {
 		return
 	}
-	function := aduRequest[1]
-	functionFail := aduRequest[1] & 0x80
-	bytesToRead := calculateResponseLength(aduRequest)
-	time.Sleep(rtu.calculateDelay(len(aduRequest) + bytesToRead))
-
-	var n int
-	var n1 int
-	var data [rtuMaxSize]byte
-	//We first read the minimum length and then read either the full package
-	//or the error package, depending on the error status (byte 2 of the response)
-	n, warn = io.ReadAtLeast(rtu.port, data[:], rtuMinSize)
+	if warn = rtu.serialPort.readEcho(aduRequest); warn != nil {
+		return
+	}
+	aduResponse, warn = rtu.receive(aduRequest)
 	if warn != nil {
 		return
 	}
-	//if the function is correct
-	if data[1] == function {
-		//we read the rest of the bytes
-		if n < bytesToRead {
-			if bytesToRead > rtuMinSize && bytesToRead <= rtuMaxSize {
-				if bytesToRead > n {
-					n1, warn = io.ReadFull(rtu.port, data[n:bytesToRead])
-					n += n1
-				}
+	rtu.stale = false
+	rtu.serialPort.logf("modbus: received % x\n", aduResponse)
+	return
+}
+
+// SetFrameTimeouts sets the inter-character (t1.5) and inter-frame (t3.5) silence intervals.
+// Zero values are calculated from the baud rate. The read timeout of an open port is changed
+// in place, serial devices are reopened on the next request.
+func (rtu *rtuTransporter) SetFrameTimeouts(charTimeout, frameTimeout time.Duration) {
+	rtu.serialPort.mu.Lock()
+	defer rtu.serialPort.mu.Unlock()
+
+	rtu.CharTimeout = charTimeout
+	rtu.FrameTimeout = frameTimeout
+	_, rtu.serialPort.readTimeout = rtu.frameTimeouts()
+	if port, ok := rtu.port.(interface{ SetReadTimeout(time.Duration) }); ok {
+		port.SetReadTimeout(rtu.serialPort.readTimeout)
+		return
+	}
+	rtu.serialPort.close()
+}
+
+// readFrame reads the line until it is silent for t3.5. Read timeout of the port is set to t3.5,
+// so a timed out read after the first byte marks the end of frame. serial.ErrTimeout is
+// returned if nothing is received before the deadline, or within t3.5 if the deadline is zero.
+// Caller must hold the mutex.
+func (rtu *rtuTransporter) readFrame(deadline time.Time) (frame []byte, err error) {
+	var n int
+	var data [rtuMaxSize]byte
+	charTimeout, _ := rtu.frameTimeouts()
+
+	length := 0
+	var last time.Time
+	for length < rtuMaxSize {
+		n, err = rtu.port.Read(data[length:])
+		now := time.Now()
+		if n > 0 {
+			if length > 0 && now.Sub(last) > charTimeout {
+				rtu.serialPort.logf("modbus: inter-character silence %v exceeds t1.5 %v\n", now.Sub(last), charTimeout)
 			}
+			length += n
+			last = now
 		}
-	} else if data[1] == functionFail {
-		//for error we need to read 5 bytes
-		if n < rtuExceptionSize {
-			n1, warn = io.ReadFull(rtu.port, data[n:rtuExceptionSize])
+		if err == serial.ErrTimeout {
+			err = nil
+			if length > 0 {
+				// Silence for t3.5 after the last character
+				break
+			}
+			if deadline.IsZero() || now.After(deadline) {
+				err = serial.ErrTimeout
+				return
+			}
+			continue
+		}
+		if err != nil {
+			break
 		}
-		n += n1
 	}
-	if warn != nil {
+	frame = data[:length]
+	return
+}
+
+// receive reads until a valid response frame for the request is found.
+// Noise, echoed requests and stale frames around it are dropped. Caller must hold the mutex.
+func (rtu *rtuTransporter) receive(aduRequest []byte) (aduResponse []byte, err error) {
+	deadline := time.Now().Add(rtu.serialPort.responseTimeout())
+	var data, chunk []byte
+	var dropped int
+	for {
+		chunk, err = rtu.readFrame(deadline)
+		data = append(data, chunk...)
+		if len(data) > 2*rtuMaxSize {
+			rtu.drop(len(data) - 2*rtuMaxSize)
+			data = data[len(data)-2*rtuMaxSize:]
+		}
+		if aduResponse, dropped = findRtuFrame(aduRequest, data); aduResponse != nil {
+			rtu.drop(dropped)
+			err = nil
+			return
+		}
+		if err != nil {
+			rtu.drop(len(data))
+			if err == serial.ErrTimeout && len(data) > 0 {
+				err = fmt.Errorf("modbus: no valid response frame in '%v' bytes received", len(data))
+			}
+			return
+		}
+	}
+}
+
+// flush drops pending input after a failed request, e.g. the late response of a timed out request.
+// It waits for t3.5 of silence, so it is skipped after successful requests. Caller must hold the mutex.
+func (rtu *rtuTransporter) flush() {
+	if !rtu.stale {
 		return
 	}
-	aduResponse = data[:n]
-	rtu.serialPort.logf("modbus: received % x\n", aduResponse)
-	return
+	rtu.stale = false
+	var data [rtuMaxSize]byte
+	for {
+		n, err := rtu.port.Read(data[:])
+		rtu.drop(n)
+		if err != nil || n == 0 {
+			return
+		}
+	}
 }
 
-// calculateDelay roughly calculates time needed for the next frame.
-// See MODBUS over Serial Line - Specification and Implementation Guide (page 13).
-func (mb *rtuTransporter) calculateDelay(chars int) time.Duration {
-	var characterDelay, frameDelay int // us
+// drop counts bytes discarded while searching for a response frame.
+func (rtu *rtuTransporter) drop(n int) {
+	if n > 0 {
+		rtu.dropped += uint64(n)
+		rtu.serialPort.logf("modbus: dropped %v bytes of noise\n", n)
+	}
+}
 
-	if mb.BaudRate <= 0 || mb.BaudRate > 19200 {
-		characterDelay = 750
-		frameDelay = 1750
-	} else {
-		characterDelay = 15000000 / mb.BaudRate
-		frameDelay = 35000000 / mb.BaudRate
+// DroppedBytes returns total count of noise bytes discarded while receiving.
+func (rtu *rtuTransporter) DroppedBytes() uint64 {
+	rtu.serialPort.mu.Lock()
+	defer rtu.serialPort.mu.Unlock()
+
+	return rtu.dropped
+}
+
+// findRtuFrame scans data for a frame with the slave id, function or exception code of the request
+// and a matching CRC. Returns the frame and the count of bytes around it.
+func findRtuFrame(aduRequest, data []byte) (frame []byte, dropped int) {
+	id, function := aduRequest[0], aduRequest[1]
+	for i := 0; i+rtuMinSize <= len(data); i++ {
+		if data[i] != id || (data[i+1] != function && data[i+1] != function|0x80) {
+			continue
+		}
+		if length := rtuResponseLength(data[i:]); length > 0 {
+			if i+length <= len(data) && isRtuResponse(aduRequest, data[i:i+length]) {
+				return data[i : i+length], len(data) - length
+			}
+			continue
+		}
+		for j := i + rtuMinSize; j <= len(data); j++ {
+			if isRtuResponse(aduRequest, data[i:j]) {
+				return data[i:j], len(data) - (j - i)
+			}
+		}
 	}
-	return time.Duration(characterDelay*chars+frameDelay) * time.Microsecond
+	return nil, 0
 }
 
-func calculateResponseLength(adu []byte) int {
-	length := rtuMinSize
+// isRtuResponse checks the CRC of the frame and that it is not the echo of the request.
+func isRtuResponse(aduRequest, frame []byte) bool {
+	var crc crc
+	length := len(frame)
+	crc.reset().pushBytes(frame[:length-2])
+	if uint16(frame[length-1])<<8|uint16(frame[length-2]) != crc.value() {
+		return false
+	}
+	// Write single coil and register respond with the echo of the request
+	switch frame[1] {
+	case FuncCodeWriteSingleCoil, FuncCodeWriteSingleRegister:
+		return true
+	}
+	return !bytes.Equal(aduRequest, frame)
+}
+
+// rtuResponseLength returns the length of the response frame from its header,
+// zero if the length can not be determined.
+func rtuResponseLength(adu []byte) int {
+	if adu[1]&0x80 != 0 {
+		return rtuExceptionSize
+	}
 	switch adu[1] {
 	case FuncCodeReadDiscreteInputs,
-		FuncCodeReadCoils:
-		count := int(binary.BigEndian.Uint16(adu[4:]))
-		length += 1 + count/8
-		if count%8 != 0 {
-			length++
-		}
-	case FuncCodeReadInputRegisters,
+		FuncCodeReadCoils,
+		FuncCodeReadInputRegisters,
 		FuncCodeReadHoldingRegisters,
 		FuncCodeReadWriteMultipleRegisters:
-		count := int(binary.BigEndian.Uint16(adu[4:]))
-		length += 1 + count*2
+		return 5 + int(adu[2])
 	case FuncCodeWriteSingleCoil,
 		FuncCodeWriteMultipleCoils,
 		FuncCodeWriteSingleRegister,
 		FuncCodeWriteMultipleRegisters:
-		length += 4
+		return 8
 	case FuncCodeMaskWriteRegister:
-		length += 6
+		return 10
 	case FuncCodeReadFIFOQueue:
-		// undetermined
-	default:
+		return 6 + int(binary.BigEndian.Uint16(adu[2:]))
 	}
-	return length
+	return 0
+}
+
+// frameTimeouts returns t1.5 and t3.5, calculated from the baud rate unless they are set.
+// See MODBUS over Serial Line - Specification and Implementation Guide (page 13).
+func (rtu *rtuTransporter) frameTimeouts() (charTimeout, frameTimeout time.Duration) {
+	var characterDelay, frameDelay int // us
+
+	if rtu.BaudRate <= 0 || rtu.BaudRate > 19200 {
+		characterDelay = 750
+		frameDelay = 1750
+	} else {
+		characterDelay = 15000000 / rtu.BaudRate
+		frameDelay = 35000000 / rtu.BaudRate
+	}
+	charTimeout, frameTimeout = rtu.CharTimeout, rtu.FrameTimeout
+	if charTimeout <= 0 {
+		charTimeout = time.Duration(characterDelay) * time.Microsecond
+	}
+	if frameTimeout <= 0 {
+		frameTimeout = time.Duration(frameDelay) * time.Microsecond
+	}
+	return
 }
 
 /*
@@ -624,14 +1024,29 @@ func (ascii *asciiTransporter) Set(address string, baud, databits int, parity st
 	ascii.IdleTimeout = time.Duration(idletimeout) * time.Millisecond
 }
 
+// Spec decodes slave id and function code from the hexadecimal frame.
 func (ascii *asciiTransporter) Spec(aduReqRes []byte) (byte, byte) {
-	return aduReqRes[0], aduReqRes[1]
+	if len(aduReqRes) < 5 {
+		return 0, 0
+	}
+	id, _ := readHex(aduReqRes[1:])
+	function, _ := readHex(aduReqRes[3:])
+	return id, function
 }
 
+// Send sends the request and reads the response.
+// Broadcast requests (slave id 0) are not answered, aduResponse is nil for them.
 func (ascii *asciiTransporter) Send(aduRequest []byte) (aduResponse []byte, warn, err error) {
 	ascii.serialPort.mu.Lock()
 	defer ascii.serialPort.mu.Unlock()
 
+	if len(aduRequest) < asciiMinSize+6 {
+		err = fmt.Errorf("modbus: request length '%v' does not meet minimum '%v'", len(aduRequest), asciiMinSize+6)
+		return
+	}
+	if err = verifySerialAddress(ascii.Spec(aduRequest)); err != nil {
+		return
+	}
 	// Make sure port is connected
 	if err = ascii.serialPort.connect(); err != nil {
 		return
@@ -639,12 +1054,25 @@ func (ascii *asciiTransporter) Send(aduRequest []byte) (aduResponse []byte, warn
 	// Start the timer to close when idle
 	ascii.serialPort.lastActivity = time.Now()
 	ascii.serialPort.startCloseTimer()
+	// Hold the bus against other processes for the transaction
+	if err = ascii.serialPort.lockBus(); err != nil {
+		return
+	}
+	defer ascii.serialPort.unlockBus()
+	if isBroadcast(ascii.Spec(aduRequest)) {
+		ascii.serialPort.logf("modbus: broadcasting %q\n", aduRequest)
+		warn, err = ascii.serialPort.broadcast(aduRequest)
+		return
+	}
 
 	// Send the request
 	ascii.serialPort.logf("modbus: sending %q\n", aduRequest)
 	if _, err = ascii.port.Write(aduRequest); err != nil {
 		return
 	}
+	if warn = ascii.serialPort.readEcho(aduRequest); warn != nil {
+		return
+	}
 	// Get the response
 	var n int
 	var data [asciiMaxSize]byte
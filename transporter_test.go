@@ -0,0 +1,283 @@
+package modbus
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetFrameTimeoutsFixedPort(t *testing.T) {
+	mbt := connectPipe(t, "rtu", "deadline", 500, func(int) ([]byte, time.Duration) {
+		return []byte{0, 1}, 0
+	})
+	mbc := NewSClient(1, "rtu")
+	mbt.SetFrameTimeouts(0, 50*time.Millisecond)
+	rtu := mbt.ApiTransporter.(*rtuTransporter)
+	if port, ok := rtu.port.(*timeoutPort); !ok || port.timeout != 50*time.Millisecond {
+		t.Fatalf("port %T closed or read timeout not changed", rtu.port)
+	}
+	start := time.Now()
+	values, warn, err := mbc.ReadValues(mbt, TableHoldingRegisters, 0, 1)
+	if warn != nil || err != nil || !bytes.Equal(values, []byte{0, 1}) {
+		t.Fatalf("values % x, %v %v", values, warn, err)
+	}
+	// the end of the response is detected after t3.5 of silence
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("response received after %v, before t3.5", elapsed)
+	}
+	mbt.SetFrameTimeouts(0, 0)
+	if _, warn, err = mbc.ReadValues(mbt, TableHoldingRegisters, 0, 1); warn != nil || err != nil {
+		t.Errorf("%v %v", warn, err)
+	}
+	// t3.5 of 19200 baud
+	if port := rtu.port.(*timeoutPort); port.timeout != 1822*time.Microsecond {
+		t.Errorf("read timeout %v", port.timeout)
+	}
+}
+
+// rtuFrame encodes the PDU for unit 1 in RTU framing.
+func rtuFrame(t *testing.T, function byte, data ...byte) []byte {
+	t.Helper()
+	adu, err := NewSClient(1, "rtu").Encode(&ProtocolDataUnit{FunctionCode: function, Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return adu
+}
+
+func concat(parts ...[]byte) (b []byte) {
+	for _, part := range parts {
+		b = append(b, part...)
+	}
+	return
+}
+
+func TestFindRtuFrame(t *testing.T) {
+	request := rtuFrame(t, FuncCodeReadHoldingRegisters, 0, 0, 0, 2)
+	response := rtuFrame(t, FuncCodeReadHoldingRegisters, 4, 0, 1, 0, 2)
+	exception := rtuFrame(t, FuncCodeReadHoldingRegisters|0x80, ExceptionCodeIllegalDataAddress)
+	corrupt := append([]byte(nil), response...)
+	corrupt[len(corrupt)-1] ^= 0xFF
+	other := append([]byte{2}, response[1:]...)
+	writeRequest := rtuFrame(t, FuncCodeWriteSingleRegister, 0, 1, 0, 5)
+
+	tests := []struct {
+		name    string
+		request []byte
+		data    []byte
+		frame   []byte
+		dropped int
+	}{
+		{"frame", request, response, response, 0},
+		{"leading garbage", request, concat([]byte{0x00, 0xFF, 0x01}, response), response, 3},
+		{"trailing garbage", request, concat(response, []byte{0x7E}), response, 1},
+		{"echo of request", request, concat(request, response), response, len(request)},
+		{"first part of split frame", request, response[:4], nil, 0},
+		{"exception by scanning", request, concat([]byte{0x01, 0x03, 0x55}, exception), exception, 3},
+		{"corrupt crc", request, corrupt, nil, 0},
+		{"other slave", request, other, nil, 0},
+		{"echoed write", writeRequest, writeRequest, writeRequest, 0},
+		{"only echo", request, request, nil, 0},
+	}
+	for _, test := range tests {
+		frame, dropped := findRtuFrame(test.request, test.data)
+		if !bytes.Equal(frame, test.frame) || dropped != test.dropped {
+			t.Errorf("%v: frame % x dropped '%v', expected % x dropped '%v'", test.name, frame, dropped, test.frame, test.dropped)
+		}
+	}
+}
+
+// rawPipe connects a transporter of mode (rtu, ascii) to a device answering each request by respond.
+func rawPipe(t *testing.T, mode string, timeout int64, respond func(request []byte, port net.Conn)) *MBTransporter {
+	t.Helper()
+	client, device := net.Pipe()
+	go func() {
+		buf := make([]byte, asciiMaxSize)
+		for {
+			n, err := device.Read(buf)
+			if err != nil {
+				return
+			}
+			respond(buf[:n], device)
+		}
+	}()
+	mbt := NewTransporter()
+	if err := mbt.ConnectPort(mode, FixedPort(client), 19200, 8, "E", 1, timeout, 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		mbt.Close()
+		device.Close()
+	})
+	return mbt
+}
+
+func TestRtuReceiveResync(t *testing.T) {
+	response := rtuFrame(t, FuncCodeReadHoldingRegisters, 2, 0, 7)
+	exception := rtuFrame(t, FuncCodeReadHoldingRegisters|0x80, ExceptionCodeIllegalDataAddress)
+	garbage := []byte{0x00, 0xFF, 0x01}
+	tests := []struct {
+		name    string
+		chunks  [][]byte
+		values  []byte
+		code    byte
+		dropped uint64
+	}{
+		{"leading garbage", [][]byte{concat(garbage, response)}, []byte{0, 7}, 0, 3},
+		{"frame split by silence", [][]byte{garbage, response[:3], response[3:]}, []byte{0, 7}, 0, 3},
+		{"exception after garbage", [][]byte{concat(garbage, exception)}, nil, ExceptionCodeIllegalDataAddress, 3},
+		{"garbage around frame", [][]byte{garbage, concat(response, []byte{0x55})}, []byte{0, 7}, 0, 4},
+	}
+	for _, test := range tests {
+		mbt := rawPipe(t, "rtu", 500, func(_ []byte, port net.Conn) {
+			for n, chunk := range test.chunks {
+				if n > 0 {
+					// longer than t3.5 of 19200 baud
+					time.Sleep(10 * time.Millisecond)
+				}
+				port.Write(chunk)
+			}
+		})
+		values, warn, err := NewSClient(1, "rtu").ReadValues(mbt, TableHoldingRegisters, 0, 1)
+		if err != nil || exceptionOf(warn) != test.code || (test.code == 0 && (warn != nil || !bytes.Equal(values, test.values))) {
+			t.Errorf("%v: values % x, %v %v", test.name, values, warn, err)
+		}
+		if dropped := mbt.DroppedBytes(); dropped != test.dropped {
+			t.Errorf("%v: '%v' bytes dropped, expected '%v'", test.name, dropped, test.dropped)
+		}
+	}
+}
+
+func TestRtuReceiveNoFrame(t *testing.T) {
+	mbt := rawPipe(t, "rtu", 100, func(_ []byte, port net.Conn) {
+		port.Write([]byte{0x01, 0x03, 0x02, 0x00})
+	})
+	_, warn, err := NewSClient(1, "rtu").ReadValues(mbt, TableHoldingRegisters, 0, 1)
+	if warn == nil && err == nil {
+		t.Fatalf("incomplete frame accepted")
+	}
+	if dropped := mbt.DroppedBytes(); dropped != 4 {
+		t.Errorf("'%v' bytes dropped, expected 4", dropped)
+	}
+}
+
+func TestVerifySerialAddress(t *testing.T) {
+	tests := []struct {
+		id, function byte
+		valid        bool
+	}{
+		{1, FuncCodeReadHoldingRegisters, true},
+		{247, FuncCodeReadHoldingRegisters, true},
+		{0, FuncCodeWriteSingleRegister, true},
+		{0, FuncCodeWriteMultipleCoils, true},
+		{0, FuncCodeReadHoldingRegisters, false},
+	}
+	for id := 248; id <= 255; id++ {
+		tests = append(tests, struct {
+			id, function byte
+			valid        bool
+		}{byte(id), FuncCodeWriteSingleRegister, false})
+	}
+	for _, test := range tests {
+		if err := verifySerialAddress(test.id, test.function); (err == nil) != test.valid {
+			t.Errorf("id '%v' function '%v': %v", test.id, test.function, err)
+		}
+	}
+}
+
+func TestSerialReservedAddress(t *testing.T) {
+	for _, mode := range []string{"rtu", "ascii"} {
+		sent := make(chan []byte, 1)
+		mbt := rawPipe(t, mode, 100, func(request []byte, _ net.Conn) {
+			sent <- request
+		})
+		if _, _, err := NewSClient(248, mode).ReadValues(mbt, TableHoldingRegisters, 0, 1); err == nil {
+			t.Errorf("%v: request to reserved address sent", mode)
+		}
+		select {
+		case request := <-sent:
+			t.Errorf("%v: device received % x", mode, request)
+		default:
+		}
+	}
+}
+
+func TestSerialBroadcast(t *testing.T) {
+	for _, mode := range []string{"rtu", "ascii"} {
+		received := make(chan []byte, 1)
+		unread := make(chan error, 1)
+		mbt := rawPipe(t, mode, 1000, func(request []byte, port net.Conn) {
+			received <- append([]byte(nil), request...)
+			// a response is never read, the write times out
+			port.SetWriteDeadline(time.Now().Add(200 * time.Millisecond))
+			_, err := port.Write([]byte{0x01, 0x06})
+			unread <- err
+		})
+		mbt.SetTurnaroundDelay(50 * time.Millisecond)
+		start := time.Now()
+		warn, err := NewSClient(0, mode).WriteValues(mbt, TableHoldingRegisters, 1, 1, []byte{0, 5})
+		if warn != nil || err != nil {
+			t.Errorf("%v: %v %v", mode, warn, err)
+		}
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > 150*time.Millisecond {
+			t.Errorf("%v: returned after %v, expected the turnaround delay", mode, elapsed)
+		}
+		if request := <-received; len(request) == 0 {
+			t.Errorf("%v: broadcast not sent", mode)
+		}
+		if err := <-unread; err == nil {
+			t.Errorf("%v: transporter read after broadcast", mode)
+		}
+	}
+}
+
+func TestSerialEcho(t *testing.T) {
+	for _, mode := range []string{"rtu", "ascii"} {
+		mbc := NewSClient(1, mode)
+		response, err := mbc.Encode(&ProtocolDataUnit{FunctionCode: FuncCodeReadHoldingRegisters, Data: []byte{2, 0, 9}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tests := []struct {
+			name    string
+			echo    func(request []byte) []byte
+			warning string
+		}{
+			{"echo", func(request []byte) []byte { return request }, ""},
+			{"collision", func(request []byte) []byte {
+				echo := append([]byte(nil), request...)
+				echo[len(echo)-3] ^= 0x01
+				return echo
+			}, "collision"},
+			{"no echo", func([]byte) []byte { return nil }, "echo of request not received"},
+		}
+		for _, test := range tests {
+			mbt := rawPipe(t, mode, 100, func(request []byte, port net.Conn) {
+				if echo := test.echo(request); echo != nil {
+					port.Write(echo)
+					port.Write(response)
+				}
+			})
+			mbt.SetEcho(true)
+			start := time.Now()
+			values, warn, err := mbc.ReadValues(mbt, TableHoldingRegisters, 0, 1)
+			elapsed := time.Since(start)
+			if err != nil {
+				t.Errorf("%v %v: %v", mode, test.name, err)
+				continue
+			}
+			if test.warning == "" && (warn != nil || !bytes.Equal(values, []byte{0, 9})) {
+				t.Errorf("%v %v: values % x, %v", mode, test.name, values, warn)
+			}
+			if test.warning != "" && (warn == nil || !strings.Contains(warn.Error(), test.warning)) {
+				t.Errorf("%v %v: warning %v, expected %v", mode, test.name, warn, test.warning)
+			}
+			// the wait for the echo is bounded by the response timeout
+			if elapsed > 500*time.Millisecond || test.name == "no echo" && elapsed < 100*time.Millisecond {
+				t.Errorf("%v %v: returned after %v", mode, test.name, elapsed)
+			}
+		}
+	}
+}
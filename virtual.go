@@ -0,0 +1,401 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// VirtualRange maps Quantity registers or bits at Address of Table of the virtual device
+// to the device Unit on the backend transporter, framed in Mode (rtu, ascii, tcp) detected from
+// the backend if empty.
+// Registers are copied from Source.Table and Source.Address if Type is empty. Otherwise
+// the value of the Source tag (type, word order, scale) is converted to Type, WordOrder and Scale
+// of the virtual registers, Quantity is derived from Type except for strings.
+type VirtualRange struct {
+	Table    Table
+	Address  uint16
+	Quantity uint16
+	Backend  ApiSender
+	Mode     string
+	Unit     byte
+	Source   Tag
+
+	Type      DataType
+	WordOrder WordOrder
+	Scale     float64
+}
+
+func (vr *VirtualRange) end() int {
+	return int(vr.Address) + int(vr.Quantity)
+}
+
+func (vr *VirtualRange) converted() bool {
+	return vr.Type != ""
+}
+
+// tag returns the virtual registers of a converted range as tag.
+func (vr *VirtualRange) tag() *Tag {
+	return &Tag{
+		Name:      fmt.Sprintf("virtual %v:%v", vr.Table, vr.Address),
+		Table:     vr.Table,
+		Address:   vr.Address,
+		Type:      vr.Type,
+		Length:    vr.Quantity,
+		WordOrder: vr.WordOrder,
+		Scale:     vr.Scale,
+	}
+}
+
+// VirtualDevice is the Handler of one unit id with a flat register map assembled from
+// ranges of several devices, served by Server:
+//  vd := modbus.NewVirtualDevice(1)
+//  vd.Add(modbus.VirtualRange{Table: modbus.TableInputRegisters, Address: 0, Quantity: 10,
+//  	Backend: bus1, Unit: 5, Source: modbus.Tag{Table: modbus.TableInputRegisters, Address: 100}})
+//  modbus.NewServer(vd).ListenAndServe(":502")
+// Reads fan out to the backends, one goroutine per backend, and are answered with
+// illegal data address (0x02) if any address is not mapped. Writes are routed to the owning
+// devices in order of address, converted ranges must be written as a whole. Writes spanning
+// several devices are not atomic. Requests of other units are passed to Next if set.
+type VirtualDevice struct {
+	Unit   byte
+	Next   Handler
+	Logger *log.Logger
+
+	mu     sync.RWMutex
+	ranges map[Table][]*VirtualRange // sorted by address
+}
+
+func NewVirtualDevice(unit byte) *VirtualDevice {
+	return &VirtualDevice{Unit: unit, ranges: make(map[Table][]*VirtualRange)}
+}
+
+// Add checks and maps the range, ranges of a table must not overlap.
+func (vd *VirtualDevice) Add(vr VirtualRange) error {
+	if vr.Table < TableCoils || vr.Table > TableInputRegisters {
+		return fmt.Errorf("modbus: virtual range table '%v' is invalid", vr.Table)
+	}
+	if vr.Backend == nil {
+		return fmt.Errorf("modbus: virtual range %v:%v has no backend", vr.Table, vr.Address)
+	}
+	if vr.Mode == "" {
+		if vr.Mode = transporterMode(vr.Backend); vr.Mode == "" {
+			return fmt.Errorf("modbus: mode of transporter '%T' is unknown", vr.Backend)
+		}
+	}
+	vr.Mode = strings.ToLower(vr.Mode)
+	if vr.Source.Name == "" {
+		vr.Source.Name = fmt.Sprintf("source %v:%v", vr.Source.Table, vr.Source.Address)
+	}
+	if vr.converted() {
+		if vr.Source.Type == "" && !vr.Source.Table.IsBit() {
+			return fmt.Errorf("modbus: virtual range %v:%v converts source without type", vr.Table, vr.Address)
+		}
+		if err := vr.Source.Validate(); err != nil {
+			return err
+		}
+		vr.Quantity = vr.tag().Quantity()
+		if err := vr.tag().Validate(); err != nil {
+			return err
+		}
+	} else if vr.Source.Table < TableCoils || vr.Source.Table > TableInputRegisters || vr.Source.Table.IsBit() != vr.Table.IsBit() {
+		return fmt.Errorf("modbus: virtual range %v:%v copies from source table '%v'", vr.Table, vr.Address, vr.Source.Table)
+	} else if int(vr.Source.Address)+int(vr.Quantity) > 0x10000 {
+		return fmt.Errorf("modbus: virtual range %v:%v source exceeds address 65535", vr.Table, vr.Address)
+	}
+	if vr.Quantity == 0 || vr.end() > 0x10000 {
+		return fmt.Errorf("modbus: virtual range %v:%v quantity '%v' is invalid", vr.Table, vr.Address, vr.Quantity)
+	}
+	vd.mu.Lock()
+	defer vd.mu.Unlock()
+	for _, r := range vd.ranges[vr.Table] {
+		if int(vr.Address) < r.end() && int(r.Address) < vr.end() {
+			return fmt.Errorf("modbus: virtual range %v:%v overlaps range at '%v'", vr.Table, vr.Address, r.Address)
+		}
+	}
+	ranges := append(vd.ranges[vr.Table], &vr)
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Address < ranges[j].Address })
+	vd.ranges[vr.Table] = ranges
+	return nil
+}
+
+func (vd *VirtualDevice) logf(format string, v ...interface{}) {
+	if vd.Logger != nil {
+		vd.Logger.Printf(format, v...)
+	}
+}
+
+// overlapping returns the ranges covering address..address+quantity-1, ok is false if any address is not mapped.
+func (vd *VirtualDevice) overlapping(table Table, address, quantity uint16) (ranges []*VirtualRange, ok bool) {
+	vd.mu.RLock()
+	defer vd.mu.RUnlock()
+	next := int(address)
+	end := int(address) + int(quantity)
+	for _, r := range vd.ranges[table] {
+		if r.end() <= next {
+			continue
+		}
+		if int(r.Address) > next || next >= end {
+			break
+		}
+		ranges = append(ranges, r)
+		next = r.end()
+	}
+	return ranges, next >= end
+}
+
+// ServeModbus answers reads and writes of the virtual unit.
+func (vd *VirtualDevice) ServeModbus(req *Request) *ProtocolDataUnit {
+	if req.UnitID != vd.Unit {
+		if vd.Next != nil {
+			return vd.Next.ServeModbus(req)
+		}
+		return ExceptionResponse(req.PDU.FunctionCode, ExceptionCodeGatewayPathUnavailable)
+	}
+	pdu := req.PDU
+	if table, address, quantity, ok := virtualRead(pdu); ok {
+		if quantity == 0 || quantity > table.MaxRead() || int(address)+int(quantity) > 0x10000 {
+			return ExceptionResponse(pdu.FunctionCode, ExceptionCodeIllegalDataValue)
+		}
+		values, code := vd.read(table, address, quantity)
+		if code != 0 {
+			return ExceptionResponse(pdu.FunctionCode, code)
+		}
+		return &ProtocolDataUnit{FunctionCode: pdu.FunctionCode, Data: append([]byte{byte(len(values))}, values...)}
+	}
+	switch pdu.FunctionCode {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs, FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters:
+		// not taken by virtualRead, the request has wrong length
+		return ExceptionResponse(pdu.FunctionCode, ExceptionCodeIllegalDataValue)
+	case FuncCodeWriteSingleCoil, FuncCodeWriteSingleRegister, FuncCodeWriteMultipleCoils, FuncCodeWriteMultipleRegisters:
+	default:
+		return ExceptionResponse(pdu.FunctionCode, ExceptionCodeIllegalFunction)
+	}
+	table, address, quantity, values, ok := virtualWrite(pdu)
+	if !ok {
+		// malformed write, e.g. FC 5 value other than 0x0000 and 0xFF00 or wrong byte count
+		return ExceptionResponse(pdu.FunctionCode, ExceptionCodeIllegalDataValue)
+	}
+	if quantity == 0 || int(address)+int(quantity) > 0x10000 {
+		return ExceptionResponse(pdu.FunctionCode, ExceptionCodeIllegalDataValue)
+	}
+	if code := vd.write(table, address, quantity, values); code != 0 {
+		return ExceptionResponse(pdu.FunctionCode, code)
+	}
+	if req.UnitID == 0 {
+		return nil
+	}
+	response := &ProtocolDataUnit{FunctionCode: pdu.FunctionCode, Data: pdu.Data}
+	if len(pdu.Data) > 4 {
+		response.Data = pdu.Data[:4]
+	}
+	return response
+}
+
+// read assembles the values of the virtual registers or bits, code is the exception code on failure.
+func (vd *VirtualDevice) read(table Table, address, quantity uint16) (values []byte, code byte) {
+	ranges, ok := vd.overlapping(table, address, quantity)
+	if !ok {
+		return nil, ExceptionCodeIllegalDataAddress
+	}
+	// points of the ranges grouped by backend, a goroutine per backend
+	type job struct {
+		r     *VirtualRange
+		point Point
+		data  []byte
+	}
+	backends := make(map[ApiSender][]*job)
+	var order []ApiSender
+	jobs := make([]*job, len(ranges))
+	for n, r := range ranges {
+		j := &job{r: r}
+		if r.converted() {
+			j.point = r.Source.Point()
+		} else {
+			first, last := maxInt(int(address), int(r.Address)), minInt(int(address)+int(quantity), r.end())
+			j.point = Point{Table: r.Source.Table, Address: r.Source.Address + uint16(first-int(r.Address)), Length: uint16(last - first)}
+		}
+		if _, ok := backends[r.Backend]; !ok {
+			order = append(order, r.Backend)
+		}
+		backends[r.Backend] = append(backends[r.Backend], j)
+		jobs[n] = j
+	}
+	codes := make([]byte, len(order))
+	var wg sync.WaitGroup
+	for n, backend := range order {
+		wg.Add(1)
+		go func(n int, backend ApiSender, jobs []*job) {
+			defer wg.Done()
+			for _, j := range jobs {
+				mbc := NewSClient(j.r.Unit, j.r.Mode)
+				data, warn, err := mbc.ReadValues(backend, j.point.Table, j.point.Address, j.point.Length)
+				if codes[n] = vd.exceptionCode(j.r, warn, err); codes[n] != 0 {
+					return
+				}
+				j.data = data
+			}
+		}(n, backend, backends[backend])
+	}
+	wg.Wait()
+	for _, c := range codes {
+		if c != 0 {
+			return nil, c
+		}
+	}
+	if table.IsBit() {
+		values = make([]byte, (int(quantity)+7)/8)
+	} else {
+		values = make([]byte, int(quantity)*2)
+	}
+	for _, j := range jobs {
+		r, data := j.r, j.data
+		first, last := maxInt(int(address), int(r.Address)), minInt(int(address)+int(quantity), r.end())
+		offset := 0
+		if r.converted() {
+			value, err := r.Source.Decode(data)
+			if err == nil {
+				data, err = r.tag().Encode(value)
+			}
+			if err != nil {
+				vd.logf("modbus: virtual unit '%v' range %v:%v: %v", vd.Unit, r.Table, r.Address, err)
+				return nil, ExceptionCodeServerDeviceFailure
+			}
+			offset = first - int(r.Address)
+		}
+		if table.IsBit() {
+			copyBits(values, first-int(address), data, offset, last-first)
+		} else {
+			copy(values[(first-int(address))*2:], data[offset*2:(offset+last-first)*2])
+		}
+	}
+	return values, 0
+}
+
+// write routes the values to the owning devices, code is the exception code on failure.
+func (vd *VirtualDevice) write(table Table, address, quantity uint16, values []byte) (code byte) {
+	ranges, ok := vd.overlapping(table, address, quantity)
+	if !ok {
+		return ExceptionCodeIllegalDataAddress
+	}
+	for _, r := range ranges {
+		first, last := maxInt(int(address), int(r.Address)), minInt(int(address)+int(quantity), r.end())
+		var data []byte
+		if table.IsBit() {
+			data = make([]byte, (last-first+7)/8)
+			copyBits(data, 0, values, first-int(address), last-first)
+		} else {
+			data = values[(first-int(address))*2 : (last-int(address))*2]
+		}
+		target := Point{Table: r.Source.Table, Address: r.Source.Address + uint16(first-int(r.Address)), Length: uint16(last - first)}
+		if r.converted() {
+			if first != int(r.Address) || last != r.end() {
+				return ExceptionCodeIllegalDataAddress
+			}
+			value, err := r.tag().Decode(data)
+			if err == nil {
+				data, err = r.Source.Encode(value)
+			}
+			if err != nil {
+				vd.logf("modbus: virtual unit '%v' range %v:%v: %v", vd.Unit, r.Table, r.Address, err)
+				return ExceptionCodeIllegalDataValue
+			}
+			target = r.Source.Point()
+		}
+		if !target.Table.IsWritable() {
+			return ExceptionCodeIllegalDataAddress
+		}
+		mbc := NewSClient(r.Unit, r.Mode)
+		warn, err := mbc.WriteValues(r.Backend, target.Table, target.Address, target.Length, data)
+		if code = vd.exceptionCode(r, warn, err); code != 0 {
+			return
+		}
+	}
+	return 0
+}
+
+// exceptionCode maps the result of a backend request to the exception code, zero on success.
+// Exceptions of the devices are passed through.
+func (vd *VirtualDevice) exceptionCode(r *VirtualRange, warn, err error) byte {
+	if err == nil && warn == nil {
+		return 0
+	}
+	if me, ok := warn.(*ModbusError); ok {
+		return me.ExceptionCode
+	}
+	if err != nil {
+		vd.logf("modbus: virtual unit '%v' backend unit '%v': %v", vd.Unit, r.Unit, err)
+		return ExceptionCodeGatewayPathUnavailable
+	}
+	vd.logf("modbus: virtual unit '%v' backend unit '%v': %v", vd.Unit, r.Unit, warn)
+	return ExceptionCodeGatewayTargetDeviceFailedToRespond
+}
+
+// virtualRead returns the range of read requests FC 1-4.
+func virtualRead(pdu *ProtocolDataUnit) (table Table, address, quantity uint16, ok bool) {
+	if len(pdu.Data) != 4 {
+		return
+	}
+	switch pdu.FunctionCode {
+	case FuncCodeReadCoils:
+		table = TableCoils
+	case FuncCodeReadDiscreteInputs:
+		table = TableDiscreteInputs
+	case FuncCodeReadHoldingRegisters:
+		table = TableHoldingRegisters
+	case FuncCodeReadInputRegisters:
+		table = TableInputRegisters
+	default:
+		return
+	}
+	return table, binary.BigEndian.Uint16(pdu.Data), binary.BigEndian.Uint16(pdu.Data[2:]), true
+}
+
+// virtualWrite returns the range and the values of write requests FC 5, 6, 15, 16,
+// registers as big endian bytes and bits packed LSB first.
+func virtualWrite(pdu *ProtocolDataUnit) (table Table, address, quantity uint16, values []byte, ok bool) {
+	data := pdu.Data
+	if len(data) < 4 {
+		return
+	}
+	address = binary.BigEndian.Uint16(data)
+	switch pdu.FunctionCode {
+	case FuncCodeWriteSingleCoil:
+		v := binary.BigEndian.Uint16(data[2:])
+		if v != 0xFF00 && v != 0x0000 {
+			return
+		}
+		return TableCoils, address, 1, []byte{byte(v >> 15)}, true
+	case FuncCodeWriteSingleRegister:
+		return TableHoldingRegisters, address, 1, data[2:4], true
+	case FuncCodeWriteMultipleCoils, FuncCodeWriteMultipleRegisters:
+		quantity = binary.BigEndian.Uint16(data[2:])
+		table = TableHoldingRegisters
+		count := int(quantity) * 2
+		if pdu.FunctionCode == FuncCodeWriteMultipleCoils {
+			table, count = TableCoils, (int(quantity)+7)/8
+		}
+		if len(data) != 5+count || int(data[4]) != count {
+			return
+		}
+		return table, address, quantity, data[5:], true
+	}
+	return
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
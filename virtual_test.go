@@ -0,0 +1,108 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// testVirtualDevice maps holding registers 0-3 to 10-13 of a and 4-5 to 0-1 of b.
+func testVirtualDevice(t *testing.T) (vd *VirtualDevice, a, b *registerDevice) {
+	t.Helper()
+	a, b = &registerDevice{}, &registerDevice{}
+	for n := range a.registers {
+		a.registers[n] = uint16(0xA000 + n)
+		b.registers[n] = uint16(0xB000 + n)
+	}
+	vd = NewVirtualDevice(1)
+	ranges := []VirtualRange{
+		{Table: TableHoldingRegisters, Address: 0, Quantity: 4, Backend: a, Mode: "rtu", Unit: 5,
+			Source: Tag{Table: TableHoldingRegisters, Address: 10}},
+		{Table: TableHoldingRegisters, Address: 4, Quantity: 2, Backend: b, Mode: "rtu", Unit: 6,
+			Source: Tag{Table: TableHoldingRegisters, Address: 0}},
+	}
+	for _, vr := range ranges {
+		if err := vd.Add(vr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return
+}
+
+func virtualRequest(unit, function byte, data ...byte) *Request {
+	return &Request{UnitID: unit, PDU: &ProtocolDataUnit{FunctionCode: function, Data: data}}
+}
+
+func TestVirtualDeviceRead(t *testing.T) {
+	vd, a, b := testVirtualDevice(t)
+	tests := []struct {
+		name      string
+		request   *Request
+		exception byte
+		values    []uint16
+	}{
+		{"one device", virtualRequest(1, FuncCodeReadHoldingRegisters, 0, 1, 0, 2), 0, []uint16{0xA00B, 0xA00C}},
+		{"fan out", virtualRequest(1, FuncCodeReadHoldingRegisters, 0, 2, 0, 4), 0, []uint16{0xA00C, 0xA00D, 0xB000, 0xB001}},
+		{"not mapped", virtualRequest(1, FuncCodeReadHoldingRegisters, 0, 5, 0, 2), ExceptionCodeIllegalDataAddress, nil},
+		{"other table", virtualRequest(1, FuncCodeReadInputRegisters, 0, 0, 0, 1), ExceptionCodeIllegalDataAddress, nil},
+		{"wrong length", virtualRequest(1, FuncCodeReadHoldingRegisters, 0, 0, 0, 1, 0), ExceptionCodeIllegalDataValue, nil},
+		{"zero quantity", virtualRequest(1, FuncCodeReadHoldingRegisters, 0, 0, 0, 0), ExceptionCodeIllegalDataValue, nil},
+		{"other unit", virtualRequest(2, FuncCodeReadHoldingRegisters, 0, 0, 0, 1), ExceptionCodeGatewayPathUnavailable, nil},
+	}
+	for _, test := range tests {
+		response := vd.ServeModbus(test.request)
+		if test.exception != 0 {
+			if response.FunctionCode != test.request.PDU.FunctionCode|0x80 || response.Data[0] != test.exception {
+				t.Errorf("%v: response %+v, exception '%v' expected", test.name, response, test.exception)
+			}
+			continue
+		}
+		if response.FunctionCode != test.request.PDU.FunctionCode || int(response.Data[0]) != 2*len(test.values) {
+			t.Errorf("%v: response %+v", test.name, response)
+			continue
+		}
+		for n, value := range test.values {
+			if got := binary.BigEndian.Uint16(response.Data[1+2*n:]); got != value {
+				t.Errorf("%v: register %v is %04X, %04X expected", test.name, n, got, value)
+			}
+		}
+	}
+	// one request of each backend per read, also when fanned out
+	if a.reads != 2 || b.reads != 1 {
+		t.Errorf("backend reads %v and %v", a.reads, b.reads)
+	}
+}
+
+func TestVirtualDeviceWrite(t *testing.T) {
+	vd, a, b := testVirtualDevice(t)
+	tests := []struct {
+		name      string
+		request   *Request
+		exception byte
+		a, b      int // writes of the devices
+	}{
+		{"single register of b", virtualRequest(1, FuncCodeWriteSingleRegister, 0, 5, 0x12, 0x34), 0, 0, 1},
+		{"spanning both", virtualRequest(1, FuncCodeWriteMultipleRegisters, 0, 3, 0, 2, 4, 0x56, 0x78, 0x9A, 0xBC), 0, 1, 2},
+		{"not mapped", virtualRequest(1, FuncCodeWriteSingleRegister, 0, 6, 0, 1), ExceptionCodeIllegalDataAddress, 1, 2},
+		{"wrong byte count", virtualRequest(1, FuncCodeWriteMultipleRegisters, 0, 0, 0, 1, 4, 0, 1), ExceptionCodeIllegalDataValue, 1, 2},
+		{"unsupported function", virtualRequest(1, FuncCodeReadWriteMultipleRegisters, 0, 0), ExceptionCodeIllegalFunction, 1, 2},
+	}
+	for _, test := range tests {
+		response := vd.ServeModbus(test.request)
+		switch {
+		case test.exception != 0 && (response.FunctionCode != test.request.PDU.FunctionCode|0x80 || response.Data[0] != test.exception):
+			t.Errorf("%v: response %+v, exception '%v' expected", test.name, response, test.exception)
+		case test.exception == 0 && (response.FunctionCode != test.request.PDU.FunctionCode || len(response.Data) != 4):
+			t.Errorf("%v: response %+v", test.name, response)
+		}
+		if a.writes != test.a || b.writes != test.b {
+			t.Errorf("%v: backend writes %v and %v", test.name, a.writes, b.writes)
+		}
+	}
+	if a.registers[13] != 0x5678 || b.registers[0] != 0x9ABC || b.registers[1] != 0x1234 {
+		t.Errorf("registers % X and % X", a.registers[10:14], b.registers[:2])
+	}
+	// the neighbours of the written registers are unchanged
+	if a.registers[12] != 0xA00C || b.registers[2] != 0xB002 {
+		t.Errorf("registers % X and % X", a.registers[10:14], b.registers[:3])
+	}
+}